@@ -18,9 +18,10 @@ package main
 
 
 import (
-	"fmt"
 	"strconv"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -39,28 +40,47 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
 	var Aval, Bval int // Asset holdings
 	var err error
 
+	if len(args) != 4 {
+		return errcode.InvalidArgument("Incorrect number of arguments. Expecting 4").Response()
+	}
+
+	// The accounts belong to the identity that instantiates the chaincode
+	owner, err := invokerID(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
 	// Initialize the chaincode
 	A = args[0]
 	Aval, err = strconv.Atoi(args[1])
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return errcode.InvalidArgument("Expecting integer value for asset holding").Response()
 	}
 	B = args[2]
 	Bval, err = strconv.Atoi(args[3])
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return errcode.InvalidArgument("Expecting integer value for asset holding").Response()
 	}
 	logger.Info("Aval = %d, Bval = %d\n", Aval, Bval)
 
 	// Write the state to the ledger
 	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
+	}
+
+	err = putAccount(stub, account{Name: A, Owner: owner})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putAccount(stub, account{Name: B, Owner: owner})
+	if err != nil {
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
@@ -72,65 +92,82 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response  {
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	logger.Info("########### example_cc0 Invoke ###########")
 
-	function, args := stub.GetFunctionAndParameters()
-	
-	if function == "delete" {
-		// Deletes an entity from its state
-		return t.delete(stub, args)
+	response := routes.Handle(stub)
+	if response.Status != shim.OK {
+		logger.Errorf("Invoke failed: %s", response.Message)
 	}
+	return response
+}
 
-	if function == "query" {
-		// queries an entity state
-		return t.query(stub, args)
-	}
-	if function == "move" {
-		// Deletes an entity from its state
-		return t.move(stub, args)
-	}
+// routes lists the functions of the chaincode and their parameters
+var routes = new(SimpleChaincode).buildRoutes()
 
-	logger.Errorf("Unknown action, check the first argument, must be one of 'delete', 'query', or 'move'. But got: %v", args[0])
-	return shim.Error(fmt.Sprintf("Unknown action, check the first argument, must be one of 'delete', 'query', or 'move'. But got: %v", args[0]))
+func (t *SimpleChaincode) buildRoutes() *router.Router {
+	return router.New("example_cc", "1.0").
+		// Opens an account owned by the invoker
+		Add("openAccount", "Opens an account with a zero balance owned by the invoker", t.openAccount,
+			router.Required("name", router.String)).
+		// Lists the accounts with their owners and balances
+		Add("listAccounts", "Lists all accounts with their owners and balances", t.listAccounts).
+		// Deletes an entity from its state
+		Add("delete", "Deletes an account owned by the invoker", t.delete,
+			router.Required("name", router.String)).
+		// queries an entity state
+		Add("query", "Returns the holding of an entity", t.query,
+			router.Required("name", router.String)).
+		// Transaction makes payment of X units from A to B
+		Add("move", "Moves an amount from an account owned by the invoker to another", t.move,
+			router.Required("from", router.String),
+			router.Required("to", router.String),
+			router.Required("amount", router.Int))
 }
 
-func (t *SimpleChaincode) move(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) move(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 	// must be an invoke
-	var A, B string    // Entities
 	var Aval, Bval int // Asset holdings
-	var X int          // Transaction value
-	var err error
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 4, function followed by 2 names and 1 value")
+	A := args.String("from") // Entities
+	B := args.String("to")
+	X := args.Int("amount") // Transaction value
+	if X <= 0 {
+		return errcode.InvalidArgument("Amount must be a positive integer").With("amount", X).Response()
+	}
+	// Writes are not visible to reads in the same transaction, so moving
+	// funds to the same account would credit it without the debit
+	if A == B {
+		return errcode.InvalidArgument("Cannot move funds from an account to itself").With("name", A).Response()
 	}
 
-	A = args[0]
-	B = args[1]
+	// Only the owner can move funds out of an account
+	err := assertOwner(stub, A)
+	if err != nil {
+		return errcode.Response(err)
+	}
 
 	// Get the state from the ledger
 	// TODO: will be nice to have a GetAllState call to ledger
 	Avalbytes, err := stub.GetState(A)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errcode.Internal("Failed to get state").Response()
 	}
 	if Avalbytes == nil {
-		return shim.Error("Entity not found")
+		return errcode.NotFound("Entity not found").With("name", A).Response()
 	}
 	Aval, _ = strconv.Atoi(string(Avalbytes))
+	if Aval < X {
+		return errcode.Conflict("Insufficient funds in %s", A).With("name", A).With("balance", Aval).With("amount", X).Response()
+	}
 
 	Bvalbytes, err := stub.GetState(B)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errcode.Internal("Failed to get state").Response()
 	}
 	if Bvalbytes == nil {
-		return shim.Error("Entity not found")
+		return errcode.NotFound("Entity not found").With("name", B).Response()
 	}
 	Bval, _ = strconv.Atoi(string(Bvalbytes))
 
 	// Perform the execution
-	X, err = strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
-	}
 	Aval = Aval - X
 	Bval = Bval + X
 	logger.Infof("Aval = %d, Bval = %d\n", Aval, Bval)
@@ -138,56 +175,53 @@ func (t *SimpleChaincode) move(stub shim.ChaincodeStubInterface, args []string)
 	// Write the state back to the ledger
 	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
         return shim.Success(nil);
 }
 
 // Deletes an entity from state
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
+func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	A := args.String("name")
 
-	A := args[0]
+	// Only the owner can delete an account
+	err := assertOwner(stub, A)
+	if err != nil {
+		return errcode.Response(err)
+	}
 
 	// Delete the key from the state in ledger
-	err := stub.DelState(A)
+	err = stub.DelState(A)
 	if err != nil {
-		return shim.Error("Failed to delete state")
+		return errcode.Internal("Failed to delete state").Response()
+	}
+	err = delAccount(stub, A)
+	if err != nil {
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
 }
 
 // Query callback representing the query of a chaincode
-func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	var A string // Entities
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
-	}
-
-	A = args[0]
+	A := args.String("name") // Entities
 
 	// Get the state from the ledger
 	Avalbytes, err := stub.GetState(A)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", A).With("name", A).Response()
 	}
 
 	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Nil amount for %s", A).With("name", A).Response()
 	}
 
 	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
@@ -0,0 +1,212 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Every account belongs to the user that opened it. The balance of an
+// account is stored under its name, as before, and the registry entry under
+// the composite key (account, name):
+//
+//	{"name":"alice","owner":"Org1MSP::alice"}
+//
+// The owner is the MSP ID and the enrollment ID of the user, as registered
+// by the balance-transfer REST app. Only the owner can move funds out of an
+// account or delete it. The accounts created by Init belong to the identity
+// that instantiated the chaincode.
+const (
+	accountObjectType = "account"
+
+	// enrollmentIDAttribute is added to every certificate issued by the
+	// Fabric CA
+	enrollmentIDAttribute = "hf.EnrollmentID"
+)
+
+// account is a registry entry
+type account struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+// accountWithBalance is an account as returned by listAccounts
+type accountWithBalance struct {
+	account
+	Balance int `json:"balance"`
+}
+
+// openAccount opens an account with a zero balance owned by the invoker
+func (t *SimpleChaincode) openAccount(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	name := args.String("name")
+
+	owner, err := invokerID(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	balanceAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return errcode.Internal("Failed to get state for %s", name).With("name", name).Response()
+	}
+	if balanceAsBytes != nil {
+		return errcode.AlreadyExists("Account already exists: %s", name).With("name", name).Response()
+	}
+
+	err = stub.PutState(name, []byte(strconv.Itoa(0)))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	acct := account{Name: name, Owner: owner}
+	err = putAccount(stub, acct)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	logger.Infof("Opened account %s for %s", name, owner)
+
+	accountJSONasBytes, err := json.Marshal(acct)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(accountJSONasBytes)
+}
+
+// listAccounts returns every account in the registry with its owner and
+// balance
+func (t *SimpleChaincode) listAccounts(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	iterator, err := stub.GetStateByPartialCompositeKey(accountObjectType, []string{})
+	if err != nil {
+		return errcode.Internal("Failed to read accounts: %s", err).Response()
+	}
+	defer iterator.Close()
+
+	accounts := []accountWithBalance{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errcode.Internal("Failed to read accounts: %s", err).Response()
+		}
+		var acct account
+		err = json.Unmarshal(kv.Value, &acct)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		balance, err := getBalance(stub, acct.Name)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		accounts = append(accounts, accountWithBalance{account: acct, Balance: balance})
+	}
+
+	accountsJSONasBytes, err := json.Marshal(accounts)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(accountsJSONasBytes)
+}
+
+// assertOwner returns an error unless the invoker owns the account. Accounts
+// that are not in the registry have no owner and cannot be changed.
+func assertOwner(stub shim.ChaincodeStubInterface, name string) error {
+	acct, err := getAccount(stub, name)
+	if err != nil {
+		return err
+	}
+	invoker, err := invokerID(stub)
+	if err != nil {
+		return err
+	}
+	if acct == nil || acct.Owner != invoker {
+		return errcode.Unauthorized("Account %s is not owned by %s", name, invoker).With("name", name).With("invoker", invoker)
+	}
+	return nil
+}
+
+// getAccount returns the registry entry of an account, or nil if there is
+// none
+func getAccount(stub shim.ChaincodeStubInterface, name string) (*account, error) {
+	accountKey, err := stub.CreateCompositeKey(accountObjectType, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	accountAsBytes, err := stub.GetState(accountKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get account %s: %s", name, err)
+	}
+	if accountAsBytes == nil {
+		return nil, nil
+	}
+	acct := &account{}
+	err = json.Unmarshal(accountAsBytes, acct)
+	if err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
+
+// putAccount writes the registry entry of an account
+func putAccount(stub shim.ChaincodeStubInterface, acct account) error {
+	accountKey, err := stub.CreateCompositeKey(accountObjectType, []string{acct.Name})
+	if err != nil {
+		return err
+	}
+	accountAsBytes, err := json.Marshal(acct)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(accountKey, accountAsBytes)
+}
+
+// delAccount deletes the registry entry of an account
+func delAccount(stub shim.ChaincodeStubInterface, name string) error {
+	accountKey, err := stub.CreateCompositeKey(accountObjectType, []string{name})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(accountKey)
+}
+
+// getBalance returns the balance of an account
+func getBalance(stub shim.ChaincodeStubInterface, name string) (int, error) {
+	balanceAsBytes, err := stub.GetState(name)
+	if err != nil {
+		return 0, errcode.Internal("Failed to get state for %s", name).With("name", name)
+	}
+	if balanceAsBytes == nil {
+		return 0, errcode.NotFound("Entity not found").With("name", name)
+	}
+	return strconv.Atoi(string(balanceAsBytes))
+}
+
+// invokerID returns the MSP ID and enrollment ID of the invoker as
+// MSPID::enrollmentID. Certificates without the enrollment ID attribute fall
+// back to the common name, which the Fabric CA sets to the enrollment ID too.
+func invokerID(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err)
+	}
+	id, found, err := cid.GetAttributeValue(stub, enrollmentIDAttribute)
+	if err != nil {
+		return "", errcode.Unauthorized("Failed to get the enrollment ID of the invoker: %s", err)
+	}
+	if !found {
+		cert, err := cid.GetX509Certificate(stub)
+		if err != nil {
+			return "", errcode.Unauthorized("Failed to get the certificate of the invoker: %s", err)
+		}
+		id = cert.Subject.CommonName
+	}
+	if len(id) == 0 {
+		return "", errcode.Unauthorized("The invoker has no enrollment ID")
+	}
+	return mspID + "::" + id, nil
+}
@@ -0,0 +1,84 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var (
+	alice = cctest.NewClient("Org1MSP", "alice")
+	bob   = cctest.NewClient("Org2MSP", "bob")
+	eve   = cctest.NewClient("Org2MSP", "alice")
+)
+
+// newStub returns a stub whose accounts a and b belong to alice
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("example_cc", new(SimpleChaincode))
+	if res := stub.As(alice).Init("init", "a", "100", "b", "200"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+func assertBalance(t *testing.T, stub *cctest.Stub, name, balance string) {
+	if got := string(cctest.Invoke(t, stub, "query", name)); got != balance {
+		t.Errorf("%s holds %s, want %s", name, got, balance)
+	}
+}
+
+func TestInitArguments(t *testing.T) {
+	stub := cctest.NewStub("example_cc", new(SimpleChaincode)).As(alice)
+	cctest.AssertCode(t, stub.Init("init", "a", "100", "b"), errcode.CodeInvalidArgument, "Init with three arguments")
+	cctest.AssertCode(t, stub.Init("init", "a", "many", "b", "200"), errcode.CodeInvalidArgument, "Init with a holding that is no integer")
+}
+
+func TestOnlyTheOwnerMovesFunds(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.Invoke(t, stub.As(alice), "move", "a", "b", "10")
+	assertBalance(t, stub, "a", "90")
+	assertBalance(t, stub, "b", "210")
+
+	cctest.AssertCode(t, stub.As(bob).Invoke("move", "b", "a", "10"), errcode.CodeUnauthorized, "bob moving funds of alice")
+	cctest.AssertCode(t, stub.As(eve).Invoke("move", "b", "a", "10"), errcode.CodeUnauthorized, "an alice of another MSP moving funds of alice")
+	cctest.AssertCode(t, stub.As(alice).Invoke("move", "a", "b", "0"), errcode.CodeInvalidArgument, "moving nothing")
+	cctest.AssertCode(t, stub.Invoke("move", "a", "b", "1000"), errcode.CodeConflict, "moving more than the balance")
+	cctest.AssertCode(t, stub.Invoke("move", "a", "a", "10"), errcode.CodeInvalidArgument, "moving funds to the same account")
+	assertBalance(t, stub, "a", "90")
+
+	cctest.AssertCode(t, stub.As(bob).Invoke("delete", "a"), errcode.CodeUnauthorized, "bob deleting an account of alice")
+	cctest.Invoke(t, stub.As(alice), "delete", "a")
+	cctest.AssertCode(t, stub.Invoke("query", "a"), errcode.CodeNotFound, "querying a deleted account")
+}
+
+func TestOpenAccount(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.Invoke(t, stub.As(bob), "openAccount", "c")
+	cctest.AssertCode(t, stub.Invoke("openAccount", "a"), errcode.CodeAlreadyExists, "opening an existing account")
+	cctest.Invoke(t, stub.As(alice), "move", "a", "c", "25")
+	cctest.Invoke(t, stub.As(bob), "move", "c", "b", "5")
+	assertBalance(t, stub, "c", "20")
+
+	accounts := []accountWithBalance{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "listAccounts"), &accounts); err != nil {
+		t.Fatal(err)
+	}
+	owners := map[string]string{"a": "Org1MSP::alice", "b": "Org1MSP::alice", "c": "Org2MSP::bob"}
+	if len(accounts) != len(owners) {
+		t.Fatalf("listed %+v", accounts)
+	}
+	for _, acct := range accounts {
+		if acct.Owner != owners[acct.Name] {
+			t.Errorf("%s is owned by %s, want %s", acct.Name, acct.Owner, owners[acct.Name])
+		}
+	}
+}
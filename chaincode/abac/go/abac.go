@@ -19,8 +19,14 @@ package main
 import (
 	"fmt"
 	"strconv"
-
-	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/approval"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/archive"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/roles"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -29,7 +35,20 @@ import (
 type SimpleChaincode struct {
 }
 
-// Init initializes the chaincode
+// archivistRole is the ledger role allowed to restore and purge soft deleted
+// entities, along with clients whose certificate has abac.archivist=true
+const archivistRole = "archivist"
+
+// identities gets the clients of abac. The MSPs of the samples use the
+// default NodeOU identifiers; add WithNodeOUs for MSPs configured otherwise.
+var identities = identity.New(identity.DefaultNodeOUs)
+
+// registry holds the ledger roles of abac. Admins, i.e. clients with the
+// NodeOU admin role in an admin MSP, at first the instantiator's, or the ledger
+// admin role, grant and revoke them.
+var registry = roles.New().Identities(identities).
+	Include(roles.AdminRole, archivistRole)
+
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 	fmt.Println("abac Init")
@@ -39,9 +58,9 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	// to see if the caller has the "abac.init" attribute with a value of true;
 	// if not, return an error.
 	//
-	err := cid.AssertAttributeValue(stub, "abac.init", "true")
+	err := identities.AssertAttributeValue(stub, "abac.init", "true")
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Unauthorized("Not allowed to instantiate: %s", err).With("attribute", "abac.init").Response()
 	}
 
 	_, args := stub.GetFunctionAndParameters()
@@ -49,31 +68,37 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	var Aval, Bval int // Asset holdings
 
 	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+		return errcode.InvalidArgument("Incorrect number of arguments. Expecting 4").Response()
 	}
 
 	// Initialize the chaincode
 	A = args[0]
 	Aval, err = strconv.Atoi(args[1])
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return errcode.InvalidArgument("Expecting integer value for asset holding").Response()
 	}
 	B = args[2]
 	Bval, err = strconv.Atoi(args[3])
 	if err != nil {
-		return shim.Error("Expecting integer value for asset holding")
+		return errcode.InvalidArgument("Expecting integer value for asset holding").Response()
 	}
 	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
 
+	// The instantiator's MSP is the admin MSP, see package roles
+	err = registry.Init(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
 	// Write the state to the ledger
 	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
@@ -81,60 +106,88 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("abac Invoke")
-	function, args := stub.GetFunctionAndParameters()
-	if function == "invoke" {
+
+	// Reject revoked clients, see abac_revocation.go, and, as every check
+	// made through identities does, certificates that are not valid at the
+	// transaction timestamp
+	err := identities.AssertNotRevoked(stub)
+	if err != nil {
+		return errcode.Wrap(err, "Not allowed to invoke").Response()
+	}
+
+	return routes.Handle(stub)
+}
+
+// routes lists the functions of the chaincode and their parameters
+var routes = new(SimpleChaincode).buildRoutes()
+
+func (t *SimpleChaincode) buildRoutes() *router.Router {
+	return approvals.AddRoutes(registry.AddRoutes(router.New("abac", "1.0").
 		// Make payment of X units from A to B
-		return t.invoke(stub, args)
-	} else if function == "delete" {
+		Add("invoke", "Moves an amount from one entity to another", t.invoke,
+			router.Required("from", router.String),
+			router.Required("to", router.String),
+			router.Required("amount", router.Int)).
 		// Deletes an entity from its state
-		return t.delete(stub, args)
-	} else if function == "query" {
+		Add("delete", "Deletes an entity, optionally keeping it in the archive; admins only", t.delete,
+			router.Required("name", router.String),
+			router.Optional("mode", router.String).Describe("hard (default) or soft"),
+			router.Optional("reason", router.String)).
+		Add("proposeDelete", "Proposes to delete an entity, which happens once enough members approve",
+			approvals.Proposer(approval.DeleteOperation),
+			router.Required("name", router.String),
+			router.Optional("mode", router.String).Describe("hard (default) or soft"),
+			router.Optional("reason", router.String)).
 		// the old "Query" is now implemtned in invoke
-		return t.query(stub, args)
-	}
-
-	return shim.Error("Invalid invoke function name. Expecting \"invoke\" \"delete\" \"query\"")
+		Add("query", "Returns the holding of an entity", t.query,
+			router.Required("name", router.String)).
+		Add("restore", "Restores a soft deleted entity; archivists only",
+			registry.Require("abac.archivist", archivistRole, t.restore),
+			router.Required("name", router.String)).
+		Add("purgeArchived", "Permanently removes a soft deleted entity; archivists only",
+			registry.Require("abac.archivist", archivistRole, t.purgeArchived),
+			router.Required("name", router.String)).
+		Add("revoke", "Denies access to a certificate or an enrollment ID; admins only", t.revoke,
+			router.Required("kind", router.String).Describe("serial or enrollmentID"),
+			router.Required("mspId", router.String),
+			router.Required("value", router.String).Describe("hex serial number or enrollment ID"),
+			router.Optional("reason", router.String)).
+		Add("unrevoke", "Restores access to a revoked certificate or enrollment ID; admins only", t.unrevoke,
+			router.Required("kind", router.String).Describe("serial or enrollmentID"),
+			router.Required("mspId", router.String),
+			router.Required("value", router.String)).
+		Add("listRevoked", "Lists the revoked certificates and enrollment IDs", t.listRevoked)))
 }
 
 // Transaction makes payment of X units from A to B
-func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A, B string    // Entities
+func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 	var Aval, Bval int // Asset holdings
-	var X int          // Transaction value
-	var err error
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
-	}
-
-	A = args[0]
-	B = args[1]
+	A := args.String("from") // Entities
+	B := args.String("to")
 
 	// Get the state from the ledger
 	// TODO: will be nice to have a GetAllState call to ledger
 	Avalbytes, err := stub.GetState(A)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errcode.Internal("Failed to get state").Response()
 	}
 	if Avalbytes == nil {
-		return shim.Error("Entity not found")
+		return errcode.NotFound("Entity not found").With("name", A).Response()
 	}
 	Aval, _ = strconv.Atoi(string(Avalbytes))
 
 	Bvalbytes, err := stub.GetState(B)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errcode.Internal("Failed to get state").Response()
 	}
 	if Bvalbytes == nil {
-		return shim.Error("Entity not found")
+		return errcode.NotFound("Entity not found").With("name", B).Response()
 	}
 	Bval, _ = strconv.Atoi(string(Bvalbytes))
 
 	// Perform the execution
-	X, err = strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
-	}
+	X := args.Int("amount") // Transaction value
 	Aval = Aval - X
 	Bval = Bval + X
 	fmt.Printf("Aval = %d, Bval = %d\n", Aval, Bval)
@@ -142,55 +195,74 @@ func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string
 	// Write the state back to the ledger
 	err = stub.PutState(A, []byte(strconv.Itoa(Aval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	err = stub.PutState(B, []byte(strconv.Itoa(Bval)))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
 }
 
-// Deletes an entity from state
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// Deletes an entity from state. The optional mode is "hard" (the default) or "soft";
+// a soft delete keeps the entity in the archive, from where it can be restored.
+// Only admins may delete entities directly, others go through proposeDelete.
+func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	err := registry.AssertAdmin(stub)
+	if err != nil {
+		return errcode.Wrap(err, "Not allowed to delete").Response()
+	}
+	err = archive.Delete(stub, args.String("name"), args.String("mode"), args.String("reason"))
+	if err != nil {
+		return errcode.Response(err)
 	}
 
-	A := args[0]
+	return shim.Success(nil)
+}
 
-	// Delete the key from the state in ledger
-	err := stub.DelState(A)
+// Restores a soft deleted entity from the archive
+func (t *SimpleChaincode) restore(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	err := archive.Restore(stub, args.String("name"))
 	if err != nil {
-		return shim.Error("Failed to delete state")
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
 }
 
-// query callback representing the query of a chaincode
-func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var A string // Entities
-	var err error
+// Permanently removes a soft deleted entity once the retention window has passed
+func (t *SimpleChaincode) purgeArchived(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	err := archive.Purge(stub, args.String("name"), archive.DefaultRetentionDays)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	return shim.Success(nil)
+}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+// getTxTime returns the transaction timestamp set by the client
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
 	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
 
-	A = args[0]
+// query callback representing the query of a chaincode
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	A := args.String("name") // Entities
 
 	// Get the state from the ledger
 	Avalbytes, err := stub.GetState(A)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", A).With("name", A).Response()
 	}
 
 	if Avalbytes == nil {
-		jsonResp := "{\"Error\":\"Nil amount for " + A + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Nil amount for %s", A).With("name", A).Response()
 	}
 
 	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
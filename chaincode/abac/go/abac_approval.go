@@ -0,0 +1,18 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-samples/chaincode/lib/approval"
+)
+
+// Besides admins deleting entities directly, anyone can propose a delete
+// with proposeDelete, which then needs the approval of admins of two
+// different MSPs within a day. Admins can change the policy, including who
+// may approve, through proposeApprovalPolicy. The archive of a soft delete
+// records the member whose approval completed it; the approval request
+// records all of them.
+var approvals = approval.New(registry, approval.Policy{Threshold: 2, DistinctMSPs: true, TTLSeconds: 24 * 60 * 60}).
+	Register(approval.DeleteOperation, approval.Delete)
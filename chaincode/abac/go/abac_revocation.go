@@ -0,0 +1,86 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/roles"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Admins manage the deny-list of the identity package through revoke and
+// unrevoke. As soon as a revocation commits, Invoke rejects every call from
+// the revoked client.
+
+// revoke adds a certificate serial number or an enrollment ID to the deny-list
+func (t *SimpleChaincode) revoke(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	revokedBy, err := assertAdmin(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	now, err := getTxTime(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	r := identity.Revocation{
+		Kind:      args.String("kind"),
+		MSPID:     args.String("mspId"),
+		Value:     args.String("value"),
+		Reason:    args.String("reason"),
+		RevokedBy: revokedBy,
+		RevokedAt: now.Format(time.RFC3339),
+	}
+	err = identity.Revoke(stub, r)
+	if err != nil {
+		return errcode.Wrap(err, "Failed to revoke").Response()
+	}
+	return shim.Success(nil)
+}
+
+// unrevoke removes a certificate serial number or an enrollment ID from the
+// deny-list
+func (t *SimpleChaincode) unrevoke(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	_, err := assertAdmin(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = identity.Unrevoke(stub, args.String("kind"), args.String("mspId"), args.String("value"))
+	if err != nil {
+		return errcode.Wrap(err, "Failed to unrevoke").Response()
+	}
+	return shim.Success(nil)
+}
+
+// listRevoked returns the deny-list
+func (t *SimpleChaincode) listRevoked(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	revocations, err := identity.ListRevocations(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	revocationsJSONasBytes, err := json.Marshal(revocations)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(revocationsJSONasBytes)
+}
+
+// assertAdmin returns an error unless the invoker is an admin (see
+// registry), and the invoker as MSPID::ID otherwise
+func assertAdmin(stub shim.ChaincodeStubInterface) (string, error) {
+	err := registry.AssertAdmin(stub)
+	if err != nil {
+		return "", errcode.Wrap(err, "Not allowed")
+	}
+	return roles.Member(stub)
+}
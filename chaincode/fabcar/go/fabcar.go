@@ -25,15 +25,20 @@
 package main
 
 /* Imports
- * 4 utility libraries for formatting, handling bytes, reading and writing JSON, and string manipulation
+ * 5 utility libraries for formatting, handling bytes, reading and writing JSON, and string manipulation
  * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
+ * 1 library shared by the sample chaincodes for routing function calls
  */
 import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 )
@@ -50,6 +55,26 @@ type Car struct {
 	Owner  string `json:"owner"`
 }
 
+/*
+ * A StateRecord is one line of an export. Exports are newline-delimited JSON (NDJSON),
+ * one record per line, where value is the car exactly as it is stored in state:
+ *   {"key":"CAR0","docType":"car","value":{"make":"Toyota","model":"Prius","colour":"blue","owner":"Tomoko"}}
+ */
+type StateRecord struct {
+	Key     string          `json:"key"`
+	DocType string          `json:"docType"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// An ExportResponse is a page of exported records along with the bookmark of the next page
+type ExportResponse struct {
+	Records          string `json:"Records"`
+	ResponseMetadata struct {
+		RecordsCount int32  `json:"RecordsCount"`
+		Bookmark     string `json:"Bookmark"`
+	} `json:"ResponseMetadata"`
+}
+
 /*
  * The Init method is called when the Smart Contract "fabcar" is instantiated by the blockchain network
  * Best practice is to have any Ledger initialization in separate function -- see initLedger()
@@ -64,35 +89,54 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
  */
 func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
 
-	// Retrieve the requested Smart Contract function and arguments
-	function, args := APIstub.GetFunctionAndParameters()
 	// Route to the appropriate handler function to interact with the ledger appropriately
-	if function == "queryCar" {
-		return s.queryCar(APIstub, args)
-	} else if function == "initLedger" {
-		return s.initLedger(APIstub)
-	} else if function == "createCar" {
-		return s.createCar(APIstub, args)
-	} else if function == "queryAllCars" {
-		return s.queryAllCars(APIstub)
-	} else if function == "changeCarOwner" {
-		return s.changeCarOwner(APIstub, args)
-	}
-
-	return shim.Error("Invalid Smart Contract function name.")
+	return routes.Handle(APIstub)
+}
+
+/*
+ * routes lists the Smart Contract functions and their arguments. The router checks the arguments before
+ * calling the handler, and the built-in "describe" function returns this list as JSON.
+ */
+var routes = new(SmartContract).buildRoutes()
+
+func (s *SmartContract) buildRoutes() *router.Router {
+	return router.New("fabcar", "1.0").
+		Add("queryCar", "Returns a car", s.queryCar,
+			router.Required("key", router.String)).
+		Add("initLedger", "Adds the sample cars", s.initLedger).
+		Add("createCar", "Adds a car", s.createCar,
+			router.Required("key", router.String),
+			router.Required("make", router.String),
+			router.Required("model", router.String),
+			router.Required("colour", router.String),
+			router.Required("owner", router.String)).
+		Add("queryAllCars", "Returns all cars", s.queryAllCars).
+		Add("changeCarOwner", "Sets a new owner on a car", s.changeCarOwner,
+			router.Required("key", router.String),
+			router.Required("owner", router.String)).
+		Add("exportState", "Exports a page of cars as NDJSON", s.exportState,
+			router.Required("startKey", router.String),
+			router.Required("endKey", router.String),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("importState", "Imports cars exported with exportState", s.importState,
+			router.Required("records", router.String),
+			router.Optional("force", router.Bool).Describe("overwrite existing cars"))
 }
 
-func (s *SmartContract) queryCar(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+func (s *SmartContract) queryCar(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	carAsBytes, err := APIstub.GetState(args.String("key"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if carAsBytes == nil {
+		return errcode.NotFound("Car not found: %s", args.String("key")).With("key", args.String("key")).Response()
 	}
-
-	carAsBytes, _ := APIstub.GetState(args[0])
 	return shim.Success(carAsBytes)
 }
 
-func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
+func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 	cars := []Car{
 		Car{Make: "Toyota", Model: "Prius", Colour: "blue", Owner: "Tomoko"},
 		Car{Make: "Ford", Model: "Mustang", Colour: "red", Owner: "Brad"},
@@ -118,28 +162,24 @@ func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Respo
 	return shim.Success(nil)
 }
 
-func (s *SmartContract) createCar(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
-	}
+func (s *SmartContract) createCar(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 
-	var car = Car{Make: args[1], Model: args[2], Colour: args[3], Owner: args[4]}
+	var car = Car{Make: args.String("make"), Model: args.String("model"), Colour: args.String("colour"), Owner: args.String("owner")}
 
 	carAsBytes, _ := json.Marshal(car)
-	APIstub.PutState(args[0], carAsBytes)
+	APIstub.PutState(args.String("key"), carAsBytes)
 
 	return shim.Success(nil)
 }
 
-func (s *SmartContract) queryAllCars(APIstub shim.ChaincodeStubInterface) sc.Response {
+func (s *SmartContract) queryAllCars(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 
 	startKey := "CAR0"
 	endKey := "CAR999"
 
 	resultsIterator, err := APIstub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer resultsIterator.Close()
 
@@ -151,7 +191,7 @@ func (s *SmartContract) queryAllCars(APIstub shim.ChaincodeStubInterface) sc.Res
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
@@ -175,24 +215,134 @@ func (s *SmartContract) queryAllCars(APIstub shim.ChaincodeStubInterface) sc.Res
 	return shim.Success(buffer.Bytes())
 }
 
-func (s *SmartContract) changeCarOwner(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+func (s *SmartContract) changeCarOwner(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	carAsBytes, err := APIstub.GetState(args.String("key"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if carAsBytes == nil {
+		return errcode.NotFound("Car not found: %s", args.String("key")).With("key", args.String("key")).Response()
 	}
-
-	carAsBytes, _ := APIstub.GetState(args[0])
 	car := Car{}
 
 	json.Unmarshal(carAsBytes, &car)
-	car.Owner = args[1]
+	car.Owner = args.String("owner")
 
 	carAsBytes, _ = json.Marshal(car)
-	APIstub.PutState(args[0], carAsBytes)
+	APIstub.PutState(args.String("key"), carAsBytes)
 
 	return shim.Success(nil)
 }
 
+/*
+ * exportState returns one page of the cars in the key range [startKey, endKey) as NDJSON, see StateRecord.
+ * The Records string of the response can be passed to importState as is:
+ *   peer chaincode query -C mychannel -n fabcar -c '{"Args":["exportState","CAR0","CAR999","50",""]}'
+ */
+func (s *SmartContract) exportState(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
+
+	pageSize := args.Int("pageSize")
+	if pageSize <= 0 || pageSize > math.MaxInt32 {
+		return errcode.InvalidArgument("Page size must be a positive 32-bit integer, got %d", pageSize).Response()
+	}
+
+	resultsIterator, responseMetadata, err := APIstub.GetStateByRangeWithPagination(args.String("startKey"), args.String("endKey"), int32(pageSize), args.String("bookmark"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errcode.Response(err)
+		}
+		recordAsBytes, err := json.Marshal(StateRecord{Key: queryResponse.Key, DocType: "car", Value: queryResponse.Value})
+		if err != nil {
+			return errcode.Response(err)
+		}
+		buffer.Write(recordAsBytes)
+		buffer.WriteString("\n")
+	}
+
+	response := ExportResponse{Records: buffer.String()}
+	if responseMetadata != nil {
+		response.ResponseMetadata.RecordsCount = responseMetadata.FetchedRecordsCount
+		response.ResponseMetadata.Bookmark = responseMetadata.Bookmark
+	}
+	responseAsBytes, err := json.Marshal(response)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(responseAsBytes)
+}
+
+/*
+ * importState writes the cars of an export in a single transaction. The import fails if a record would
+ * overwrite an existing car, unless the optional force argument is "true":
+ *   peer chaincode invoke -C mychannel -n fabcar -c '{"Args":["importState","{\"key\":\"CAR0\",\"docType\":\"car\",\"value\":{...}}","false"]}'
+ */
+func (s *SmartContract) importState(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
+
+	force := args.Bool("force")
+
+	// Validate every record before writing any of them
+	var records []StateRecord
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(args.String("records"), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var record StateRecord
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			return errcode.InvalidArgument("Line %d: failed to decode record: %s", i+1, err).With("line", i+1).Response()
+		}
+		if record.DocType != "car" || len(record.Key) == 0 {
+			return errcode.InvalidArgument("Line %d: expecting a car record with a key", i+1).With("line", i+1).Response()
+		}
+		car := Car{}
+		err = json.Unmarshal(record.Value, &car)
+		if err != nil {
+			return errcode.InvalidArgument("Line %d: failed to decode car: %s", i+1, err).With("line", i+1).Response()
+		}
+		// Store the car the way createCar does, dropping any unknown fields
+		record.Value, _ = json.Marshal(car)
+		if seen[record.Key] {
+			return errcode.InvalidArgument("Line %d: %s appears more than once", i+1, record.Key).With("line", i+1).Response()
+		}
+		seen[record.Key] = true
+
+		if !force {
+			carAsBytes, err := APIstub.GetState(record.Key)
+			if err != nil {
+				return errcode.Response(err)
+			}
+			if carAsBytes != nil {
+				return errcode.AlreadyExists("Line %d: %s already exists, import with force to overwrite it", i+1, record.Key).
+					With("line", i+1).With("key", record.Key).Response()
+			}
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return errcode.InvalidArgument("No records to import").Response()
+	}
+
+	for _, record := range records {
+		err := APIstub.PutState(record.Key, record.Value)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	return shim.Success([]byte(fmt.Sprintf("{\"imported\":%d}", len(records))))
+}
+
 // The main function is only relevant in unit test mode. Only included here for completeness.
 func main() {
 
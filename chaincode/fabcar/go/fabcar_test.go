@@ -0,0 +1,118 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+)
+
+// newStub returns a stub holding the cars of initLedger
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("fabcar", new(SmartContract))
+	stub.Init()
+	cctest.Invoke(t, stub, "initLedger")
+	return stub
+}
+
+// export returns a page of the export of stub
+func export(t *testing.T, stub *cctest.Stub, args ...string) ExportResponse {
+	response := ExportResponse{}
+	err := json.Unmarshal(cctest.Invoke(t, stub, append([]string{"exportState"}, args...)...), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestExportAndImport(t *testing.T) {
+	stub := newStub(t)
+
+	// Export the ten cars in pages of four
+	var records []string
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("the export did not end after three pages")
+		}
+		page := export(t, stub, "CAR0", "CAR999", "4", bookmark)
+		lines := strings.Split(strings.TrimSuffix(page.Records, "\n"), "\n")
+		if int(page.ResponseMetadata.RecordsCount) != len(lines) {
+			t.Errorf("the page counts %d records, holds %d", page.ResponseMetadata.RecordsCount, len(lines))
+		}
+		records = append(records, lines...)
+		bookmark = page.ResponseMetadata.Bookmark
+		if len(bookmark) == 0 {
+			break
+		}
+	}
+	if len(records) != 10 {
+		t.Fatalf("exported %d cars, want 10", len(records))
+	}
+	record := StateRecord{}
+	if err := json.Unmarshal([]byte(records[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != "CAR0" || record.DocType != "car" || !strings.Contains(string(record.Value), "Tomoko") {
+		t.Errorf("exported %+v", record)
+	}
+
+	// The records recreate the cars in an empty ledger
+	empty := cctest.NewStub("fabcar", new(SmartContract))
+	if res := string(cctest.Invoke(t, empty, "importState", strings.Join(records, "\n"))); res != `{"imported":10}` {
+		t.Errorf("importState returned %s", res)
+	}
+	for key, value := range stub.State {
+		if string(empty.State[key]) != string(value) {
+			t.Errorf("%s was imported as %s, want %s", key, empty.State[key], value)
+		}
+	}
+}
+
+func TestImportChecksEveryRecord(t *testing.T) {
+	stub := newStub(t)
+
+	car := `{"key":"CAR10","docType":"car","value":{"make":"Fiat","model":"Punto","colour":"violet","owner":"Pari"}}`
+	tests := []struct {
+		what string
+		args []string
+		code errcode.Code
+	}{
+		{"an empty import", []string{""}, errcode.CodeInvalidArgument},
+		{"a record that is no JSON", []string{car + "\n{"}, errcode.CodeInvalidArgument},
+		{"a record of another type", []string{`{"key":"CAR11","docType":"marble","value":{}}`}, errcode.CodeInvalidArgument},
+		{"a record without a key", []string{`{"docType":"car","value":{}}`}, errcode.CodeInvalidArgument},
+		{"a car named twice", []string{car + "\n" + car}, errcode.CodeInvalidArgument},
+		{"an existing car", []string{strings.Replace(car, "CAR10", "CAR0", 1)}, errcode.CodeAlreadyExists},
+		{"too many arguments", []string{car, "true", "true"}, errcode.CodeInvalidArgument},
+	}
+	for _, test := range tests {
+		cctest.AssertCode(t, stub.Invoke(append([]string{"importState"}, test.args...)...), test.code, test.what)
+	}
+	if stub.State["CAR10"] != nil {
+		t.Error("a failed import wrote CAR10")
+	}
+
+	// Forcing the import overwrites the existing car and drops unknown fields
+	cctest.Invoke(t, stub, "importState", strings.Replace(car, `"owner"`, `"tyres":4,"owner"`, 1)+"\n"+strings.Replace(car, "CAR10", "CAR0", 1), "true")
+	if got := string(stub.State["CAR0"]); got != `{"make":"Fiat","model":"Punto","colour":"violet","owner":"Pari"}` {
+		t.Errorf("CAR0 is %s", got)
+	}
+	if got := string(stub.State["CAR10"]); strings.Contains(got, "tyres") {
+		t.Errorf("CAR10 is %s", got)
+	}
+}
+
+func TestExportArguments(t *testing.T) {
+	stub := newStub(t)
+	for _, pageSize := range []string{"0", "-1", "many", "2147483648"} {
+		cctest.AssertCode(t, stub.Invoke("exportState", "CAR0", "CAR999", pageSize, ""), errcode.CodeInvalidArgument, "exporting pages of "+pageSize)
+	}
+	cctest.AssertCode(t, stub.Invoke("exportState", "CAR0", "CAR999", "4"), errcode.CodeInvalidArgument, "exporting without a bookmark")
+}
@@ -0,0 +1,629 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package approval runs sensitive operations only once several identities
+// have approved them. An operation is first proposed, which stores a pending
+// request, then distinct identities call approve; the approval that reaches
+// the threshold executes the operation in the same transaction. Requests
+// that are not approved in time expire, and any approver or the proposer can
+// reject a request instead.
+//
+// A chaincode registers the operations that need approval, adds a propose
+// function for each, and adds the approval functions to its router:
+//
+//	var approvals = approval.New(registry, approval.Policy{Threshold: 2, DistinctMSPs: true, TTLSeconds: 86400}).
+//		Register(approval.DeleteOperation, approval.Delete)
+//
+//	var r = approvals.AddRoutes(router.New("abac", "1.0").
+//		Add("proposeDelete", "Proposes to delete an entity", approvals.Proposer(approval.DeleteOperation),
+//			router.Required("name", router.String),
+//			router.Optional("mode", router.String),
+//			router.Optional("reason", router.String)))
+//
+// An operation may check the proposal and normalize its arguments when it is
+// proposed, so that approvers see exactly what will be executed. Otherwise
+// the executor receives the arguments given to the propose function.
+//
+// Approvers are named by identity, by MSP or by a role of the roles registry
+// passed to New. Without approvers, the policy passed to New lets admins
+// approve. That policy applies until a different one is approved: admins
+// propose one with proposeApprovalPolicy, which is itself subject to the
+// policy in force. Each request keeps the policy it was proposed under.
+package approval
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/roles"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Request statuses. StatusExpired is never stored: a pending request reads
+// as expired once its deadline has passed.
+const (
+	StatusPending  = "pending"
+	StatusExecuted = "executed"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+)
+
+// SetPolicyOperation is the built-in operation changing the policy
+const SetPolicyOperation = "setApprovalPolicy"
+
+// RolePrefix marks an approver naming a role of the roles registry, e.g.
+// role:admin
+const RolePrefix = "role:"
+
+const (
+	requestObjectType = "approval~request"
+	policyObjectType  = "approval~policy"
+)
+
+// Policy says how many approvals an operation needs and from whom
+type Policy struct {
+	// Threshold is the number of approvals needed, the M in M-of-N
+	Threshold int `json:"threshold"`
+	// Approvers are the identities that may approve: one identity as
+	// MSPID::ID, every member of an MSP as MSPID, or every holder of a role
+	// as role:NAME. A policy needs at least one.
+	Approvers []string `json:"approvers,omitempty"`
+	// DistinctMSPs requires every approval to come from a different MSP
+	DistinctMSPs bool `json:"distinctMsps"`
+	// TTLSeconds is the time a request may stay pending
+	TTLSeconds int `json:"ttlSeconds"`
+}
+
+// Vote is an approval or a rejection
+type Vote struct {
+	Member string `json:"member"`
+	MSPID  string `json:"mspId"`
+	At     string `json:"at"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Request is a proposed operation and its approvals
+type Request struct {
+	ID         string   `json:"id"`
+	Operation  string   `json:"operation"`
+	Args       []string `json:"args"`
+	Proposer   string   `json:"proposer"`
+	CreatedAt  string   `json:"createdAt"`
+	ExpiresAt  string   `json:"expiresAt"`
+	Policy     Policy   `json:"policy"`
+	Status     string   `json:"status"`
+	Approvals  []Vote   `json:"approvals"`
+	Rejection  *Vote    `json:"rejection,omitempty"`
+	ExecutedAt string   `json:"executedAt,omitempty"`
+}
+
+// Preparer checks a proposal and returns the arguments to store in the
+// request, e.g. with defaults filled in
+type Preparer func(stub shim.ChaincodeStubInterface, args *router.Args) ([]string, error)
+
+// Executor performs an approved operation
+type Executor func(stub shim.ChaincodeStubInterface, args []string) error
+
+// Operation is an operation that needs approval
+type Operation struct {
+	// Prepare is optional; without it the request stores the arguments of
+	// the propose function as they were passed
+	Prepare Preparer
+	Execute Executor
+}
+
+// Manager holds the operations that need approval and the default policy
+type Manager struct {
+	registry   *roles.Registry
+	defaults   Policy
+	operations map[string]Operation
+}
+
+// New returns a manager with the given default policy. Role approvers are
+// looked up in registry, and a default policy without approvers lets the
+// admins of registry approve.
+func New(registry *roles.Registry, defaults Policy) *Manager {
+	if len(defaults.Approvers) == 0 {
+		defaults.Approvers = []string{RolePrefix + roles.AdminRole}
+	}
+	m := &Manager{registry: registry, defaults: defaults, operations: make(map[string]Operation)}
+	return m.Register(SetPolicyOperation, Operation{Execute: m.setPolicy})
+}
+
+// Register adds an operation that needs approval
+func (m *Manager) Register(name string, op Operation) *Manager {
+	m.operations[name] = op
+	return m
+}
+
+// Proposer returns a handler proposing the operation with the arguments of
+// the invocation, as prepared by the operation
+func (m *Manager) Proposer(operation string) router.Handler {
+	return func(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+		op, ok := m.operations[operation]
+		if !ok {
+			return errcode.InvalidArgument("Operation %s does not need approval", operation).With("operation", operation).Response()
+		}
+		requestArgs := args.Raw()
+		if op.Prepare != nil {
+			var err error
+			requestArgs, err = op.Prepare(stub, args)
+			if err != nil {
+				return errcode.Response(err)
+			}
+		}
+		request, err := m.Propose(stub, operation, requestArgs)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		return success(request)
+	}
+}
+
+// Propose stores a pending request for an operation. The ID of the request
+// is the ID of the proposing transaction.
+func (m *Manager) Propose(stub shim.ChaincodeStubInterface, operation string, args []string) (*Request, error) {
+	if _, ok := m.operations[operation]; !ok {
+		return nil, errcode.InvalidArgument("Operation %s does not need approval", operation).With("operation", operation)
+	}
+	proposer, _, err := invoker(stub)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := m.GetPolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &Request{
+		ID:        stub.GetTxID(),
+		Operation: operation,
+		Args:      args,
+		Proposer:  proposer,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(time.Duration(policy.TTLSeconds) * time.Second).Format(time.RFC3339),
+		Policy:    *policy,
+		Status:    StatusPending,
+		Approvals: []Vote{},
+	}
+	err = putRequest(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Approve records the invoker's approval of a pending request. The approval
+// that reaches the threshold executes the operation; if it fails, so does
+// the approval.
+func (m *Manager) Approve(stub shim.ChaincodeStubInterface, id string) (*Request, error) {
+	request, now, err := m.pendingRequest(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	member, mspID, err := m.eligible(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	for _, vote := range request.Approvals {
+		if vote.Member == member {
+			return nil, errcode.Conflict("%s has already approved request %s", member, id).With("id", id).With("member", member)
+		}
+		if request.Policy.DistinctMSPs && vote.MSPID == mspID {
+			return nil, errcode.Conflict("Request %s already has an approval from %s", id, mspID).With("id", id).With("mspId", mspID)
+		}
+	}
+
+	request.Approvals = append(request.Approvals, Vote{Member: member, MSPID: mspID, At: now.Format(time.RFC3339)})
+	if len(request.Approvals) >= request.Policy.Threshold {
+		op, ok := m.operations[request.Operation]
+		if !ok {
+			return nil, errcode.Internal("No executor for operation %s", request.Operation)
+		}
+		err = op.Execute(stub, request.Args)
+		if err != nil {
+			return nil, errcode.Wrap(err, "Failed to execute request %s", id)
+		}
+		request.Status = StatusExecuted
+		request.ExecutedAt = now.Format(time.RFC3339)
+	}
+	err = putRequest(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// Reject rejects a pending request. The proposer and anyone who may approve
+// the request can reject it.
+func (m *Manager) Reject(stub shim.ChaincodeStubInterface, id, reason string) (*Request, error) {
+	request, now, err := m.pendingRequest(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	member, mspID, err := invoker(stub)
+	if err != nil {
+		return nil, err
+	}
+	if member != request.Proposer {
+		if _, _, err := m.eligible(stub, request); err != nil {
+			return nil, err
+		}
+	}
+
+	request.Status = StatusRejected
+	request.Rejection = &Vote{Member: member, MSPID: mspID, At: now.Format(time.RFC3339), Reason: reason}
+	err = putRequest(stub, request)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetRequest returns a request, with the status expired if it is pending
+// past its deadline
+func (m *Manager) GetRequest(stub shim.ChaincodeStubInterface, id string) (*Request, error) {
+	requestKey, err := stub.CreateCompositeKey(requestObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	requestJSONasBytes, err := stub.GetState(requestKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get request %s: %s", id, err)
+	}
+	if requestJSONasBytes == nil {
+		return nil, errcode.NotFound("Approval request does not exist: %s", id).With("id", id)
+	}
+	request := &Request{}
+	err = json.Unmarshal(requestJSONasBytes, request)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	markExpired(request, now)
+	return request, nil
+}
+
+// ListRequests returns the requests with the given status, or all requests
+// if status is empty
+func (m *Manager) ListRequests(stub shim.ChaincodeStubInterface, status string) ([]*Request, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := stub.GetStateByPartialCompositeKey(requestObjectType, []string{})
+	if err != nil {
+		return nil, errcode.Internal("Failed to read approval requests: %s", err)
+	}
+	defer iterator.Close()
+
+	requests := []*Request{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, errcode.Internal("Failed to read approval requests: %s", err)
+		}
+		request := &Request{}
+		err = json.Unmarshal(kv.Value, request)
+		if err != nil {
+			return nil, err
+		}
+		markExpired(request, now)
+		if len(status) == 0 || request.Status == status {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
+// GetPolicy returns the policy in force
+func (m *Manager) GetPolicy(stub shim.ChaincodeStubInterface) (*Policy, error) {
+	policyKey, err := stub.CreateCompositeKey(policyObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	policyJSONasBytes, err := stub.GetState(policyKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get the approval policy: %s", err)
+	}
+	if policyJSONasBytes == nil {
+		policy := m.defaults
+		return &policy, nil
+	}
+	policy := &Policy{}
+	err = json.Unmarshal(policyJSONasBytes, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// AddRoutes adds the approval functions to a router:
+//
+//	approve(id)                      approves a pending request
+//	reject(id, [reason])             rejects a pending request
+//	getApprovalRequest(id)           returns a request
+//	listApprovalRequests([status])   returns the requests, optionally by status
+//	getApprovalPolicy()              returns the policy in force
+//	proposeApprovalPolicy(threshold, [approvers], [distinctMsps], [ttlSeconds])
+//	                                 proposes a new policy; admins only
+func (m *Manager) AddRoutes(rt *router.Router) *router.Router {
+	return rt.
+		Add("approve", "Approves a pending request, executing it once it has enough approvals", m.approve,
+			router.Required("id", router.String)).
+		Add("reject", "Rejects a pending request", m.reject,
+			router.Required("id", router.String),
+			router.Optional("reason", router.String)).
+		Add("getApprovalRequest", "Returns an approval request", m.getRequest,
+			router.Required("id", router.String)).
+		Add("listApprovalRequests", "Lists approval requests, optionally only those with a status", m.listRequests,
+			router.Optional("status", router.String).Describe("pending, executed, rejected or expired")).
+		Add("getApprovalPolicy", "Returns the approval policy in force", m.getPolicy).
+		Add("proposeApprovalPolicy", "Proposes a new approval policy; admins only", m.proposePolicy,
+			router.Required("threshold", router.Int),
+			router.Optional("approvers", router.JSON).Describe("list of MSPID, MSPID::ID or role:NAME"),
+			router.Optional("distinctMsps", router.Bool),
+			router.Optional("ttlSeconds", router.Int))
+}
+
+func (m *Manager) approve(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	request, err := m.Approve(stub, args.String("id"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(request)
+}
+
+func (m *Manager) reject(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	request, err := m.Reject(stub, args.String("id"), args.String("reason"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(request)
+}
+
+func (m *Manager) getRequest(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	request, err := m.GetRequest(stub, args.String("id"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(request)
+}
+
+func (m *Manager) listRequests(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	requests, err := m.ListRequests(stub, args.String("status"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(requests)
+}
+
+func (m *Manager) getPolicy(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	policy, err := m.GetPolicy(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(policy)
+}
+
+// proposePolicy proposes a policy with the given threshold. The other
+// fields keep their current values unless they are passed.
+func (m *Manager) proposePolicy(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	err := m.registry.AssertAdmin(stub)
+	if err != nil {
+		return errcode.Wrap(err, "Not allowed to propose an approval policy").Response()
+	}
+	current, err := m.GetPolicy(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	policy := Policy{Threshold: args.Int("threshold"), Approvers: current.Approvers, DistinctMSPs: current.DistinctMSPs, TTLSeconds: current.TTLSeconds}
+	if args.Has("approvers") {
+		policy.Approvers = nil
+		err = args.Decode("approvers", &policy.Approvers)
+		if err != nil {
+			return errcode.InvalidArgument("Approvers must be a JSON list of strings: %s", err).Response()
+		}
+	}
+	if args.Has("distinctMsps") {
+		policy.DistinctMSPs = args.Bool("distinctMsps")
+	}
+	if args.Has("ttlSeconds") {
+		policy.TTLSeconds = args.Int("ttlSeconds")
+	}
+	err = checkPolicy(policy)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	policyJSONasBytes, err := json.Marshal(policy)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	request, err := m.Propose(stub, SetPolicyOperation, []string{string(policyJSONasBytes)})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(request)
+}
+
+// setPolicy is the executor of SetPolicyOperation
+func (m *Manager) setPolicy(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) != 1 {
+		return errcode.InvalidArgument("Expecting the policy as JSON")
+	}
+	policy := Policy{}
+	err := json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil {
+		return errcode.InvalidArgument("Policy is not valid JSON: %s", err)
+	}
+	err = checkPolicy(policy)
+	if err != nil {
+		return err
+	}
+	policyKey, err := stub.CreateCompositeKey(policyObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(policyKey, []byte(args[0]))
+}
+
+// pendingRequest returns a request that can still be approved or rejected,
+// along with the transaction time
+func (m *Manager) pendingRequest(stub shim.ChaincodeStubInterface, id string) (*Request, time.Time, error) {
+	request, err := m.GetRequest(stub, id)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if request.Status != StatusPending {
+		return nil, time.Time{}, errcode.Conflict("Approval request %s is %s", id, request.Status).With("id", id).With("status", request.Status)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return request, now, nil
+}
+
+// checkPolicy returns an INVALID_ARGUMENT error if no set of approvers
+// allowed by the policy can reach its threshold
+func checkPolicy(policy Policy) error {
+	if policy.Threshold < 1 {
+		return errcode.InvalidArgument("Threshold must be at least 1").With("threshold", policy.Threshold)
+	}
+	if policy.TTLSeconds < 1 {
+		return errcode.InvalidArgument("TTL must be a positive number of seconds").With("ttlSeconds", policy.TTLSeconds)
+	}
+	if len(policy.Approvers) == 0 {
+		return errcode.InvalidArgument("Policy must name at least one approver")
+	}
+	msps := make(map[string]bool)
+	members := 0
+	hasRoles := false
+	for _, approver := range policy.Approvers {
+		if strings.HasPrefix(approver, RolePrefix) {
+			if len(approver) == len(RolePrefix) {
+				return errcode.InvalidArgument("Approver must name a role after %s", RolePrefix).With("approver", approver)
+			}
+			hasRoles = true
+			continue
+		}
+		parts := strings.SplitN(approver, "::", 2)
+		if len(parts[0]) == 0 || len(parts) == 2 && len(parts[1]) == 0 {
+			return errcode.InvalidArgument("Approver must have the form MSPID or MSPID::ID, got '%s'", approver).With("approver", approver)
+		}
+		msps[parts[0]] = true
+		if len(parts) == 2 {
+			members++
+		}
+	}
+	// a role may have any number of holders in any number of MSPs
+	if policy.DistinctMSPs && !hasRoles && policy.Threshold > len(msps) {
+		return errcode.InvalidArgument("Threshold %d cannot be reached with approvals from %d distinct MSPs", policy.Threshold, len(msps)).
+			With("threshold", policy.Threshold)
+	}
+	if members == len(policy.Approvers) && policy.Threshold > members {
+		return errcode.InvalidArgument("Threshold %d cannot be reached by %d approvers", policy.Threshold, members).
+			With("threshold", policy.Threshold)
+	}
+	return nil
+}
+
+// eligible returns the invoker as MSPID::ID and its MSP ID, or an
+// UNAUTHORIZED error if the policy of the request does not allow it to
+// approve
+func (m *Manager) eligible(stub shim.ChaincodeStubInterface, request *Request) (string, string, error) {
+	member, mspID, err := invoker(stub)
+	if err != nil {
+		return "", "", err
+	}
+	for _, approver := range request.Policy.Approvers {
+		if approver == member || approver == mspID {
+			return member, mspID, nil
+		}
+		if !strings.HasPrefix(approver, RolePrefix) {
+			continue
+		}
+		role := strings.TrimPrefix(approver, RolePrefix)
+		if role == roles.AdminRole {
+			err = m.registry.AssertAdmin(stub)
+		} else {
+			err = m.registry.AssertRole(stub, role)
+		}
+		if err == nil {
+			return member, mspID, nil
+		}
+		if errcode.CodeOf(err) != errcode.CodeUnauthorized {
+			return "", "", err
+		}
+	}
+	return "", "", errcode.Unauthorized("%s is not an approver of request %s", member, request.ID).
+		With("id", request.ID).With("member", member)
+}
+
+// invoker returns the invoker as MSPID::ID and its MSP ID
+func invoker(stub shim.ChaincodeStubInterface) (string, string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", "", errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err)
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return "", "", errcode.Unauthorized("Failed to get the ID of the invoker: %s", err)
+	}
+	return mspID + "::" + id, mspID, nil
+}
+
+// markExpired sets the status of a pending request past its deadline to
+// expired
+func markExpired(request *Request, now time.Time) {
+	if request.Status != StatusPending {
+		return
+	}
+	expiresAt, err := time.Parse(time.RFC3339, request.ExpiresAt)
+	if err == nil && now.After(expiresAt) {
+		request.Status = StatusExpired
+	}
+}
+
+func putRequest(stub shim.ChaincodeStubInterface, request *Request) error {
+	requestKey, err := stub.CreateCompositeKey(requestObjectType, []string{request.ID})
+	if err != nil {
+		return err
+	}
+	requestJSONasBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(requestKey, requestJSONasBytes)
+}
+
+// txTime returns the transaction timestamp set by the client
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func success(v interface{}) pb.Response {
+	resultJSONasBytes, err := json.Marshal(v)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(resultJSONasBytes)
+}
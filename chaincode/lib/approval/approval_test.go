@@ -0,0 +1,242 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package approval
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/archive"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/roles"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var (
+	alice = cctest.NewClient("Org1MSP", "alice")
+	carol = cctest.NewClient("Org1MSP", "carol")
+	bob   = cctest.NewClient("Org2MSP", "bob")
+	dave  = cctest.NewClient("Org3MSP", "dave")
+	admin = cctest.NewClientWith("Org1MSP", "admin", cctest.CertOptions{OUs: []string{"admin"}})
+)
+
+// testChaincode stores the entities passed to Init and deletes them once
+// their deletion is approved
+type testChaincode struct {
+	registry *roles.Registry
+	routes   *router.Router
+}
+
+func newTestChaincode(defaults Policy) *testChaincode {
+	registry := roles.New()
+	approvals := New(registry, defaults).Register(DeleteOperation, Delete)
+	return &testChaincode{registry: registry, routes: approvals.AddRoutes(registry.AddRoutes(router.New("approvaltest", "1.0").
+		Add("proposeDelete", "Proposes to delete an entity", approvals.Proposer(DeleteOperation),
+			router.Required("name", router.String),
+			router.Optional("mode", router.String),
+			router.Optional("reason", router.String))))}
+}
+
+func (t *testChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	if err := t.registry.Init(stub); err != nil {
+		return errcode.Response(err)
+	}
+	for _, name := range stub.GetStringArgs() {
+		err := stub.PutState(name, []byte("value of "+name))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success(nil)
+}
+
+func (t *testChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return t.routes.Handle(stub)
+}
+
+func newStub(t *testing.T, defaults Policy) *cctest.Stub {
+	stub := cctest.NewStub("approvaltest", newTestChaincode(defaults))
+	if res := stub.As(alice).Init("a", "b", "c"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+// request invokes a function returning a request and decodes it
+func request(t *testing.T, stub *cctest.Stub, args ...string) *Request {
+	request := &Request{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, args...), request); err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestThresholdWithDistinctMSPs(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 2, Approvers: []string{"Org1MSP", "Org2MSP"}, DistinctMSPs: true, TTLSeconds: 3600})
+
+	req := request(t, stub.As(alice), "proposeDelete", "a", "soft", "obsolete")
+	if req.Status != StatusPending || req.Proposer != alice.Member() {
+		t.Fatalf("proposed %+v", req)
+	}
+	if len(req.Args) != 3 || req.Args[1] != archive.ModeSoft {
+		t.Errorf("the request stores %v", req.Args)
+	}
+
+	req = request(t, stub.As(alice), "approve", req.ID)
+	if req.Status != StatusPending || len(req.Approvals) != 1 {
+		t.Fatalf("after one approval: %+v", req)
+	}
+	if stub.State["a"] == nil {
+		t.Fatal("a was deleted below the threshold")
+	}
+	cctest.AssertCode(t, stub.As(alice).Invoke("approve", req.ID), errcode.CodeConflict, "a second approval by alice")
+	cctest.AssertCode(t, stub.As(carol).Invoke("approve", req.ID), errcode.CodeConflict, "a second approval from Org1MSP")
+	cctest.AssertCode(t, stub.As(dave).Invoke("approve", req.ID), errcode.CodeUnauthorized, "an approval from Org3MSP")
+
+	req = request(t, stub.As(bob), "approve", req.ID)
+	if req.Status != StatusExecuted || len(req.Approvals) != 2 || len(req.ExecutedAt) == 0 {
+		t.Fatalf("after the threshold: %+v", req)
+	}
+	if stub.State["a"] != nil {
+		t.Error("a was not deleted")
+	}
+	err := stub.Call(func(stub shim.ChaincodeStubInterface) error {
+		_, err := archive.Get(stub, "a")
+		return err
+	})
+	if err != nil {
+		t.Errorf("a was not archived: %s", err)
+	}
+	cctest.AssertCode(t, stub.As(carol).Invoke("approve", req.ID), errcode.CodeConflict, "an approval of an executed request")
+}
+
+func TestThresholdWithNamedApprovers(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 2, Approvers: []string{alice.Member(), carol.Member(), bob.Member()}, TTLSeconds: 3600})
+
+	req := request(t, stub.As(bob), "proposeDelete", "b")
+	if req.Args[1] != archive.ModeHard {
+		t.Errorf("the empty mode was stored as %q", req.Args[1])
+	}
+	cctest.AssertCode(t, stub.As(admin).Invoke("approve", req.ID), errcode.CodeUnauthorized, "an approval by an admin who is not named")
+	request(t, stub.As(alice), "approve", req.ID)
+	req = request(t, stub.As(carol), "approve", req.ID)
+	if req.Status != StatusExecuted {
+		t.Fatalf("two approvers of the same MSP did not reach the threshold: %+v", req)
+	}
+	if stub.State["b"] != nil {
+		t.Error("b was not deleted")
+	}
+}
+
+func TestProposeChecksTheDelete(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 1, TTLSeconds: 3600})
+
+	cctest.AssertCode(t, stub.As(alice).Invoke("proposeDelete", "nope"), errcode.CodeNotFound, "proposing to delete a missing entity")
+	cctest.AssertCode(t, stub.As(alice).Invoke("proposeDelete", "a", "bogus"), errcode.CodeInvalidArgument, "proposing an unknown mode")
+	cctest.AssertCode(t, stub.As(alice).Invoke("approve", "tx404"), errcode.CodeNotFound, "approving a missing request")
+}
+
+func TestDefaultPolicyLetsAdminsApprove(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 1, TTLSeconds: 3600})
+
+	req := request(t, stub.As(alice), "proposeDelete", "a")
+	if len(req.Policy.Approvers) != 1 || req.Policy.Approvers[0] != RolePrefix+roles.AdminRole {
+		t.Errorf("the default approvers are %v", req.Policy.Approvers)
+	}
+	cctest.AssertCode(t, stub.As(alice).Invoke("approve", req.ID), errcode.CodeUnauthorized, "an approval by a client")
+
+	// A ledger admin approves like a NodeOU admin
+	cctest.Invoke(t, stub.As(admin), "grantRole", bob.Member(), roles.AdminRole)
+	req = request(t, stub.As(bob), "approve", req.ID)
+	if req.Status != StatusExecuted {
+		t.Errorf("the approval by a ledger admin did not execute: %+v", req)
+	}
+}
+
+func TestPolicyProposals(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 1, TTLSeconds: 3600})
+
+	cctest.AssertCode(t, stub.As(alice).Invoke("proposeApprovalPolicy", "1", `["role:auditor"]`), errcode.CodeUnauthorized, "a policy proposal by a client")
+	invalid := [][]string{
+		{"0"},
+		{"1", `["Org1MSP::"]`},
+		{"1", `["role:"]`},
+		{"1", `["role:auditor","Org1MSP::"]`},
+		{"1", `["role:auditor","role:"]`},
+		{"2", `["Org1MSP::alice"]`},
+		{"2", `["Org1MSP"]`, "true"},
+		{"1", `"Org1MSP"`},
+		{"1", "", "", "0"},
+	}
+	for _, args := range invalid {
+		args = append([]string{"proposeApprovalPolicy"}, args...)
+		cctest.AssertCode(t, stub.As(admin).Invoke(args...), errcode.CodeInvalidArgument, "proposing the policy "+args[1])
+	}
+
+	req := request(t, stub.As(admin), "proposeApprovalPolicy", "1", `["role:auditor"]`)
+	if req.Operation != SetPolicyOperation {
+		t.Fatalf("proposed %+v", req)
+	}
+	request(t, stub.As(admin), "approve", req.ID)
+	policy := Policy{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "getApprovalPolicy"), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Threshold != 1 || len(policy.Approvers) != 1 || policy.Approvers[0] != "role:auditor" || policy.TTLSeconds != 3600 {
+		t.Fatalf("the policy in force is %+v", policy)
+	}
+
+	// Requests proposed from now on need an auditor
+	req = request(t, stub.As(alice), "proposeDelete", "c")
+	cctest.AssertCode(t, stub.As(bob).Invoke("approve", req.ID), errcode.CodeUnauthorized, "an approval by a member who is not an auditor")
+	cctest.Invoke(t, stub.As(admin), "grantRole", bob.Member(), "auditor")
+	req = request(t, stub.As(bob), "approve", req.ID)
+	if req.Status != StatusExecuted {
+		t.Errorf("the approval by an auditor did not execute: %+v", req)
+	}
+}
+
+func TestRejectAndExpiry(t *testing.T) {
+	stub := newStub(t, Policy{Threshold: 2, Approvers: []string{"Org1MSP", "Org2MSP"}, TTLSeconds: 60})
+	now := time.Now()
+	stub.At(now)
+
+	first := request(t, stub.As(alice), "proposeDelete", "a")
+	second := request(t, stub.As(alice), "proposeDelete", "b")
+	third := request(t, stub.As(alice), "proposeDelete", "c")
+
+	cctest.AssertCode(t, stub.As(dave).Invoke("reject", first.ID), errcode.CodeUnauthorized, "a rejection by a member who is not an approver")
+	req := request(t, stub.As(bob), "reject", first.ID, "not yet")
+	if req.Status != StatusRejected || req.Rejection.Member != bob.Member() || req.Rejection.Reason != "not yet" {
+		t.Errorf("rejected %+v", req)
+	}
+	cctest.AssertCode(t, stub.As(alice).Invoke("approve", first.ID), errcode.CodeConflict, "an approval of a rejected request")
+
+	// The proposer may reject its own request
+	if req := request(t, stub.As(alice), "reject", second.ID); req.Status != StatusRejected {
+		t.Errorf("rejected %+v", req)
+	}
+
+	stub.At(now.Add(2 * time.Minute))
+	cctest.AssertCode(t, stub.As(bob).Invoke("approve", third.ID), errcode.CodeConflict, "an approval of an expired request")
+	if req := request(t, stub, "getApprovalRequest", third.ID); req.Status != StatusExpired {
+		t.Errorf("the request past its deadline is %s", req.Status)
+	}
+
+	requests := []*Request{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "listApprovalRequests", StatusRejected), &requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("listed %d rejected requests, want 2", len(requests))
+	}
+	if stub.State["a"] == nil || stub.State["b"] == nil || stub.State["c"] == nil {
+		t.Error("an entity was deleted without approval")
+	}
+}
@@ -0,0 +1,46 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package approval
+
+import (
+	"github.com/hyperledger/fabric-samples/chaincode/lib/archive"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// DeleteOperation is the name chaincodes register Delete under
+const DeleteOperation = "delete"
+
+// Delete deletes an entity with archive.Delete once it is approved. Its
+// propose function takes the parameters name, [mode] and [reason]. The
+// entity must exist when the delete is proposed, and the request stores the
+// mode after archive.NormalizeMode, so a request that was approved does not
+// fail over its arguments.
+var Delete = Operation{Prepare: prepareDelete, Execute: executeDelete}
+
+func prepareDelete(stub shim.ChaincodeStubInterface, args *router.Args) ([]string, error) {
+	name := args.String("name")
+	mode, err := archive.NormalizeMode(args.String("mode"))
+	if err != nil {
+		return nil, err
+	}
+	valbytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get state")
+	}
+	if valbytes == nil {
+		return nil, errcode.NotFound("Entity not found").With("name", name)
+	}
+	return []string{name, mode, args.String("reason")}, nil
+}
+
+// executeDelete takes the arguments stored by prepareDelete
+func executeDelete(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) != 3 {
+		return errcode.InvalidArgument("Expecting the name, mode and reason of the delete")
+	}
+	return archive.Delete(stub, args[0], args[1], args[2])
+}
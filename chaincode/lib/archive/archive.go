@@ -0,0 +1,219 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package archive implements soft deletes of values stored under simple
+// keys. A soft delete moves the value into the archive together with who
+// deleted it, when and why. From there it can be restored, or purged once
+// the retention window has passed:
+//
+//	archive~name  name  -> Entity
+//
+// Restoring and purging are meant for a few trusted members, so chaincodes
+// usually gate them, e.g. with roles.Registry.Require:
+//
+//	Add("restore", "Restores a soft deleted entity; archivists only",
+//		registry.Require("", "archivist", restore),
+//		router.Required("name", router.String))
+package archive
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Delete modes
+const (
+	ModeHard = "hard"
+	ModeSoft = "soft"
+)
+
+// DefaultRetentionDays is how long a soft deleted entity is usually kept
+// before it can be purged
+const DefaultRetentionDays = 30
+
+// nameIndex is the composite key namespace holding soft deleted entities
+const nameIndex = "archive~name"
+
+// Entity is a soft deleted entity along with its deletion metadata
+type Entity struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	DeletedBy string `json:"deletedBy"`
+	DeletedAt string `json:"deletedAt"`
+	Reason    string `json:"reason"`
+}
+
+// NormalizeMode returns ModeHard for an empty mode, and an INVALID_ARGUMENT
+// error for anything but ModeHard and ModeSoft
+func NormalizeMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return ModeHard, nil
+	case ModeHard, ModeSoft:
+		return mode, nil
+	}
+	return "", errcode.InvalidArgument("Delete mode must be \"%s\" or \"%s\"", ModeHard, ModeSoft).With("mode", mode)
+}
+
+// Delete deletes the entity stored under name. A soft delete archives the
+// entity first, and fails if an archived copy of it already exists.
+func Delete(stub shim.ChaincodeStubInterface, name, mode, reason string) error {
+	mode, err := NormalizeMode(mode)
+	if err != nil {
+		return err
+	}
+	if mode == ModeSoft {
+		err = archive(stub, name, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the key from the state in ledger
+	err = stub.DelState(name)
+	if err != nil {
+		return errcode.Internal("Failed to delete state")
+	}
+	return nil
+}
+
+// Restore moves a soft deleted entity back into the state, unless the name
+// has been reused since it was deleted
+func Restore(stub shim.ChaincodeStubInterface, name string) error {
+	archived, err := Get(stub, name)
+	if err != nil {
+		return err
+	}
+
+	valbytes, err := stub.GetState(name)
+	if err != nil {
+		return errcode.Internal("Failed to get state")
+	}
+	if valbytes != nil {
+		return errcode.AlreadyExists("Entity exists, it cannot be restored over").With("name", name)
+	}
+
+	err = stub.PutState(name, []byte(archived.Value))
+	if err != nil {
+		return err
+	}
+	return del(stub, name)
+}
+
+// Purge permanently removes a soft deleted entity once retentionDays have
+// passed since it was deleted
+func Purge(stub shim.ChaincodeStubInterface, name string, retentionDays int) error {
+	archived, err := Get(stub, name)
+	if err != nil {
+		return err
+	}
+
+	deletedAt, err := time.Parse(time.RFC3339, archived.DeletedAt)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	purgeableAt := deletedAt.AddDate(0, 0, retentionDays)
+	if now.Before(purgeableAt) {
+		return errcode.Conflict("Archived entity cannot be purged before %s", purgeableAt.Format(time.RFC3339)).
+			With("purgeableAt", purgeableAt.Format(time.RFC3339))
+	}
+	return del(stub, name)
+}
+
+// Get reads a soft deleted entity from the archive, or returns a NOT_FOUND
+// error
+func Get(stub shim.ChaincodeStubInterface, name string) (*Entity, error) {
+	archiveKey, err := stub.CreateCompositeKey(nameIndex, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	archivedJSONasBytes, err := stub.GetState(archiveKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get state")
+	}
+	if archivedJSONasBytes == nil {
+		return nil, errcode.NotFound("Archived entity not found").With("name", name)
+	}
+
+	archived := &Entity{}
+	err = json.Unmarshal(archivedJSONasBytes, archived)
+	if err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// archive copies an entity into the archive with its deletion metadata
+func archive(stub shim.ChaincodeStubInterface, name, reason string) error {
+	valbytes, err := stub.GetState(name)
+	if err != nil {
+		return errcode.Internal("Failed to get state")
+	}
+	if valbytes == nil {
+		return errcode.NotFound("Entity not found").With("name", name)
+	}
+
+	archiveKey, err := stub.CreateCompositeKey(nameIndex, []string{name})
+	if err != nil {
+		return err
+	}
+	existing, err := stub.GetState(archiveKey)
+	if err != nil {
+		return errcode.Internal("Failed to get state")
+	}
+	if existing != nil {
+		return errcode.AlreadyExists("An archived copy of %s already exists, purge it first", name).With("name", name)
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return err
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	archivedJSONasBytes, err := json.Marshal(Entity{
+		Name:      name,
+		Value:     string(valbytes),
+		DeletedBy: mspID + "::" + id,
+		DeletedAt: now.Format(time.RFC3339),
+		Reason:    reason,
+	})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(archiveKey, archivedJSONasBytes)
+}
+
+// del removes a soft deleted entity from the archive
+func del(stub shim.ChaincodeStubInterface, name string) error {
+	archiveKey, err := stub.CreateCompositeKey(nameIndex, []string{name})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(archiveKey)
+}
+
+// txTime returns the transaction timestamp set by the client
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
@@ -0,0 +1,374 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package cctest runs chaincodes in unit tests. shim.MockStub keeps the state
+// in memory but has no client identity, since its GetCreator returns nothing.
+// Stub adds one, so that chaincodes using cid can be tested as different
+// clients:
+//
+//	alice, bob := cctest.NewClient("Org1MSP", "alice"), cctest.NewClient("Org2MSP", "bob")
+//	stub := cctest.NewStub("marbles", new(SimpleChaincode))
+//	res := stub.As(alice).Init("init")
+//	res = stub.Invoke("initMarble", "marble1", "blue", "35")
+//	res = stub.Invoke("transferMarble", "marble1", bob.Member())
+//
+// Clients hold self-signed certificates whose organizational units,
+// attributes and validity are set with CertOptions. As on a peer, the writes
+// of an invocation that returns an error are discarded, and paginated range
+// queries, which MockStub leaves out, return pages of the state. Invoke and
+// AssertCode check the outcome of an invocation in a test.
+package cctest
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// CertOptions describes the certificate of a client
+type CertOptions struct {
+	// OUs are the organizational units of the subject, "client" by default
+	OUs []string
+	// Attrs are written to the attribute extension read by cid, along with
+	// the metadata in Meta, e.g. {"dept": {"notAfter": "2019-01-01T00:00:00Z"}}
+	Attrs map[string]string
+	Meta  map[string]interface{}
+	// NotBefore and NotAfter default to a day before and a year after now
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Serial is the serial number, a new one by default
+	Serial int64
+}
+
+// Client is a client identity
+type Client struct {
+	MSPID   string
+	Cert    *x509.Certificate
+	creator []byte
+}
+
+// key signs all the certificates, which are only parsed, never verified
+var key *ecdsa.PrivateKey
+
+// serials numbers the certificates
+var serials int64
+
+func init() {
+	var err error
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// NewClient returns a client of the MSP mspID with the common name name
+func NewClient(mspID, name string) *Client {
+	return NewClientWith(mspID, name, CertOptions{})
+}
+
+// NewClientWith returns a client whose certificate is described by opts. It
+// panics if the certificate cannot be created.
+func NewClientWith(mspID, name string, opts CertOptions) *Client {
+	now := time.Now()
+	if opts.OUs == nil {
+		opts.OUs = []string{"client"}
+	}
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = now.AddDate(0, 0, -1)
+	}
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = now.AddDate(1, 0, 0)
+	}
+	if opts.Serial == 0 {
+		serials++
+		opts.Serial = serials
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(opts.Serial),
+		Subject:      pkix.Name{CommonName: name, OrganizationalUnit: opts.OUs, Organization: []string{mspID}},
+		NotBefore:    opts.NotBefore,
+		NotAfter:     opts.NotAfter,
+	}
+	if opts.Attrs != nil {
+		ext := map[string]interface{}{"attrs": opts.Attrs}
+		if opts.Meta != nil {
+			ext["meta"] = opts.Meta
+		}
+		value, err := json.Marshal(ext)
+		if err != nil {
+			panic(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attrmgr.AttrOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &Client{MSPID: mspID, Cert: cert, creator: creator}
+}
+
+// GetCreator returns the serialized identity of the client, so that a Client
+// can be passed to the functions of cid
+func (c *Client) GetCreator() ([]byte, error) {
+	return c.creator, nil
+}
+
+// Member returns the client as MSPID::ID, the ID being the one returned by
+// cid.GetID
+func (c *Client) Member() string {
+	id, err := cid.GetID(c)
+	if err != nil {
+		panic(err)
+	}
+	return c.MSPID + "::" + id
+}
+
+// Stub is a shim.MockStub that invokes the chaincode as a client
+type Stub struct {
+	*shim.MockStub
+	cc      shim.Chaincode
+	args    [][]byte
+	client  *Client
+	txTime  *timestamp.Timestamp
+	txCount int
+}
+
+// NewStub returns a stub for the chaincode cc
+func NewStub(name string, cc shim.Chaincode) *Stub {
+	return &Stub{MockStub: shim.NewMockStub(name, cc), cc: cc}
+}
+
+// As makes the following transactions submitted by client
+func (s *Stub) As(client *Client) *Stub {
+	s.client = client
+	return s
+}
+
+// At sets the timestamp of the following transactions. The zero time
+// returns to the clock.
+func (s *Stub) At(t time.Time) *Stub {
+	s.txTime = nil
+	if !t.IsZero() {
+		s.txTime = &timestamp.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+	}
+	return s
+}
+
+// Init calls the Init function of the chaincode with args
+func (s *Stub) Init(args ...string) pb.Response {
+	return s.transaction(args, s.cc.Init)
+}
+
+// Invoke calls the Invoke function of the chaincode with args, the first
+// being the function name
+func (s *Stub) Invoke(args ...string) pb.Response {
+	return s.transaction(args, s.cc.Invoke)
+}
+
+// Call runs fn in a transaction without arguments, for testing code that
+// takes a stub rather than a whole chaincode
+func (s *Stub) Call(fn func(stub shim.ChaincodeStubInterface) error) error {
+	var err error
+	s.transaction(nil, func(stub shim.ChaincodeStubInterface) pb.Response {
+		err = fn(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(nil)
+	})
+	return err
+}
+
+// Events returns the events set since the last call
+func (s *Stub) Events() []*pb.ChaincodeEvent {
+	events := []*pb.ChaincodeEvent{}
+	for {
+		select {
+		case event := <-s.ChaincodeEventsChannel:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// Invoke invokes a function of the chaincode of stub that must succeed and
+// returns its payload
+func Invoke(t testing.TB, stub *Stub, args ...string) []byte {
+	t.Helper()
+	res := stub.Invoke(args...)
+	if res.Status != shim.OK {
+		t.Fatalf("%s failed: %s", strings.Join(args, " "), res.Message)
+	}
+	return res.Payload
+}
+
+// AssertCode checks that the invocation described by what failed with code
+// and returns its error. Since every chaincode vendors its own copy of
+// errcode, code may be the errcode.Code of any copy.
+func AssertCode(t testing.TB, res pb.Response, code interface{}, what string) *errcode.Error {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Errorf("%s succeeded", what)
+		return nil
+	}
+	e := errcode.Parse(res.Message)
+	if string(e.Code) != fmt.Sprint(code) {
+		t.Errorf("%s: got %s (%s), want %s", what, e.Code, e.Message, code)
+	}
+	return e
+}
+
+// transaction runs fn in a transaction and discards its writes if it fails
+func (s *Stub) transaction(args []string, fn func(stub shim.ChaincodeStubInterface) pb.Response) pb.Response {
+	s.txCount++
+	txID := fmt.Sprintf("tx%d", s.txCount)
+	s.args = make([][]byte, len(args))
+	for i, arg := range args {
+		s.args[i] = []byte(arg)
+	}
+	state, keys := s.snapshot()
+	events := len(s.ChaincodeEventsChannel)
+
+	s.MockTransactionStart(txID)
+	if s.txTime != nil {
+		s.TxTimestamp = s.txTime
+	}
+	res := fn(s)
+	s.MockTransactionEnd(txID)
+
+	if res.Status >= shim.ERRORTHRESHOLD {
+		s.State, s.Keys = state, keys
+		for _, event := range s.Events()[:events] {
+			s.ChaincodeEventsChannel <- event
+		}
+	}
+	return res
+}
+
+// snapshot copies the state
+func (s *Stub) snapshot() (map[string][]byte, *list.List) {
+	state := make(map[string][]byte, len(s.State))
+	for key, value := range s.State {
+		state[key] = value
+	}
+	keys := list.New()
+	keys.PushBackList(s.Keys)
+	return state, keys
+}
+
+// GetCreator returns the client set with As
+func (s *Stub) GetCreator() ([]byte, error) {
+	if s.client == nil {
+		return nil, nil
+	}
+	return s.client.GetCreator()
+}
+
+// GetArgs returns the arguments of the transaction
+func (s *Stub) GetArgs() [][]byte {
+	return s.args
+}
+
+// GetStringArgs returns the arguments of the transaction as strings
+func (s *Stub) GetStringArgs() []string {
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = string(arg)
+	}
+	return args
+}
+
+// GetFunctionAndParameters returns the first argument as the function name
+// and the others as its parameters
+func (s *Stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+// GetStateByRangeWithPagination returns a page of at most pageSize keys of
+// the range [startKey, endKey), which MockStub leaves out. The page starts at
+// bookmark, if set, and the bookmark of the next page is its first key.
+func (s *Stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(bookmark) > 0 {
+		startKey = bookmark
+	}
+	if len(startKey) == 0 {
+		// as in the shim, an empty start key skips the composite keys
+		startKey = "\x01"
+	}
+	page := &pageIterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for e := s.Keys.Front(); e != nil; e = e.Next() {
+		key := e.Value.(string)
+		if key < startKey || (len(endKey) > 0 && key >= endKey) {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = key
+			break
+		}
+		page.kvs = append(page.kvs, &queryresult.KV{Namespace: s.Name, Key: key, Value: s.State[key]})
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+	return page, metadata, nil
+}
+
+// pageIterator iterates over a page of results
+type pageIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (p *pageIterator) HasNext() bool {
+	return len(p.kvs) > 0
+}
+
+func (p *pageIterator) Next() (*queryresult.KV, error) {
+	if len(p.kvs) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := p.kvs[0]
+	p.kvs = p.kvs[1:]
+	return kv, nil
+}
+
+func (p *pageIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package errcode defines the error model shared by the sample chaincodes.
+// Every failed invocation returns, through shim.Error, a JSON document with
+// a stable code, a human readable message and optional details:
+//
+//	{"code":"NOT_FOUND","message":"Marble does not exist: marble1","details":{"name":"marble1"}}
+//
+// Client applications branch on the code; the message is meant for people
+// and may change between versions.
+//
+// Functions build errors with the constructor matching their code and hand
+// them back as they would any other error:
+//
+//	if marbleAsBytes == nil {
+//		return errcode.NotFound("Marble does not exist: %s", name).With("name", name).Response()
+//	}
+//
+// Errors that did not originate from this package, such as those returned
+// by the shim, are reported with the INTERNAL code by Response.
+package errcode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Code classifies an error. Codes are part of the chaincode API and never
+// change once published.
+type Code string
+
+// Well-known error codes
+const (
+	// CodeNotFound means the requested entity does not exist
+	CodeNotFound Code = "NOT_FOUND"
+	// CodeAlreadyExists means the entity to create exists already
+	CodeAlreadyExists Code = "ALREADY_EXISTS"
+	// CodeInvalidArgument means the arguments are malformed, whatever the
+	// state of the ledger
+	CodeInvalidArgument Code = "INVALID_ARGUMENT"
+	// CodeUnauthorized means the invoker is not allowed to do what it asked
+	CodeUnauthorized Code = "UNAUTHORIZED"
+	// CodeConflict means the request is well formed but cannot be applied
+	// to the current state, e.g. insufficient funds or a locked entity
+	CodeConflict Code = "CONFLICT"
+	// CodeInternal is used for unexpected failures, typically errors
+	// returned by the shim
+	CodeInternal Code = "INTERNAL"
+)
+
+// Error is a classified chaincode error
+type Error struct {
+	Code    Code                   `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// New returns an error with the given code and a formatted message
+func New(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// NotFound returns a NOT_FOUND error
+func NotFound(format string, a ...interface{}) *Error {
+	return New(CodeNotFound, format, a...)
+}
+
+// AlreadyExists returns an ALREADY_EXISTS error
+func AlreadyExists(format string, a ...interface{}) *Error {
+	return New(CodeAlreadyExists, format, a...)
+}
+
+// InvalidArgument returns an INVALID_ARGUMENT error
+func InvalidArgument(format string, a ...interface{}) *Error {
+	return New(CodeInvalidArgument, format, a...)
+}
+
+// Unauthorized returns an UNAUTHORIZED error
+func Unauthorized(format string, a ...interface{}) *Error {
+	return New(CodeUnauthorized, format, a...)
+}
+
+// Conflict returns a CONFLICT error
+func Conflict(format string, a ...interface{}) *Error {
+	return New(CodeConflict, format, a...)
+}
+
+// Internal returns an INTERNAL error
+func Internal(format string, a ...interface{}) *Error {
+	return New(CodeInternal, format, a...)
+}
+
+// Wrap returns an error with the code and details of err and its message
+// prefixed with the formatted context, e.g. "Transfer failed: Marble does
+// not exist: marble1"
+func Wrap(err error, format string, a ...interface{}) *Error {
+	cause := From(err)
+	return &Error{Code: cause.Code, Message: fmt.Sprintf(format, a...) + ": " + cause.Message, Details: cause.Details}
+}
+
+// With adds a detail to the error and returns it
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// Error returns the message, so that wrapping an Error with fmt.Errorf
+// reads naturally. The code is kept only as long as the *Error is.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// JSON returns the error as it is sent to clients
+func (e *Error) JSON() string {
+	errorJSONasBytes, err := json.Marshal(e)
+	if err != nil {
+		// a detail could not be marshaled, drop the details
+		errorJSONasBytes, _ = json.Marshal(&Error{Code: e.Code, Message: e.Message})
+	}
+	return string(errorJSONasBytes)
+}
+
+// Response returns the error as a chaincode error response
+func (e *Error) Response() pb.Response {
+	return shim.Error(e.JSON())
+}
+
+// Response returns any error as a chaincode error response. Errors that are
+// not an *Error are reported as INTERNAL.
+func Response(err error) pb.Response {
+	return From(err).Response()
+}
+
+// From returns err if it is an *Error, and an INTERNAL error with the same
+// message otherwise
+func From(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Code: CodeInternal, Message: err.Error()}
+}
+
+// CodeOf returns the code of an error, INTERNAL for errors that are not an
+// *Error and the empty code for nil
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	return From(err).Code
+}
+
+// Parse decodes the message of an error response, e.g. one returned by
+// another chaincode through InvokeChaincode. Messages that are not in the
+// error format are returned as INTERNAL errors.
+func Parse(message string) *Error {
+	e := &Error{}
+	if json.Unmarshal([]byte(message), e) != nil || len(e.Code) == 0 {
+		return &Error{Code: CodeInternal, Message: message}
+	}
+	return e
+}
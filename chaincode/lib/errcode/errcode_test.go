@@ -0,0 +1,102 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestResponseRoundTrip(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		code Code
+	}{
+		{NotFound("Marble does not exist: %s", "marble1").With("name", "marble1"), CodeNotFound},
+		{AlreadyExists("exists"), CodeAlreadyExists},
+		{InvalidArgument("bad").With("param", "size"), CodeInvalidArgument},
+		{Unauthorized("no"), CodeUnauthorized},
+		{Conflict("Insufficient funds").With("balance", "10").With("amount", "20"), CodeConflict},
+		{Internal("boom"), CodeInternal},
+		{New("CUSTOM", "custom"), Code("CUSTOM")},
+	}
+	for _, test := range tests {
+		res := test.err.Response()
+		if res.Status != shim.ERROR {
+			t.Errorf("%s: got status %d", test.code, res.Status)
+		}
+		parsed := Parse(res.Message)
+		if parsed.Code != test.code || parsed.Message != test.err.Message {
+			t.Errorf("%s: parsed %+v from %s", test.code, parsed, res.Message)
+		}
+		if len(parsed.Details) != len(test.err.Details) {
+			t.Errorf("%s: parsed details %v, want %v", test.code, parsed.Details, test.err.Details)
+		}
+		for key, value := range test.err.Details {
+			if parsed.Details[key] != value {
+				t.Errorf("%s: detail %s is %v, want %v", test.code, key, parsed.Details[key], value)
+			}
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	e := NotFound("Marble does not exist: %s", "marble1").With("name", "marble1")
+	want := `{"code":"NOT_FOUND","message":"Marble does not exist: marble1","details":{"name":"marble1"}}`
+	if e.JSON() != want {
+		t.Errorf("got %s, want %s", e.JSON(), want)
+	}
+	if e.Error() != "Marble does not exist: marble1" {
+		t.Errorf("Error returned %s", e.Error())
+	}
+}
+
+func TestUnmarshalableDetailsAreDropped(t *testing.T) {
+	e := Internal("boom").With("channel", make(chan int))
+	parsed := Parse(e.JSON())
+	if parsed.Code != CodeInternal || parsed.Message != "boom" || parsed.Details != nil {
+		t.Errorf("parsed %+v", parsed)
+	}
+}
+
+func TestOtherErrorsAreInternal(t *testing.T) {
+	err := errors.New("shim failure")
+	if CodeOf(err) != CodeInternal {
+		t.Errorf("CodeOf returned %s", CodeOf(err))
+	}
+	parsed := Parse(Response(err).Message)
+	if parsed.Code != CodeInternal || parsed.Message != "shim failure" {
+		t.Errorf("parsed %+v", parsed)
+	}
+	if CodeOf(nil) != "" {
+		t.Errorf("CodeOf(nil) returned %s", CodeOf(nil))
+	}
+	if CodeOf(fmt.Errorf("wrapped: %s", Conflict("locked"))) != CodeInternal {
+		t.Error("an error wrapped with fmt.Errorf kept its code")
+	}
+}
+
+func TestParseMessagesInOtherFormats(t *testing.T) {
+	for _, message := range []string{"plain text", `{"message":"no code"}`, ""} {
+		parsed := Parse(message)
+		if parsed.Code != CodeInternal || parsed.Message != message {
+			t.Errorf("%q: parsed %+v", message, parsed)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	e := Wrap(NotFound("Marble does not exist: marble1").With("name", "marble1"), "Transfer failed")
+	if e.Code != CodeNotFound || e.Message != "Transfer failed: Marble does not exist: marble1" || e.Details["name"] != "marble1" {
+		t.Errorf("got %+v", e)
+	}
+	e = Wrap(errors.New("shim failure"), "Transfer %s failed", "marble1")
+	if e.Code != CodeInternal || e.Message != "Transfer marble1 failed: shim failure" {
+		t.Errorf("got %+v", e)
+	}
+}
@@ -0,0 +1,307 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package identity
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/common/attrmgr"
+)
+
+// The attributes of a client are read from the certificate extension that
+// attrmgr writes. Next to the values, the extension may hold metadata about
+// some of the attributes, which attrmgr itself ignores and EncodeAttributes
+// writes:
+//
+//	{"attrs":{"dept":"finance"},"meta":{"dept":{"notAfter":"2019-01-01T00:00:00Z","issuer":"ca.org1.example.com"}}}
+//
+// Attributes whose NotAfter time is before the transaction timestamp are
+// ignored, as if the certificate did not have them.
+//
+// attrmgr and cid are vendored from Fabric and only return strings, so the
+// typed getters (IntValue, BoolValue, TimeValue, ListValue and ContainsValue)
+// are methods of Attributes in this package, not of attrmgr.Attributes, and
+// the matching assertions are methods of Identity, not functions of cid.
+
+// AttributeMetadata describes the validity and provenance of an attribute.
+// All fields are optional.
+type AttributeMetadata struct {
+	// NotAfter is the time after which the attribute is no longer valid
+	NotAfter *time.Time `json:"notAfter,omitempty"`
+	// Issuer identifies the CA that issued the attribute
+	Issuer string `json:"issuer,omitempty"`
+	// Registrar is the identity that registered the attribute
+	Registrar string `json:"registrar,omitempty"`
+}
+
+// Attributes holds the attributes of a certificate and their metadata
+type Attributes struct {
+	Attrs map[string]string             `json:"attrs"`
+	Meta  map[string]*AttributeMetadata `json:"meta,omitempty"`
+}
+
+// EncodeAttributes returns the value of the attribute extension of a
+// certificate, whose identifier is attrmgr.AttrOID, for attrs and their
+// metadata. Without metadata the value is the one attrmgr writes, and readers
+// that only know attrmgr ignore the metadata. Metadata is only allowed for
+// attributes that attrs has; empty metadata is left out.
+func EncodeAttributes(attrs *Attributes) ([]byte, error) {
+	encoded := &Attributes{Attrs: attrs.Attrs}
+	if encoded.Attrs == nil {
+		encoded.Attrs = map[string]string{}
+	}
+	for name, meta := range attrs.Meta {
+		if _, ok := encoded.Attrs[name]; !ok {
+			return nil, errcode.InvalidArgument("Attribute '%s' has metadata but no value", name).With("attribute", name)
+		}
+		if meta == nil || *meta == (AttributeMetadata{}) {
+			continue
+		}
+		if encoded.Meta == nil {
+			encoded.Meta = map[string]*AttributeMetadata{}
+		}
+		encoded.Meta[name] = meta
+	}
+	return json.Marshal(encoded)
+}
+
+// attributesFromCert reads the attribute extension of a certificate. A
+// certificate without the extension has no attributes.
+func attributesFromCert(cert *x509.Certificate) (*Attributes, error) {
+	attrs := &Attributes{Attrs: map[string]string{}}
+	for _, ext := range cert.Extensions {
+		if ext.Id.String() != attrmgr.AttrOIDString {
+			continue
+		}
+		err := json.Unmarshal(ext.Value, attrs)
+		if err != nil {
+			return nil, errcode.Unauthorized("Failed to unmarshal the attributes of the client's certificate: %s", err)
+		}
+		if attrs.Attrs == nil {
+			attrs.Attrs = map[string]string{}
+		}
+	}
+	return attrs, nil
+}
+
+// validAt returns a copy of the attributes without those that expired
+// before t
+func (a *Attributes) validAt(t time.Time) *Attributes {
+	valid := &Attributes{Attrs: map[string]string{}, Meta: map[string]*AttributeMetadata{}}
+	for name, value := range a.Attrs {
+		meta := a.Meta[name]
+		if meta != nil && meta.NotAfter != nil && meta.NotAfter.Before(t) {
+			continue
+		}
+		valid.Attrs[name] = value
+		if meta != nil {
+			valid.Meta[name] = meta
+		}
+	}
+	return valid
+}
+
+// Value returns the value of attribute name
+func (a *Attributes) Value(name string) (string, bool) {
+	value, ok := a.Attrs[name]
+	return value, ok
+}
+
+// Metadata returns the metadata of attribute name, or nil if it has none
+func (a *Attributes) Metadata(name string) *AttributeMetadata {
+	return a.Meta[name]
+}
+
+// IntValue returns the value of attribute name as an integer
+func (a *Attributes) IntValue(name string) (int, bool, error) {
+	value, ok := a.Value(name)
+	if !ok {
+		return 0, false, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, true, errcode.Unauthorized("Attribute '%s' equals '%s', which is not an integer", name, value).With("attribute", name)
+	}
+	return i, true, nil
+}
+
+// BoolValue returns the value of attribute name as a boolean. The values
+// accepted are those of strconv.ParseBool, e.g. "true" and "false".
+func (a *Attributes) BoolValue(name string) (bool, bool, error) {
+	value, ok := a.Value(name)
+	if !ok {
+		return false, false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, true, errcode.Unauthorized("Attribute '%s' equals '%s', which is not a boolean", name, value).With("attribute", name)
+	}
+	return b, true, nil
+}
+
+// TimeValue returns the value of attribute name as a time. The value must be
+// in RFC 3339 format, e.g. "2018-06-30T00:00:00Z".
+func (a *Attributes) TimeValue(name string) (time.Time, bool, error) {
+	value, ok := a.Value(name)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, true, errcode.Unauthorized("Attribute '%s' equals '%s', which is not an RFC 3339 time", name, value).With("attribute", name)
+	}
+	return t, true, nil
+}
+
+// ListValue returns the value of attribute name as a list of strings. The
+// value is either a JSON array of strings, e.g. '["eu","us"]', or a comma
+// separated list, e.g. "eu,us". Spaces around the items of a comma
+// separated list are ignored, and an empty value is an empty list.
+func (a *Attributes) ListValue(name string) ([]string, bool, error) {
+	value, ok := a.Value(name)
+	if !ok {
+		return nil, false, nil
+	}
+	list, err := parseList(value)
+	if err != nil {
+		return nil, true, errcode.Unauthorized("Attribute '%s' equals '%s', which is not a JSON array of strings", name, value).With("attribute", name)
+	}
+	return list, true, nil
+}
+
+// ContainsValue checks to see if the list attribute name contains value. An
+// attribute that is not found contains nothing.
+func (a *Attributes) ContainsValue(name, value string) (bool, error) {
+	list, _, err := a.ListValue(name)
+	if err != nil {
+		return false, err
+	}
+	return contains(list, value), nil
+}
+
+// parseList parses a list value, see ListValue
+func parseList(value string) ([]string, error) {
+	list := []string{}
+	if strings.HasPrefix(strings.TrimSpace(value), "[") {
+		err := json.Unmarshal([]byte(value), &list)
+		return list, err
+	}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+// Attributes returns the attributes of the client that have not expired
+func (id *Identity) Attributes() *Attributes {
+	return id.attrs
+}
+
+// GetAttributeValue returns the value of an attribute of the client that has
+// not expired
+func (id *Identity) GetAttributeValue(attrName string) (value string, found bool, err error) {
+	value, found = id.attrs.Value(attrName)
+	return value, found, nil
+}
+
+// AssertAttributeValue checks to see if an attribute of the client that has
+// not expired equals attrValue
+func (id *Identity) AssertAttributeValue(attrName, attrValue string) error {
+	value, ok := id.attrs.Value(attrName)
+	if !ok {
+		return attributeNotFound(attrName)
+	}
+	if value != attrValue {
+		return errcode.Unauthorized("Attribute '%s' equals '%s', not '%s'", attrName, value, attrValue).With("attribute", attrName)
+	}
+	return nil
+}
+
+// AssertIntAttributeAtLeast checks to see if an integer attribute is at
+// least min
+func (id *Identity) AssertIntAttributeAtLeast(attrName string, min int) error {
+	value, ok, err := id.attrs.IntValue(attrName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return attributeNotFound(attrName)
+	}
+	if value < min {
+		return errcode.Unauthorized("Attribute '%s' equals %d, which is less than %d", attrName, value, min).With("attribute", attrName)
+	}
+	return nil
+}
+
+// AssertBoolAttribute checks to see if a boolean attribute is true
+func (id *Identity) AssertBoolAttribute(attrName string) error {
+	value, ok, err := id.attrs.BoolValue(attrName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return attributeNotFound(attrName)
+	}
+	if !value {
+		return errcode.Unauthorized("Attribute '%s' is not true", attrName).With("attribute", attrName)
+	}
+	return nil
+}
+
+// AssertTimeAttributeAfter checks to see if a time attribute is after t
+func (id *Identity) AssertTimeAttributeAfter(attrName string, t time.Time) error {
+	value, ok, err := id.attrs.TimeValue(attrName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return attributeNotFound(attrName)
+	}
+	if !value.After(t) {
+		return errcode.Unauthorized("Attribute '%s' equals %s, which is not after %s", attrName, value.Format(time.RFC3339), t.Format(time.RFC3339)).
+			With("attribute", attrName)
+	}
+	return nil
+}
+
+// AssertAttributeIn checks to see if an attribute equals one of values
+func (id *Identity) AssertAttributeIn(attrName string, values ...string) error {
+	value, ok := id.attrs.Value(attrName)
+	if !ok {
+		return attributeNotFound(attrName)
+	}
+	if !contains(values, value) {
+		return errcode.Unauthorized("Attribute '%s' equals '%s', which is not one of %v", attrName, value, values).With("attribute", attrName)
+	}
+	return nil
+}
+
+// AssertListAttributeContains checks to see if a list attribute contains
+// value
+func (id *Identity) AssertListAttributeContains(attrName, value string) error {
+	if _, ok := id.attrs.Value(attrName); !ok {
+		return attributeNotFound(attrName)
+	}
+	ok, err := id.attrs.ContainsValue(attrName, value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errcode.Unauthorized("Attribute '%s' does not contain '%s'", attrName, value).With("attribute", attrName)
+	}
+	return nil
+}
+
+func attributeNotFound(attrName string) error {
+	return errcode.Unauthorized("Attribute '%s' was not found", attrName).With("attribute", attrName)
+}
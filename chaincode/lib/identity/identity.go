@@ -0,0 +1,326 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package identity extends the client identity library cid, which it uses
+// unmodified, with checks that need the configuration of the MSPs or the
+// transaction: organizational units and NodeOU roles, typed attributes and
+// attribute expiry, policy expressions, certificate validity and a deny-list
+// kept on the ledger.
+//
+// NodeOU roles are given by organizational units whose identifiers each MSP
+// sets in the NodeOUs section of its config.yaml. A chaincode declares them
+// once, with the identifiers used by most MSPs and those of the MSPs that
+// differ:
+//
+//	var identities = identity.New(identity.DefaultNodeOUs).
+//		WithNodeOUs("Org3MSP", identity.NodeOUs{Client: "app", Peer: "node"})
+//
+// and checks the client submitting a transaction through it:
+//
+//	id, err := identities.Get(stub)
+//	...
+//	err = id.AssertPolicy("dept = finance AND ROLE(client)")
+//
+// Get, and so every check made through a Checker, rejects certificates that
+// are not valid at the transaction timestamp.
+//
+// Like cid, the package also has functions that take the stub, for chaincodes
+// whose MSPs all use DefaultNodeOUs:
+//
+//	err := identity.AssertOUValue(stub, "department1")
+//	err = identity.AssertRole(stub, identity.RoleAdmin)
+//
+// The deny-list is only read when a chaincode asks for it, with
+// AssertNotRevoked (see revocation.go).
+package identity
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// NodeOU roles
+const (
+	RoleClient  = "client"
+	RolePeer    = "peer"
+	RoleAdmin   = "admin"
+	RoleOrderer = "orderer"
+)
+
+// roleOrder is the order in which GetRole looks for the roles
+var roleOrder = []string{RoleClient, RolePeer, RoleAdmin, RoleOrderer}
+
+// NodeOUs holds the organizational unit identifiers an MSP gives to the
+// NodeOU roles, as in the NodeOUs section of its config.yaml. An empty
+// identifier means the MSP does not give that role.
+type NodeOUs struct {
+	Client  string `json:"client,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	Admin   string `json:"admin,omitempty"`
+	Orderer string `json:"orderer,omitempty"`
+}
+
+// DefaultNodeOUs are the identifiers of the MSPs generated for the samples
+var DefaultNodeOUs = NodeOUs{Client: "client", Peer: "peer", Admin: "admin", Orderer: "orderer"}
+
+// identifier returns the organizational unit identifier of a role
+func (ous NodeOUs) identifier(role string) string {
+	switch role {
+	case RoleClient:
+		return ous.Client
+	case RolePeer:
+		return ous.Peer
+	case RoleAdmin:
+		return ous.Admin
+	case RoleOrderer:
+		return ous.Orderer
+	}
+	return ""
+}
+
+// Checker gets client identities along with the NodeOU configuration of
+// their MSP
+type Checker struct {
+	defaults NodeOUs
+	nodeOUs  map[string]NodeOUs
+}
+
+// New returns a Checker for MSPs that use the NodeOU identifiers defaults
+func New(defaults NodeOUs) *Checker {
+	return &Checker{defaults: defaults, nodeOUs: map[string]NodeOUs{}}
+}
+
+// WithNodeOUs sets the NodeOU identifiers of one MSP and returns the Checker
+func (c *Checker) WithNodeOUs(mspID string, ous NodeOUs) *Checker {
+	c.nodeOUs[mspID] = ous
+	return c
+}
+
+// NodeOUs returns the NodeOU identifiers of an MSP
+func (c *Checker) NodeOUs(mspID string) NodeOUs {
+	if ous, ok := c.nodeOUs[mspID]; ok {
+		return ous
+	}
+	return c.defaults
+}
+
+// Identity is the client that submitted a transaction. The methods of
+// cid.ClientIdentity that read attributes are replaced by ones that ignore
+// expired attributes (see attributes.go).
+type Identity struct {
+	cid.ClientIdentity
+	stub    shim.ChaincodeStubInterface
+	mspID   string
+	cert    *x509.Certificate
+	nodeOUs NodeOUs
+	attrs   *Attributes
+	txTime  time.Time
+}
+
+// Get returns the identity of the client that submitted the transaction, or
+// an UNAUTHORIZED error if its certificate is not valid at the transaction
+// timestamp
+func (c *Checker) Get(stub shim.ChaincodeStubInterface) (*Identity, error) {
+	ci, err := cid.New(stub)
+	if err != nil {
+		return nil, errcode.Unauthorized("Failed to get the client identity: %s", err)
+	}
+	mspID, err := ci.GetMSPID()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ci.GetX509Certificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert == nil {
+		return nil, errcode.Unauthorized("The client was not identified by an X509 certificate")
+	}
+	txTime, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := attributesFromCert(cert)
+	if err != nil {
+		return nil, err
+	}
+	id := &Identity{
+		ClientIdentity: ci,
+		stub:           stub,
+		mspID:          mspID,
+		cert:           cert,
+		nodeOUs:        c.NodeOUs(mspID),
+		attrs:          attrs.validAt(txTime),
+		txTime:         txTime,
+	}
+	err = id.AssertCertValidAt(txTime)
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// AssertRole checks to see if the client has the NodeOU role role
+func (c *Checker) AssertRole(stub shim.ChaincodeStubInterface, role string) error {
+	id, err := c.Get(stub)
+	if err != nil {
+		return err
+	}
+	return id.AssertRole(role)
+}
+
+// AssertAttributeValue checks to see if an attribute of the client that has
+// not expired equals attrValue
+func (c *Checker) AssertAttributeValue(stub shim.ChaincodeStubInterface, attrName, attrValue string) error {
+	id, err := c.Get(stub)
+	if err != nil {
+		return err
+	}
+	return id.AssertAttributeValue(attrName, attrValue)
+}
+
+// AssertPolicy checks to see if the client satisfies a policy expression
+func (c *Checker) AssertPolicy(stub shim.ChaincodeStubInterface, expr string) error {
+	id, err := c.Get(stub)
+	if err != nil {
+		return err
+	}
+	return id.AssertPolicy(expr)
+}
+
+// defaultChecker is used by the functions that take a stub
+var defaultChecker = New(DefaultNodeOUs)
+
+// GetOUs returns the organizational units of the client's certificate
+func GetOUs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	id, err := defaultChecker.Get(stub)
+	if err != nil {
+		return nil, err
+	}
+	return id.GetOUs(), nil
+}
+
+// HasOUValue returns true if the client's certificate has the organizational
+// unit ou
+func HasOUValue(stub shim.ChaincodeStubInterface, ou string) (bool, error) {
+	id, err := defaultChecker.Get(stub)
+	if err != nil {
+		return false, err
+	}
+	return id.HasOUValue(ou), nil
+}
+
+// AssertOUValue checks to see if the client's certificate has the
+// organizational unit ou
+func AssertOUValue(stub shim.ChaincodeStubInterface, ou string) error {
+	id, err := defaultChecker.Get(stub)
+	if err != nil {
+		return err
+	}
+	return id.AssertOUValue(ou)
+}
+
+// GetRole returns the NodeOU role of the client under DefaultNodeOUs, or ""
+// if it has none
+func GetRole(stub shim.ChaincodeStubInterface) (string, error) {
+	id, err := defaultChecker.Get(stub)
+	if err != nil {
+		return "", err
+	}
+	return id.GetRole(), nil
+}
+
+// AssertRole checks to see if the client has the NodeOU role role under
+// DefaultNodeOUs
+func AssertRole(stub shim.ChaincodeStubInterface, role string) error {
+	return defaultChecker.AssertRole(stub, role)
+}
+
+// TxTime returns the transaction timestamp, at which attributes and the
+// certificate are checked
+func (id *Identity) TxTime() time.Time {
+	return id.txTime
+}
+
+// GetOUs returns the organizational units of the client's certificate, in
+// the order they appear in it
+func (id *Identity) GetOUs() []string {
+	return id.cert.Subject.OrganizationalUnit
+}
+
+// HasOUValue returns true if the client's certificate has the organizational
+// unit ou
+func (id *Identity) HasOUValue(ou string) bool {
+	return contains(id.cert.Subject.OrganizationalUnit, ou)
+}
+
+// AssertOUValue checks to see if the client's certificate has the
+// organizational unit ou
+func (id *Identity) AssertOUValue(ou string) error {
+	if !id.HasOUValue(ou) {
+		return errcode.Unauthorized("Organizational unit '%s' was not found, the certificate has %v", ou, id.GetOUs()).With("ou", ou)
+	}
+	return nil
+}
+
+// GetRole returns the NodeOU role of the client, or "" if its certificate
+// has none of the organizational units its MSP gives to the roles
+func (id *Identity) GetRole() string {
+	for _, role := range roleOrder {
+		ou := id.nodeOUs.identifier(role)
+		if len(ou) > 0 && id.HasOUValue(ou) {
+			return role
+		}
+	}
+	return ""
+}
+
+// AssertRole checks to see if the client has the NodeOU role role
+func (id *Identity) AssertRole(role string) error {
+	if !contains(roleOrder, role) {
+		return errcode.InvalidArgument("'%s' is not a NodeOU role", role).With("role", role)
+	}
+	actual := id.GetRole()
+	if len(actual) == 0 {
+		return errcode.Unauthorized("The client has no NodeOU role, expected '%s'", role).With("role", role)
+	}
+	if actual != role {
+		return errcode.Unauthorized("The client has role '%s', not '%s'", actual, role).With("role", role)
+	}
+	return nil
+}
+
+// AssertCertValidAt checks that the client's certificate is valid at t,
+// i.e. not before its NotBefore nor after its NotAfter time
+func (id *Identity) AssertCertValidAt(t time.Time) error {
+	if t.Before(id.cert.NotBefore) {
+		return errcode.Unauthorized("The client's certificate is not valid before %s", id.cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if t.After(id.cert.NotAfter) {
+		return errcode.Unauthorized("The client's certificate expired at %s", id.cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// txTime returns the transaction timestamp set by the client
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package identity
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/common/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var identities = New(DefaultNodeOUs).
+	WithNodeOUs("Org3MSP", NodeOUs{Client: "app", Peer: "node"})
+
+// get returns the identity of client in a transaction of stub
+func get(t *testing.T, stub *cctest.Stub, client *cctest.Client) *Identity {
+	var id *Identity
+	err := stub.As(client).Call(func(stub shim.ChaincodeStubInterface) error {
+		var err error
+		id, err = identities.Get(stub)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	return id
+}
+
+func TestRolesUseTheConfiguredNodeOUs(t *testing.T) {
+	stub := cctest.NewStub("identity", nil)
+	tests := []struct {
+		mspID string
+		ous   []string
+		role  string
+	}{
+		{"Org1MSP", []string{"client"}, RoleClient},
+		{"Org1MSP", []string{"admin"}, RoleAdmin},
+		{"Org1MSP", []string{"department1", "peer"}, RolePeer},
+		{"Org1MSP", []string{"app"}, ""},
+		{"Org3MSP", []string{"app"}, RoleClient},
+		{"Org3MSP", []string{"node"}, RolePeer},
+		{"Org3MSP", []string{"client"}, ""},
+		{"Org3MSP", []string{"admin"}, ""},
+	}
+	for _, test := range tests {
+		id := get(t, stub, cctest.NewClientWith(test.mspID, "user", cctest.CertOptions{OUs: test.ous}))
+		if role := id.GetRole(); role != test.role {
+			t.Errorf("%s %v: got role %q, want %q", test.mspID, test.ous, role, test.role)
+		}
+		err := id.AssertRole(RoleClient)
+		if (err == nil) != (test.role == RoleClient) {
+			t.Errorf("%s %v: AssertRole(client) returned %v", test.mspID, test.ous, err)
+		}
+	}
+
+	id := get(t, stub, cctest.NewClient("Org1MSP", "user"))
+	if errcode.CodeOf(id.AssertRole("auditor")) != errcode.CodeInvalidArgument {
+		t.Error("AssertRole accepted a role that is not a NodeOU role")
+	}
+	if !id.HasOUValue("client") || id.AssertOUValue("department1") == nil {
+		t.Errorf("wrong OUs: %v", id.GetOUs())
+	}
+}
+
+func TestFunctionsThatTakeTheStub(t *testing.T) {
+	client := cctest.NewClientWith("Org1MSP", "admin", cctest.CertOptions{OUs: []string{"department1", "admin"}})
+	err := cctest.NewStub("identity", nil).As(client).Call(func(stub shim.ChaincodeStubInterface) error {
+		if ous, err := GetOUs(stub); err != nil || len(ous) != 2 {
+			t.Errorf("GetOUs returned %v, %v", ous, err)
+		}
+		if ok, err := HasOUValue(stub, "department2"); err != nil || ok {
+			t.Errorf("HasOUValue(department2) returned %v, %v", ok, err)
+		}
+		if err := AssertOUValue(stub, "department2"); errcode.CodeOf(err) != errcode.CodeUnauthorized {
+			t.Errorf("AssertOUValue(department2) returned %v", err)
+		}
+		if role, err := GetRole(stub); err != nil || role != RoleAdmin {
+			t.Errorf("GetRole returned %q, %v", role, err)
+		}
+		if err := AssertRole(stub, RoleClient); errcode.CodeOf(err) != errcode.CodeUnauthorized {
+			t.Errorf("AssertRole(client) returned %v", err)
+		}
+		if err := AssertOUValue(stub, "department1"); err != nil {
+			return err
+		}
+		return AssertRole(stub, RoleAdmin)
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	client := cctest.NewClientWith("Org1MSP", "user", cctest.CertOptions{
+		OUs:   []string{"client", "contractors"},
+		Attrs: map[string]string{"dept": "finance", "level": "3", "regions": "emea,apac"},
+	})
+	id := get(t, cctest.NewStub("identity", nil), client)
+
+	satisfied := []string{
+		"dept = finance",
+		"(dept = finance AND level >= 3) OR role = auditor",
+		"level == 3.0 AND level != 4",
+		"MSP(Org1MSP, Org2MSP) AND OU(contractors)",
+		"dept IN (hr, finance) AND ROLE(client)",
+		"regions CONTAINS apac",
+		"NOT ROLE(admin)",
+		`'dept' = "finance"`,
+		"dept = finance and not level < 3",
+	}
+	for _, expr := range satisfied {
+		if err := id.AssertPolicy(expr); err != nil {
+			t.Errorf("%s: %s", expr, err)
+		}
+	}
+
+	unsatisfied := map[string]string{
+		"dept = hr":                              "clause 'dept = hr' failed",
+		"level > 3":                              "attribute 'level' equals '3'",
+		"role = auditor":                         "attribute 'role' was not found",
+		"dept = finance AND NOT OU(contractors)": "clause 'NOT OU(contractors)' failed",
+		"MSP(Org2MSP) OR ROLE(peer)":             "none of the alternatives",
+		"dept > 1":                               "which is not a number",
+	}
+	for expr, reason := range unsatisfied {
+		err := id.AssertPolicy(expr)
+		if errcode.CodeOf(err) != errcode.CodeUnauthorized || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: got %v, want an error containing %q", expr, err, reason)
+		}
+	}
+
+	invalid := []string{"", "dept =", "dept > finance", "(dept = finance", "dept = finance)", "AND dept = x", "dept ! x", "dept = 'x", "MSP(a b)"}
+	for _, expr := range invalid {
+		if _, err := ParsePolicy(expr); errcode.CodeOf(err) != errcode.CodeInvalidArgument {
+			t.Errorf("%q: ParsePolicy returned %v", expr, err)
+		}
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	p, err := ParsePolicy(`(a = 1 or b = "x y") and not c IN (d, and)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `(a = 1 OR b = "x y") AND NOT c IN (d, "and")`
+	if p.root.String() != want {
+		t.Errorf("got %s, want %s", p.root, want)
+	}
+}
+
+func TestCertificateValidity(t *testing.T) {
+	now := time.Now()
+	client := cctest.NewClientWith("Org1MSP", "user", cctest.CertOptions{
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour),
+	})
+	id := get(t, cctest.NewStub("identity", nil).At(now), client)
+
+	if err := id.AssertCertValidAt(id.TxTime()); err != nil {
+		t.Errorf("the certificate is not valid at the transaction time: %s", err)
+	}
+	for _, at := range []time.Time{now.Add(-2 * time.Hour), now.Add(2 * time.Hour)} {
+		if errcode.CodeOf(id.AssertCertValidAt(at)) != errcode.CodeUnauthorized {
+			t.Errorf("the certificate is valid at %s", at)
+		}
+	}
+}
+
+func TestDenyList(t *testing.T) {
+	client := cctest.NewClientWith("Org1MSP", "alice", cctest.CertOptions{Serial: 0x1e4998e9})
+	stub := cctest.NewStub("identity", nil).As(client)
+	assertNotRevoked := func(stub shim.ChaincodeStubInterface) error {
+		return identities.AssertNotRevoked(stub)
+	}
+
+	if err := stub.Call(assertNotRevoked); err != nil {
+		t.Fatalf("the client is revoked before any revocation: %s", err)
+	}
+
+	revocations := []Revocation{
+		{Kind: RevokedSerial, MSPID: "Org1MSP", Value: "1E:49:98:E9", Reason: "key compromise"},
+		{Kind: RevokedEnrollmentID, MSPID: "Org1MSP", Value: "alice"},
+	}
+	for _, r := range revocations {
+		err := stub.Call(func(stub shim.ChaincodeStubInterface) error { return Revoke(stub, r) })
+		if err != nil {
+			t.Fatalf("Revoke %s failed: %s", r.Kind, err)
+		}
+		err = stub.Call(assertNotRevoked)
+		if errcode.CodeOf(err) != errcode.CodeUnauthorized {
+			t.Errorf("%s revoked: AssertNotRevoked returned %v", r.Kind, err)
+		}
+		err = stub.Call(func(stub shim.ChaincodeStubInterface) error {
+			return Unrevoke(stub, r.Kind, r.MSPID, r.Value)
+		})
+		if err != nil {
+			t.Fatalf("Unrevoke %s failed: %s", r.Kind, err)
+		}
+		if err := stub.Call(assertNotRevoked); err != nil {
+			t.Errorf("%s unrevoked: %s", r.Kind, err)
+		}
+	}
+
+	// Entries of other MSPs do not apply
+	err := stub.Call(func(stub shim.ChaincodeStubInterface) error {
+		return Revoke(stub, Revocation{Kind: RevokedEnrollmentID, MSPID: "Org2MSP", Value: "alice"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stub.Call(assertNotRevoked); err != nil {
+		t.Errorf("revoked by an entry of another MSP: %s", err)
+	}
+
+	err = stub.Call(func(stub shim.ChaincodeStubInterface) error {
+		list, err := ListRevocations(stub)
+		if err == nil && (len(list) != 1 || list[0].MSPID != "Org2MSP") {
+			t.Errorf("ListRevocations returned %+v", list)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []Revocation{
+		{Kind: "name", MSPID: "Org1MSP", Value: "alice"},
+		{Kind: RevokedSerial, MSPID: "Org1MSP", Value: "xyz"},
+		{Kind: RevokedEnrollmentID, MSPID: "Org1MSP"},
+		{Kind: RevokedEnrollmentID, Value: "alice"},
+	}
+	for _, r := range invalid {
+		err := stub.Call(func(stub shim.ChaincodeStubInterface) error { return Revoke(stub, r) })
+		if errcode.CodeOf(err) != errcode.CodeInvalidArgument {
+			t.Errorf("Revoke %+v returned %v", r, err)
+		}
+	}
+	err = stub.Call(func(stub shim.ChaincodeStubInterface) error {
+		return Unrevoke(stub, RevokedSerial, "Org1MSP", "ff")
+	})
+	if errcode.CodeOf(err) != errcode.CodeNotFound {
+		t.Errorf("Unrevoke of an entry that does not exist returned %v", err)
+	}
+}
+
+func TestTypedAttributes(t *testing.T) {
+	client := cctest.NewClientWith("Org1MSP", "user", cctest.CertOptions{Attrs: map[string]string{
+		"level":     "3",
+		"active":    "true",
+		"until":     "2030-06-30T00:00:00Z",
+		"regions":   "emea, apac",
+		"teams":     `["red","blue"]`,
+		"malformed": `["red"`,
+		"dept":      "finance",
+	}})
+	id := get(t, cctest.NewStub("identity", nil), client)
+	since := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+	}{
+		{"level at least 3", id.AssertIntAttributeAtLeast("level", 3), true},
+		{"level at least 4", id.AssertIntAttributeAtLeast("level", 4), false},
+		{"dept at least 1", id.AssertIntAttributeAtLeast("dept", 1), false},
+		{"active", id.AssertBoolAttribute("active"), true},
+		{"dept is a bool", id.AssertBoolAttribute("dept"), false},
+		{"until after 2020", id.AssertTimeAttributeAfter("until", since), true},
+		{"until after 2031", id.AssertTimeAttributeAfter("until", since.AddDate(11, 0, 0)), false},
+		{"dept in", id.AssertAttributeIn("dept", "hr", "finance"), true},
+		{"dept not in", id.AssertAttributeIn("dept", "hr"), false},
+		{"regions contains apac", id.AssertListAttributeContains("regions", "apac"), true},
+		{"teams contains blue", id.AssertListAttributeContains("teams", "blue"), true},
+		{"teams contains green", id.AssertListAttributeContains("teams", "green"), false},
+		{"missing", id.AssertListAttributeContains("missing", "x"), false},
+	}
+	for _, test := range tests {
+		if (test.err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, test.err)
+		}
+		if test.err != nil && errcode.CodeOf(test.err) != errcode.CodeUnauthorized {
+			t.Errorf("%s: got code %s", test.name, errcode.CodeOf(test.err))
+		}
+	}
+
+	for _, test := range []struct {
+		name, value string
+		want        bool
+	}{
+		{"regions", "emea", true},
+		{"teams", "red", true},
+		{"teams", "green", false},
+		{"missing", "x", false},
+	} {
+		if got, err := id.Attributes().ContainsValue(test.name, test.value); err != nil || got != test.want {
+			t.Errorf("ContainsValue(%s, %s) returned %v, %v", test.name, test.value, got, err)
+		}
+	}
+	if _, err := id.Attributes().ContainsValue("malformed", "red"); errcode.CodeOf(err) != errcode.CodeUnauthorized {
+		t.Errorf("ContainsValue of a malformed list returned %v", err)
+	}
+}
+
+func TestExpiredAttributesAreIgnored(t *testing.T) {
+	now := time.Now().UTC()
+	client := cctest.NewClientWith("Org1MSP", "user", cctest.CertOptions{
+		Attrs: map[string]string{"dept": "finance", "level": "3", "temp": "true"},
+		Meta: map[string]interface{}{
+			"dept": map[string]string{"notAfter": now.Add(time.Hour).Format(time.RFC3339), "issuer": "ca.org1.example.com"},
+			"temp": map[string]string{"notAfter": now.Add(-time.Hour).Format(time.RFC3339)},
+		},
+	})
+	id := get(t, cctest.NewStub("identity", nil), client)
+
+	if value, found, _ := id.GetAttributeValue("dept"); !found || value != "finance" {
+		t.Errorf("dept is %q, %v", value, found)
+	}
+	if meta := id.Attributes().Metadata("dept"); meta == nil || meta.Issuer != "ca.org1.example.com" {
+		t.Errorf("dept has metadata %+v", meta)
+	}
+	if _, found, _ := id.GetAttributeValue("temp"); found {
+		t.Error("the expired attribute temp was found")
+	}
+	if errcode.CodeOf(id.AssertBoolAttribute("temp")) != errcode.CodeUnauthorized {
+		t.Error("the expired attribute temp was asserted")
+	}
+	if err := id.AssertAttributeValue("level", "3"); err != nil {
+		t.Errorf("level without metadata: %s", err)
+	}
+}
+
+func TestEncodeAttributes(t *testing.T) {
+	attrs := map[string]string{"dept": "finance", "level": "3"}
+	legacy, _ := json.Marshal(&attrmgr.Attributes{Attrs: attrs})
+	encoded, err := EncodeAttributes(&Attributes{Attrs: attrs, Meta: map[string]*AttributeMetadata{"level": {}}})
+	if err != nil || string(encoded) != string(legacy) {
+		t.Errorf("attributes without metadata were encoded as %s, %v; want %s", encoded, err, legacy)
+	}
+
+	notAfter := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	encoded, err = EncodeAttributes(&Attributes{Attrs: attrs, Meta: map[string]*AttributeMetadata{
+		"dept": {NotAfter: &notAfter, Issuer: "ca.org1.example.com", Registrar: "admin"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{Extensions: []pkix.Extension{{Id: attrmgr.AttrOID, Value: encoded}}}
+	old, err := attrmgr.New().GetAttributesFromCert(cert)
+	if err != nil || len(old.Attrs) != 2 || old.Attrs["dept"] != "finance" {
+		t.Errorf("attrmgr read %+v, %v", old, err)
+	}
+	decoded, err := attributesFromCert(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := decoded.Metadata("dept")
+	if meta == nil || !meta.NotAfter.Equal(notAfter) || meta.Issuer != "ca.org1.example.com" || meta.Registrar != "admin" || decoded.Metadata("level") != nil {
+		t.Errorf("decoded %+v", decoded)
+	}
+
+	_, err = EncodeAttributes(&Attributes{Attrs: attrs, Meta: map[string]*AttributeMetadata{"role": {Issuer: "ca"}}})
+	if errcode.CodeOf(err) != errcode.CodeInvalidArgument {
+		t.Errorf("metadata without a value: got %v", err)
+	}
+}
+
+func TestGetRejectsCertificatesOutsideTheirValidity(t *testing.T) {
+	now := time.Now()
+	client := cctest.NewClientWith("Org1MSP", "user", cctest.CertOptions{
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Hour),
+	})
+	stub := cctest.NewStub("identity", nil).As(client)
+	get := func(stub shim.ChaincodeStubInterface) error {
+		_, err := identities.Get(stub)
+		return err
+	}
+
+	for _, at := range []time.Time{now.Add(-2 * time.Hour), now.Add(2 * time.Hour)} {
+		err := stub.At(at).Call(get)
+		if errcode.CodeOf(err) != errcode.CodeUnauthorized {
+			t.Errorf("at %s: Get returned %v", at, err)
+		}
+		err = stub.Call(func(stub shim.ChaincodeStubInterface) error {
+			return identities.AssertAttributeValue(stub, "any", "value")
+		})
+		if err == nil || !strings.Contains(err.Error(), "certificate") {
+			t.Errorf("at %s: AssertAttributeValue returned %v", at, err)
+		}
+	}
+	if err := stub.At(now).Call(get); err != nil {
+		t.Errorf("Get failed within the validity: %s", err)
+	}
+}
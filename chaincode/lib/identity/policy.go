@@ -0,0 +1,595 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package identity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+)
+
+// A policy is a boolean expression over the attributes, MSP ID, organizational
+// units and NodeOU role of the client, for example:
+//
+//	(dept = finance AND level >= 3) OR role = auditor
+//	MSP(Org1MSP, Org2MSP) AND NOT OU(contractors)
+//	region IN (emea, apac) AND ROLE(client)
+//	regions CONTAINS emea
+//
+// The grammar, with AND binding tighter than OR:
+//
+//	expr      = and { "OR" and }
+//	and       = unary { "AND" unary }
+//	unary     = "NOT" unary | "(" expr ")" | predicate
+//	predicate = attr op value | attr "IN" "(" values ")" |
+//	            attr "CONTAINS" value | ("MSP" | "OU" | "ROLE") "(" values ")"
+//	op        = "=" | "==" | "!=" | "<" | "<=" | ">" | ">="
+//	values    = value { "," value }
+//
+// Keywords are case insensitive. Attribute names and values are either bare
+// words or quoted with ' or ". The comparisons = and != compare numerically
+// if both sides are numbers and as strings otherwise; <, <=, > and >= require
+// both sides to be numbers. A predicate over an attribute the client does not
+// have, or that has expired, is false. CONTAINS holds if the attribute is a
+// list, as parsed by Attributes.ListValue, containing the value. MSP, OU and
+// ROLE hold if the client's MSP ID, one of its organizational units or its
+// NodeOU role is one of the values.
+
+// maxCachedPolicies bounds the number of parsed policies kept by AssertPolicy
+const maxCachedPolicies = 256
+
+var policyCache = struct {
+	sync.Mutex
+	policies map[string]*Policy
+}{policies: make(map[string]*Policy)}
+
+// Policy is a parsed policy expression
+type Policy struct {
+	expr string
+	root policyNode
+}
+
+// ParsePolicy parses a policy expression
+func ParsePolicy(expr string) (*Policy, error) {
+	p := &policyParser{expr: expr}
+	err := p.tokenize()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, errcode.InvalidArgument("Invalid policy: the expression is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, p.errorf(t, "unexpected '%s'", t.text)
+	}
+	return &Policy{expr: expr, root: root}, nil
+}
+
+// AssertPolicy checks to see if the client satisfies a policy expression.
+// Expressions are parsed once and cached.
+func (id *Identity) AssertPolicy(expr string) error {
+	p, err := getPolicy(expr)
+	if err != nil {
+		return err
+	}
+	return p.Evaluate(id)
+}
+
+// String returns the policy expression
+func (p *Policy) String() string {
+	return p.expr
+}
+
+// Evaluate checks to see if a client satisfies the policy. If it does not,
+// the error names the clause that failed and why.
+func (p *Policy) Evaluate(id *Identity) error {
+	ok, reason := p.root.eval(id)
+	if !ok {
+		return errcode.Unauthorized("Policy '%s' is not satisfied: %s", p.expr, reason).With("policy", p.expr)
+	}
+	return nil
+}
+
+// getPolicy returns the parsed policy for an expression from the cache,
+// parsing it on first use
+func getPolicy(expr string) (*Policy, error) {
+	policyCache.Lock()
+	defer policyCache.Unlock()
+	if p, ok := policyCache.policies[expr]; ok {
+		return p, nil
+	}
+	p, err := ParsePolicy(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(policyCache.policies) >= maxCachedPolicies {
+		policyCache.policies = make(map[string]*Policy)
+	}
+	policyCache.policies[expr] = p
+	return p, nil
+}
+
+// policyNode is a clause of a policy. eval returns whether the client
+// satisfies the clause and, if it does not, why.
+type policyNode interface {
+	eval(id *Identity) (bool, string)
+	String() string
+}
+
+type andNode struct {
+	clauses []policyNode
+}
+
+func (n *andNode) eval(id *Identity) (bool, string) {
+	for _, clause := range n.clauses {
+		ok, reason := clause.eval(id)
+		if !ok {
+			return false, reason
+		}
+	}
+	return true, ""
+}
+
+func (n *andNode) String() string {
+	return joinNodes(n.clauses, " AND ")
+}
+
+type orNode struct {
+	clauses []policyNode
+}
+
+func (n *orNode) eval(id *Identity) (bool, string) {
+	reasons := make([]string, 0, len(n.clauses))
+	for _, clause := range n.clauses {
+		ok, reason := clause.eval(id)
+		if ok {
+			return true, ""
+		}
+		reasons = append(reasons, reason)
+	}
+	return false, fmt.Sprintf("none of the alternatives of '%s' holds (%s)", n, strings.Join(reasons, "; "))
+}
+
+func (n *orNode) String() string {
+	return joinNodes(n.clauses, " OR ")
+}
+
+type notNode struct {
+	clause policyNode
+}
+
+func (n *notNode) eval(id *Identity) (bool, string) {
+	ok, _ := n.clause.eval(id)
+	if ok {
+		return false, fmt.Sprintf("clause '%s' failed: '%s' holds", n, n.clause)
+	}
+	return true, ""
+}
+
+func (n *notNode) String() string {
+	switch n.clause.(type) {
+	case *andNode, *orNode:
+		return "NOT (" + n.clause.String() + ")"
+	}
+	return "NOT " + n.clause.String()
+}
+
+// compareNode compares an attribute with a value
+type compareNode struct {
+	attr  string
+	op    string
+	value string
+}
+
+func (n *compareNode) eval(id *Identity) (bool, string) {
+	actual, found := id.attrs.Value(n.attr)
+	if !found {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' was not found", n, n.attr)
+	}
+
+	actualNum, actualErr := strconv.ParseFloat(actual, 64)
+	valueNum, valueErr := strconv.ParseFloat(n.value, 64)
+	numeric := actualErr == nil && valueErr == nil
+
+	var ok bool
+	switch n.op {
+	case "=", "==":
+		ok = actual == n.value || numeric && actualNum == valueNum
+	case "!=":
+		ok = actual != n.value && !(numeric && actualNum == valueNum)
+	default:
+		if !numeric {
+			return false, fmt.Sprintf("clause '%s' failed: attribute '%s' equals '%s', which is not a number", n, n.attr, actual)
+		}
+		switch n.op {
+		case "<":
+			ok = actualNum < valueNum
+		case "<=":
+			ok = actualNum <= valueNum
+		case ">":
+			ok = actualNum > valueNum
+		case ">=":
+			ok = actualNum >= valueNum
+		}
+	}
+	if !ok {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' equals '%s'", n, n.attr, actual)
+	}
+	return true, ""
+}
+
+func (n *compareNode) String() string {
+	return fmt.Sprintf("%s %s %s", quoteWord(n.attr), n.op, quoteWord(n.value))
+}
+
+// inNode checks that an attribute equals one of a set of values
+type inNode struct {
+	attr   string
+	values []string
+}
+
+func (n *inNode) eval(id *Identity) (bool, string) {
+	actual, found := id.attrs.Value(n.attr)
+	if !found {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' was not found", n, n.attr)
+	}
+	if !contains(n.values, actual) {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' equals '%s'", n, n.attr, actual)
+	}
+	return true, ""
+}
+
+func (n *inNode) String() string {
+	return fmt.Sprintf("%s IN (%s)", quoteWord(n.attr), joinWords(n.values))
+}
+
+// containsNode checks that a list attribute contains a value
+type containsNode struct {
+	attr  string
+	value string
+}
+
+func (n *containsNode) eval(id *Identity) (bool, string) {
+	actual, found := id.attrs.Value(n.attr)
+	if !found {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' was not found", n, n.attr)
+	}
+	list, err := parseList(actual)
+	if err != nil {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' equals '%s', which is not a list", n, n.attr, actual)
+	}
+	if !contains(list, n.value) {
+		return false, fmt.Sprintf("clause '%s' failed: attribute '%s' equals '%s'", n, n.attr, actual)
+	}
+	return true, ""
+}
+
+func (n *containsNode) String() string {
+	return fmt.Sprintf("%s CONTAINS %s", quoteWord(n.attr), quoteWord(n.value))
+}
+
+// identityNode checks the MSP ID, the organizational units or the role of
+// the client against a set of values
+type identityNode struct {
+	kind   string
+	values []string
+}
+
+func (n *identityNode) eval(id *Identity) (bool, string) {
+	var actual []string
+	switch n.kind {
+	case "MSP":
+		actual = []string{id.mspID}
+	case "OU":
+		actual = id.GetOUs()
+	case "ROLE":
+		if role := id.GetRole(); len(role) > 0 {
+			actual = []string{role}
+		}
+	}
+	for _, value := range actual {
+		if contains(n.values, value) {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("clause '%s' failed: the client's %s is %v", n, strings.ToLower(n.kind), actual)
+}
+
+func (n *identityNode) String() string {
+	return fmt.Sprintf("%s(%s)", n.kind, joinWords(n.values))
+}
+
+// policyToken is a lexical token of a policy expression
+type policyToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// keyword returns the upper-cased text of an unquoted token
+func (t *policyToken) keyword() string {
+	if t.quoted {
+		return ""
+	}
+	return strings.ToUpper(t.text)
+}
+
+type policyParser struct {
+	expr   string
+	tokens []*policyToken
+	next   int
+}
+
+func (p *policyParser) tokenize() error {
+	s := p.expr
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			p.tokens = append(p.tokens, &policyToken{text: s[i : i+1], pos: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := s[i : i+1]
+			if i+1 < len(s) && s[i+1] == '=' {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				return errcode.InvalidArgument("Invalid policy '%s': unexpected '!' at position %d", p.expr, i)
+			}
+			p.tokens = append(p.tokens, &policyToken{text: op, pos: i})
+			i += len(op)
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return errcode.InvalidArgument("Invalid policy '%s': unterminated string at position %d", p.expr, i)
+			}
+			p.tokens = append(p.tokens, &policyToken{text: s[i+1 : i+1+end], quoted: true, pos: i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r(),=!<>\"'", rune(s[i])) {
+				i++
+			}
+			p.tokens = append(p.tokens, &policyToken{text: s[start:i], pos: start})
+		}
+	}
+	return nil
+}
+
+func (p *policyParser) peek() *policyToken {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return nil
+}
+
+func (p *policyParser) take() *policyToken {
+	t := p.peek()
+	if t != nil {
+		p.next++
+	}
+	return t
+}
+
+func (p *policyParser) errorf(t *policyToken, format string, a ...interface{}) error {
+	pos := len(p.expr)
+	if t != nil {
+		pos = t.pos
+	}
+	return errcode.InvalidArgument("Invalid policy '%s': %s at position %d", p.expr, fmt.Sprintf(format, a...), pos)
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+	clauses := []policyNode{}
+	for {
+		clause, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		if t := p.peek(); t == nil || t.keyword() != "OR" {
+			break
+		}
+		p.take()
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return &orNode{clauses: clauses}, nil
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+	clauses := []policyNode{}
+	for {
+		clause, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		if t := p.peek(); t == nil || t.keyword() != "AND" {
+			break
+		}
+		p.take()
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	return &andNode{clauses: clauses}, nil
+}
+
+func (p *policyParser) parseUnary() (policyNode, error) {
+	t := p.take()
+	if t == nil {
+		return nil, p.errorf(nil, "unexpected end of expression")
+	}
+	if !t.quoted && t.text == "(" {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	switch t.keyword() {
+	case "NOT":
+		clause, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{clause: clause}, nil
+	case "MSP", "OU", "ROLE":
+		if next := p.peek(); next != nil && !next.quoted && next.text == "(" {
+			values, err := p.parseValues()
+			if err != nil {
+				return nil, err
+			}
+			return &identityNode{kind: t.keyword(), values: values}, nil
+		}
+	case "AND", "OR", "IN", "CONTAINS":
+		return nil, p.errorf(t, "unexpected '%s'", t.text)
+	}
+	if !t.quoted && isPunctuation(t.text) {
+		return nil, p.errorf(t, "unexpected '%s'", t.text)
+	}
+	return p.parsePredicate(t.text)
+}
+
+// parsePredicate parses the rest of a predicate over the attribute attr
+func (p *policyParser) parsePredicate(attr string) (policyNode, error) {
+	op := p.take()
+	if op == nil {
+		return nil, p.errorf(nil, "expected an operator after '%s'", attr)
+	}
+	if op.keyword() == "IN" {
+		values, err := p.parseValues()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{attr: attr, values: values}, nil
+	}
+	if op.keyword() == "CONTAINS" {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return &containsNode{attr: attr, value: value}, nil
+	}
+	switch op.text {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, p.errorf(op, "expected an operator after '%s', got '%s'", attr, op.text)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if op.text != "=" && op.text != "==" && op.text != "!=" {
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, p.errorf(op, "'%s' expects a number, got '%s'", op.text, value)
+		}
+	}
+	return &compareNode{attr: attr, op: op.text, value: value}, nil
+}
+
+// parseValues parses a parenthesized, comma separated list of values
+func (p *policyParser) parseValues() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for {
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		t := p.take()
+		if t == nil {
+			return nil, p.errorf(nil, "expected ')'")
+		}
+		if t.text == ")" && !t.quoted {
+			return values, nil
+		}
+		if t.text != "," || t.quoted {
+			return nil, p.errorf(t, "expected ',' or ')', got '%s'", t.text)
+		}
+	}
+}
+
+func (p *policyParser) parseValue() (string, error) {
+	t := p.take()
+	if t == nil {
+		return "", p.errorf(nil, "expected a value")
+	}
+	if !t.quoted && isPunctuation(t.text) {
+		return "", p.errorf(t, "expected a value, got '%s'", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *policyParser) expect(text string) error {
+	t := p.take()
+	if t == nil {
+		return p.errorf(nil, "expected '%s'", text)
+	}
+	if t.quoted || t.text != text {
+		return p.errorf(t, "expected '%s', got '%s'", text, t.text)
+	}
+	return nil
+}
+
+// isPunctuation returns true for the text of operator and delimiter tokens
+func isPunctuation(text string) bool {
+	switch text {
+	case "(", ")", ",", "=", "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func joinNodes(nodes []policyNode, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = node.String()
+		if _, ok := node.(*orNode); ok {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+func joinWords(words []string) string {
+	parts := make([]string, len(words))
+	for i, word := range words {
+		parts[i] = quoteWord(word)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// quoteWord quotes a name or value that would not parse as a bare word
+func quoteWord(word string) string {
+	if len(word) == 0 || strings.ContainsAny(word, " \t\n\r(),=!<>\"'") {
+		if strings.ContainsRune(word, '"') {
+			return "'" + word + "'"
+		}
+		return `"` + word + `"`
+	}
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT", "IN", "CONTAINS":
+		return `"` + word + `"`
+	}
+	return word
+}
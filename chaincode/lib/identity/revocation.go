@@ -0,0 +1,202 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package identity
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Chaincodes can keep a deny-list of revoked certificates and enrollment IDs
+// in their own state, which takes effect as soon as the revoking transaction
+// commits, without waiting for the CRL in the MSP configuration to be
+// updated. Each entry is stored under the composite key
+//
+//	cid~revoked  kind, MSP ID, value  -> Revocation
+//
+// where kind is RevokedSerial, with the certificate serial number in
+// lowercase hex as the value, or RevokedEnrollmentID. Chaincodes manage the
+// entries with Revoke and Unrevoke, typically in functions restricted to
+// admins, and list them with ListRevocations.
+//
+// Getting an identity does not read the deny-list. Chaincodes check it
+// where they need to, usually once at the start of Invoke, with
+// AssertNotRevoked.
+const (
+	RevocationObjectType = "cid~revoked"
+
+	RevokedSerial       = "serial"
+	RevokedEnrollmentID = "enrollmentID"
+
+	// enrollmentIDAttribute is added to certificates by the Fabric CA
+	enrollmentIDAttribute = "hf.EnrollmentID"
+)
+
+// Revocation is an entry of the deny-list. RevokedBy, RevokedAt and Reason
+// are recorded as given by the chaincode.
+type Revocation struct {
+	Kind      string `json:"kind"`
+	MSPID     string `json:"mspId"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason,omitempty"`
+	RevokedBy string `json:"revokedBy,omitempty"`
+	RevokedAt string `json:"revokedAt,omitempty"`
+}
+
+// AssertNotRevoked checks that neither the serial number of the client's
+// certificate nor its enrollment ID is on the deny-list
+func (c *Checker) AssertNotRevoked(stub shim.ChaincodeStubInterface) error {
+	id, err := c.Get(stub)
+	if err != nil {
+		return err
+	}
+	return id.AssertNotRevoked()
+}
+
+// AssertNotRevoked checks that neither the serial number of the client's
+// certificate nor its enrollment ID is on the deny-list
+func (id *Identity) AssertNotRevoked() error {
+	r, err := GetRevocation(id.stub, RevokedSerial, id.mspID, id.cert.SerialNumber.Text(16))
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		enrollmentID := id.EnrollmentID()
+		if len(enrollmentID) == 0 {
+			return nil
+		}
+		r, err = GetRevocation(id.stub, RevokedEnrollmentID, id.mspID, enrollmentID)
+		if err != nil {
+			return err
+		}
+	}
+	if r != nil {
+		return errcode.Unauthorized("The client's %s '%s' of MSP '%s' was revoked at %s: %s", r.Kind, r.Value, r.MSPID, r.RevokedAt, r.Reason).
+			With("kind", r.Kind).With("value", r.Value)
+	}
+	return nil
+}
+
+// EnrollmentID returns the enrollment ID of the client: the hf.EnrollmentID
+// attribute if the certificate has it, the common name otherwise
+func (id *Identity) EnrollmentID() string {
+	if enrollmentID, ok := id.attrs.Value(enrollmentIDAttribute); ok {
+		return enrollmentID
+	}
+	return id.cert.Subject.CommonName
+}
+
+// Revoke adds an entry to the deny-list, replacing any existing entry for
+// the same serial number or enrollment ID
+func Revoke(stub shim.ChaincodeStubInterface, r Revocation) error {
+	value, err := normalizeRevokedValue(r.Kind, r.Value)
+	if err != nil {
+		return err
+	}
+	if len(r.MSPID) == 0 {
+		return errcode.InvalidArgument("The MSP ID of a revocation must not be empty")
+	}
+	r.Value = value
+	key, err := stub.CreateCompositeKey(RevocationObjectType, []string{r.Kind, r.MSPID, r.Value})
+	if err != nil {
+		return err
+	}
+	revocationJSONasBytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, revocationJSONasBytes)
+}
+
+// Unrevoke removes an entry from the deny-list
+func Unrevoke(stub shim.ChaincodeStubInterface, kind, mspID, value string) error {
+	r, err := GetRevocation(stub, kind, mspID, value)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return errcode.NotFound("%s '%s' of MSP '%s' is not revoked", kind, value, mspID).With("kind", kind).With("value", value)
+	}
+	key, err := stub.CreateCompositeKey(RevocationObjectType, []string{r.Kind, r.MSPID, r.Value})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// GetRevocation returns the deny-list entry for a serial number or an
+// enrollment ID, or nil if there is none
+func GetRevocation(stub shim.ChaincodeStubInterface, kind, mspID, value string) (*Revocation, error) {
+	value, err := normalizeRevokedValue(kind, value)
+	if err != nil {
+		return nil, err
+	}
+	key, err := stub.CreateCompositeKey(RevocationObjectType, []string{kind, mspID, value})
+	if err != nil {
+		return nil, err
+	}
+	revocationJSONasBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errcode.Internal("Failed to read the deny-list: %s", err)
+	}
+	if revocationJSONasBytes == nil {
+		return nil, nil
+	}
+	r := &Revocation{}
+	err = json.Unmarshal(revocationJSONasBytes, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRevocations returns the deny-list
+func ListRevocations(stub shim.ChaincodeStubInterface) ([]Revocation, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(RevocationObjectType, []string{})
+	if err != nil {
+		return nil, errcode.Internal("Failed to read the deny-list: %s", err)
+	}
+	defer iterator.Close()
+
+	revocations := []Revocation{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, errcode.Internal("Failed to read the deny-list: %s", err)
+		}
+		var r Revocation
+		err = json.Unmarshal(kv.Value, &r)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, r)
+	}
+	return revocations, nil
+}
+
+// normalizeRevokedValue checks the kind of a deny-list entry and returns the
+// value as it is stored. Serial numbers may be given in hex with or without
+// a 0x prefix and colons, e.g. "1e:49:98:e9".
+func normalizeRevokedValue(kind, value string) (string, error) {
+	switch kind {
+	case RevokedSerial:
+		hex := strings.TrimPrefix(strings.ToLower(strings.Replace(value, ":", "", -1)), "0x")
+		serial, ok := new(big.Int).SetString(hex, 16)
+		if !ok {
+			return "", errcode.InvalidArgument("Serial number '%s' is not a hex number", value).With("value", value)
+		}
+		return serial.Text(16), nil
+	case RevokedEnrollmentID:
+		if len(value) == 0 {
+			return "", errcode.InvalidArgument("The enrollment ID of a revocation must not be empty")
+		}
+		return value, nil
+	}
+	return "", errcode.InvalidArgument("Revocation kind must be '%s' or '%s', got '%s'", RevokedSerial, RevokedEnrollmentID, kind).With("kind", kind)
+}
@@ -0,0 +1,631 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package roles keeps role assignments on the ledger, so that admins can
+// grant and revoke roles without re-enrolling users. Members are identified
+// by their MSP ID and the ID returned by cid.GetID, joined as MSPID::ID.
+//
+// Roles may include other roles. A chaincode declares its hierarchy once and
+// adds the role management functions to its router:
+//
+//	var registry = roles.New().
+//		Include("admin", "archivist", "operator")
+//
+//	var r = registry.AddRoutes(router.New("abac", "1.0").
+//		Add("restore", "Restores an entity",
+//			registry.Require("abac.archivist", "archivist", restore),
+//			router.Required("name", router.String)))
+//
+// Grants are stored under two composite keys, so that both the members of a
+// role and the roles of a member can be listed, and every change is recorded
+// in an audit log ordered by transaction time:
+//
+//	roles~member role, member                 -> Grant
+//	member~role  member, role                 -> 0x00
+//	roles~audit  time, txID, member, role     -> AuditEntry
+//	roles~adminMsps                           -> JSON array of MSP IDs
+//
+// Roles are granted and revoked by admins: clients with the NodeOU admin role
+// in one of the admin MSPs, and members holding AdminRole on the ledger. The
+// admin MSPs are stored by Init, which a chaincode calls from its own Init,
+// and changed by admins with setAdminMspIds; an admin of any other MSP is an
+// ordinary client. NodeOU roles are looked up with an identity.Checker, by
+// default one for identity.DefaultNodeOUs; chaincodes whose MSPs use other
+// identifiers set their own with Identities.
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AdminRole is the ledger role allowed to grant and revoke roles, in
+// addition to clients with the NodeOU admin role
+const AdminRole = "admin"
+
+// Audit log actions
+const (
+	ActionGrant  = "grant"
+	ActionRevoke = "revoke"
+)
+
+const (
+	grantIndex          = "roles~member"
+	memberIndex         = "member~role"
+	auditObjectType     = "roles~audit"
+	adminMSPsObjectType = "roles~adminMsps"
+)
+
+// Grant is a role granted to a member
+type Grant struct {
+	Role      string `json:"role"`
+	Member    string `json:"member"`
+	GrantedBy string `json:"grantedBy"`
+	GrantedAt string `json:"grantedAt"`
+}
+
+// AuditEntry records a grant or a revocation
+type AuditEntry struct {
+	Action string `json:"action"`
+	Role   string `json:"role"`
+	Member string `json:"member"`
+	By     string `json:"by"`
+	At     string `json:"at"`
+	TxID   string `json:"txId"`
+}
+
+// MemberRoles is returned by listRoles
+type MemberRoles struct {
+	Member         string   `json:"member"`
+	Roles          []string `json:"roles"`
+	EffectiveRoles []string `json:"effectiveRoles"`
+}
+
+// Registry holds the role hierarchy of a chaincode
+type Registry struct {
+	includes   map[string][]string
+	identities *identity.Checker
+}
+
+// New returns a registry without a hierarchy
+func New() *Registry {
+	return &Registry{includes: make(map[string][]string), identities: identity.New(identity.DefaultNodeOUs)}
+}
+
+// Identities sets the identity.Checker used to look up the NodeOU role and
+// the attributes of the invoker
+func (r *Registry) Identities(identities *identity.Checker) *Registry {
+	r.identities = identities
+	return r
+}
+
+// Include declares that members holding role also hold the included roles,
+// and the roles those include in turn
+func (r *Registry) Include(role string, included ...string) *Registry {
+	r.includes[role] = append(r.includes[role], included...)
+	return r
+}
+
+// Init stores the admin MSPs, whose clients with the NodeOU admin role are
+// admins, or the MSP of the invoker if none are given. Admin MSPs stored
+// before, as on an upgrade, are kept.
+func (r *Registry) Init(stub shim.ChaincodeStubInterface, adminMSPIDs ...string) error {
+	stored, err := r.AdminMSPIDs(stub)
+	if err != nil || stored != nil {
+		return err
+	}
+	if len(adminMSPIDs) == 0 {
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err)
+		}
+		adminMSPIDs = []string{mspID}
+	}
+	return r.SetAdminMSPIDs(stub, adminMSPIDs)
+}
+
+// AdminMSPIDs returns the admin MSPs, or nil if Init has not stored them
+func (r *Registry) AdminMSPIDs(stub shim.ChaincodeStubInterface) ([]string, error) {
+	adminMSPsKey, err := stub.CreateCompositeKey(adminMSPsObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	mspIDsJSONasBytes, err := stub.GetState(adminMSPsKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get the admin MSPs: %s", err)
+	}
+	if mspIDsJSONasBytes == nil {
+		return nil, nil
+	}
+	mspIDs := []string{}
+	err = json.Unmarshal(mspIDsJSONasBytes, &mspIDs)
+	if err != nil {
+		return nil, err
+	}
+	return mspIDs, nil
+}
+
+// SetAdminMSPIDs replaces the admin MSPs
+func (r *Registry) SetAdminMSPIDs(stub shim.ChaincodeStubInterface, mspIDs []string) error {
+	if len(mspIDs) == 0 {
+		return errcode.InvalidArgument("At least one admin MSP is required")
+	}
+	for _, mspID := range mspIDs {
+		if len(mspID) == 0 {
+			return errcode.InvalidArgument("Admin MSP IDs must be non-empty strings")
+		}
+	}
+	adminMSPsKey, err := stub.CreateCompositeKey(adminMSPsObjectType, []string{})
+	if err != nil {
+		return err
+	}
+	mspIDsJSONasBytes, err := json.Marshal(mspIDs)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(adminMSPsKey, mspIDsJSONasBytes)
+}
+
+// Member returns the invoker as MSPID::ID
+func Member(stub shim.ChaincodeStubInterface) (string, error) {
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err)
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return "", errcode.Unauthorized("Failed to get the ID of the invoker: %s", err)
+	}
+	return mspID + "::" + id, nil
+}
+
+// Grant grants a role to a member and records it in the audit log. Granting
+// a role the member already holds replaces the grant.
+func (r *Registry) Grant(stub shim.ChaincodeStubInterface, member, role string) (*Grant, error) {
+	if err := checkMember(member); err != nil {
+		return nil, err
+	}
+	if err := checkRole(role); err != nil {
+		return nil, err
+	}
+	by, err := Member(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := &Grant{Role: role, Member: member, GrantedBy: by, GrantedAt: now.Format(time.RFC3339)}
+	grantKey, err := stub.CreateCompositeKey(grantIndex, []string{role, member})
+	if err != nil {
+		return nil, err
+	}
+	grantJSONasBytes, err := json.Marshal(grant)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(grantKey, grantJSONasBytes)
+	if err != nil {
+		return nil, errcode.Internal("Failed to grant %s to %s: %s", role, member, err)
+	}
+	memberKey, err := stub.CreateCompositeKey(memberIndex, []string{member, role})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(memberKey, []byte{0x00})
+	if err != nil {
+		return nil, errcode.Internal("Failed to grant %s to %s: %s", role, member, err)
+	}
+	return grant, audit(stub, ActionGrant, member, role, by, now)
+}
+
+// Revoke revokes a role from a member and records it in the audit log
+func (r *Registry) Revoke(stub shim.ChaincodeStubInterface, member, role string) error {
+	if err := checkMember(member); err != nil {
+		return err
+	}
+	if err := checkRole(role); err != nil {
+		return err
+	}
+	by, err := Member(stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	grantKey, err := stub.CreateCompositeKey(grantIndex, []string{role, member})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(grantKey)
+	if err != nil {
+		return errcode.Internal("Failed to revoke %s from %s: %s", role, member, err)
+	}
+	memberKey, err := stub.CreateCompositeKey(memberIndex, []string{member, role})
+	if err != nil {
+		return err
+	}
+	err = stub.DelState(memberKey)
+	if err != nil {
+		return errcode.Internal("Failed to revoke %s from %s: %s", role, member, err)
+	}
+	return audit(stub, ActionRevoke, member, role, by, now)
+}
+
+// GetGrant returns the grant of a role to a member, or nil if the member
+// does not hold the role directly
+func (r *Registry) GetGrant(stub shim.ChaincodeStubInterface, member, role string) (*Grant, error) {
+	grantKey, err := stub.CreateCompositeKey(grantIndex, []string{role, member})
+	if err != nil {
+		return nil, err
+	}
+	grantJSONasBytes, err := stub.GetState(grantKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get grant of %s to %s: %s", role, member, err)
+	}
+	if grantJSONasBytes == nil {
+		return nil, nil
+	}
+	grant := &Grant{}
+	err = json.Unmarshal(grantJSONasBytes, grant)
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// Roles returns the roles granted to a member directly, sorted by name
+func (r *Registry) Roles(stub shim.ChaincodeStubInterface, member string) ([]string, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(memberIndex, []string{member})
+	if err != nil {
+		return nil, errcode.Internal("Failed to read roles of %s: %s", member, err)
+	}
+	defer iterator.Close()
+
+	roles := []string{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, errcode.Internal("Failed to read roles of %s: %s", member, err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, attributes[1])
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+// EffectiveRoles returns the roles of a member including those implied by
+// the hierarchy, sorted by name
+func (r *Registry) EffectiveRoles(stub shim.ChaincodeStubInterface, member string) ([]string, error) {
+	direct, err := r.Roles(stub, member)
+	if err != nil {
+		return nil, err
+	}
+	return r.expand(direct), nil
+}
+
+// HasRole returns true if a member holds a role, directly or through the
+// hierarchy
+func (r *Registry) HasRole(stub shim.ChaincodeStubInterface, member, role string) (bool, error) {
+	effective, err := r.EffectiveRoles(stub, member)
+	if err != nil {
+		return false, err
+	}
+	for _, held := range effective {
+		if held == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// AssertRole returns an UNAUTHORIZED error unless the invoker holds a role
+func (r *Registry) AssertRole(stub shim.ChaincodeStubInterface, role string) error {
+	member, err := Member(stub)
+	if err != nil {
+		return err
+	}
+	ok, err := r.HasRole(stub, member, role)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errcode.Unauthorized("%s does not hold the role %s", member, role).With("member", member).With("role", role)
+	}
+	return nil
+}
+
+// AssertAdmin returns an UNAUTHORIZED error unless the invoker has the
+// NodeOU admin role in one of the admin MSPs or holds AdminRole on the ledger
+func (r *Registry) AssertAdmin(stub shim.ChaincodeStubInterface) error {
+	if r.identities.AssertRole(stub, identity.RoleAdmin) == nil {
+		adminMSPIDs, err := r.AdminMSPIDs(stub)
+		if err != nil {
+			return err
+		}
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err)
+		}
+		for _, adminMSPID := range adminMSPIDs {
+			if adminMSPID == mspID {
+				return nil
+			}
+		}
+	}
+	err := r.AssertRole(stub, AdminRole)
+	if errcode.CodeOf(err) == errcode.CodeUnauthorized {
+		member, _ := Member(stub)
+		return errcode.Unauthorized("%s is not an admin: it has neither the NodeOU admin role in an admin MSP nor the ledger role %s", member, AdminRole).
+			With("member", member).With("role", AdminRole)
+	}
+	return err
+}
+
+// Require returns a handler that calls h only if the invoker's certificate
+// has the attribute attrName, unexpired, with the value "true", or the
+// invoker holds role on the ledger. Either may be empty to require only the
+// other.
+func (r *Registry) Require(attrName, role string, h router.Handler) router.Handler {
+	return func(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+		if len(attrName) > 0 && r.identities.AssertAttributeValue(stub, attrName, "true") == nil {
+			return h(stub, args)
+		}
+		if len(role) > 0 {
+			err := r.AssertRole(stub, role)
+			if err == nil {
+				return h(stub, args)
+			}
+			if errcode.CodeOf(err) != errcode.CodeUnauthorized {
+				return errcode.Response(err)
+			}
+		}
+		return errcode.Unauthorized("Requires the certificate attribute %s or the role %s", attrName, role).
+			With("attribute", attrName).With("role", role).Response()
+	}
+}
+
+// AuditLog returns the audit log, oldest entry first
+func AuditLog(stub shim.ChaincodeStubInterface) ([]AuditEntry, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(auditObjectType, []string{})
+	if err != nil {
+		return nil, errcode.Internal("Failed to read the role audit log: %s", err)
+	}
+	defer iterator.Close()
+
+	entries := []AuditEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return nil, errcode.Internal("Failed to read the role audit log: %s", err)
+		}
+		var entry AuditEntry
+		err = json.Unmarshal(kv.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// AddRoutes adds the role management functions to a router:
+//
+//	grantRole(member, role)       admins only
+//	revokeRole(member, role)      admins only
+//	listRoles([member])           the roles of a member, the invoker by default
+//	hasRole(role, [member])       whether a member holds a role
+//	roleAuditLog()                every grant and revocation
+//	adminMspIds()                 the admin MSPs
+//	setAdminMspIds(mspIds)        admins only
+func (r *Registry) AddRoutes(rt *router.Router) *router.Router {
+	return rt.
+		Add("grantRole", "Grants a role to a member; admins only", r.grantRole,
+			router.Required("member", router.String).Describe("MSPID::ID"),
+			router.Required("role", router.String)).
+		Add("revokeRole", "Revokes a role from a member; admins only", r.revokeRole,
+			router.Required("member", router.String).Describe("MSPID::ID"),
+			router.Required("role", router.String)).
+		Add("listRoles", "Lists the roles of a member, the invoker by default", r.listRoles,
+			router.Optional("member", router.String).Describe("MSPID::ID")).
+		Add("hasRole", "Returns whether a member, the invoker by default, holds a role", r.hasRole,
+			router.Required("role", router.String),
+			router.Optional("member", router.String).Describe("MSPID::ID")).
+		Add("roleAuditLog", "Returns every grant and revocation of a role", r.roleAuditLog).
+		Add("adminMspIds", "Returns the MSPs whose NodeOU admins are admins", r.adminMspIds).
+		Add("setAdminMspIds", "Replaces the MSPs whose NodeOU admins are admins; admins only", r.setAdminMspIds,
+			router.Required("mspIds", router.JSON).Describe("JSON array of MSP IDs"))
+}
+
+func (r *Registry) grantRole(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	member, role := args.String("member"), args.String("role")
+	if err := r.AssertAdmin(stub); err != nil {
+		return errcode.Response(err)
+	}
+	existing, err := r.GetGrant(stub, member, role)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if existing != nil {
+		return errcode.AlreadyExists("%s already holds the role %s", member, role).With("member", member).With("role", role).Response()
+	}
+	grant, err := r.Grant(stub, member, role)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(grant)
+}
+
+func (r *Registry) revokeRole(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	member, role := args.String("member"), args.String("role")
+	if err := r.AssertAdmin(stub); err != nil {
+		return errcode.Response(err)
+	}
+	existing, err := r.GetGrant(stub, member, role)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if existing == nil {
+		return errcode.NotFound("%s does not hold the role %s", member, role).With("member", member).With("role", role).Response()
+	}
+	err = r.Revoke(stub, member, role)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+func (r *Registry) listRoles(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	member, err := memberArg(stub, args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	direct, err := r.Roles(stub, member)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(MemberRoles{Member: member, Roles: direct, EffectiveRoles: r.expand(direct)})
+}
+
+func (r *Registry) hasRole(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	member, err := memberArg(stub, args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	role := args.String("role")
+	ok, err := r.HasRole(stub, member, role)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(map[string]interface{}{"member": member, "role": role, "hasRole": ok})
+}
+
+func (r *Registry) roleAuditLog(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	entries, err := AuditLog(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return success(entries)
+}
+
+func (r *Registry) adminMspIds(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	mspIDs, err := r.AdminMSPIDs(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if mspIDs == nil {
+		mspIDs = []string{}
+	}
+	return success(mspIDs)
+}
+
+func (r *Registry) setAdminMspIds(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	if err := r.AssertAdmin(stub); err != nil {
+		return errcode.Response(err)
+	}
+	mspIDs := []string{}
+	if err := args.Decode("mspIds", &mspIDs); err != nil {
+		return errcode.InvalidArgument("mspIds must be a JSON array of MSP IDs: %s", err).Response()
+	}
+	if err := r.SetAdminMSPIDs(stub, mspIDs); err != nil {
+		return errcode.Response(err)
+	}
+	return success(mspIDs)
+}
+
+// expand returns roles and every role they include, sorted by name
+func (r *Registry) expand(roles []string) []string {
+	seen := make(map[string]bool)
+	todo := append([]string{}, roles...)
+	for len(todo) > 0 {
+		role := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if seen[role] {
+			continue
+		}
+		seen[role] = true
+		todo = append(todo, r.includes[role]...)
+	}
+	expanded := make([]string, 0, len(seen))
+	for role := range seen {
+		expanded = append(expanded, role)
+	}
+	sort.Strings(expanded)
+	return expanded
+}
+
+// memberArg returns the optional member argument, or the invoker
+func memberArg(stub shim.ChaincodeStubInterface, args *router.Args) (string, error) {
+	if args.Has("member") {
+		member := args.String("member")
+		return member, checkMember(member)
+	}
+	return Member(stub)
+}
+
+func checkMember(member string) error {
+	parts := strings.SplitN(member, "::", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return errcode.InvalidArgument("Member must have the form MSPID::ID, got '%s'", member).With("member", member)
+	}
+	return nil
+}
+
+func checkRole(role string) error {
+	if len(role) == 0 {
+		return errcode.InvalidArgument("Role must be a non-empty string")
+	}
+	return nil
+}
+
+// audit appends an entry to the audit log
+func audit(stub shim.ChaincodeStubInterface, action, member, role, by string, at time.Time) error {
+	entry := AuditEntry{Action: action, Role: role, Member: member, By: by, At: at.Format(time.RFC3339), TxID: stub.GetTxID()}
+	// zero padded nanoseconds, so that entries sort by time
+	auditKey, err := stub.CreateCompositeKey(auditObjectType, []string{fmt.Sprintf("%020d", at.UnixNano()), entry.TxID, member, role})
+	if err != nil {
+		return err
+	}
+	entryJSONasBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(auditKey, entryJSONasBytes)
+}
+
+// txTime returns the transaction timestamp set by the client
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func success(v interface{}) pb.Response {
+	resultJSONasBytes, err := json.Marshal(v)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(resultJSONasBytes)
+}
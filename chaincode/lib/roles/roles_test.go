@@ -0,0 +1,156 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package roles
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var (
+	admin    = cctest.NewClientWith("Org1MSP", "admin", cctest.CertOptions{OUs: []string{"admin"}})
+	admin2   = cctest.NewClientWith("Org2MSP", "admin", cctest.CertOptions{OUs: []string{"admin"}})
+	alice    = cctest.NewClient("Org1MSP", "alice")
+	bob      = cctest.NewClient("Org2MSP", "bob")
+	operator = cctest.NewClientWith("Org2MSP", "operator", cctest.CertOptions{Attrs: map[string]string{"test.operator": "true"}})
+)
+
+var registry = New().
+	Include("manager", "operator").
+	Include("operator", "viewer")
+
+// testChaincode has the role management functions and operate, which
+// requires the attribute test.operator or the role operator
+type testChaincode struct{}
+
+var testRoutes = registry.AddRoutes(router.New("test", "1.0").
+	Add("operate", "Requires the operator role", registry.Require("test.operator", "operator",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+			return shim.Success(nil)
+		})))
+
+func (t *testChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	if err := registry.Init(stub); err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+func (t *testChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return testRoutes.Handle(stub)
+}
+
+// newStub returns a stub instantiated by alice, so that Org1MSP is the admin MSP
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("roles", new(testChaincode))
+	if res := stub.As(alice).Init(); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+// listRoles returns the roles of member as seen by client
+func listRoles(t *testing.T, stub *cctest.Stub, client *cctest.Client, member string) MemberRoles {
+	roles := MemberRoles{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub.As(client), "listRoles", member), &roles); err != nil {
+		t.Fatal(err)
+	}
+	return roles
+}
+
+func TestAdminsGrantAndRevokeRoles(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.AssertCode(t, stub.As(alice).Invoke("grantRole", bob.Member(), "operator"), errcode.CodeUnauthorized, "a client granting a role")
+	cctest.Invoke(t, stub.As(admin), "grantRole", bob.Member(), "operator")
+	cctest.AssertCode(t, stub.Invoke("grantRole", bob.Member(), "operator"), errcode.CodeAlreadyExists, "granting a role twice")
+	cctest.AssertCode(t, stub.Invoke("grantRole", "bob", "operator"), errcode.CodeInvalidArgument, "granting a role to a member without MSP")
+	cctest.AssertCode(t, stub.Invoke("grantRole", bob.Member(), ""), errcode.CodeInvalidArgument, "granting an empty role")
+
+	roles := listRoles(t, stub, bob, bob.Member())
+	if len(roles.Roles) != 1 || roles.Roles[0] != "operator" {
+		t.Errorf("bob holds %v", roles.Roles)
+	}
+
+	cctest.AssertCode(t, stub.As(bob).Invoke("revokeRole", bob.Member(), "operator"), errcode.CodeUnauthorized, "a client revoking a role")
+	cctest.Invoke(t, stub.As(admin), "revokeRole", bob.Member(), "operator")
+	cctest.AssertCode(t, stub.Invoke("revokeRole", bob.Member(), "operator"), errcode.CodeNotFound, "revoking a role that is not held")
+	if roles := listRoles(t, stub, bob, bob.Member()); len(roles.Roles) != 0 {
+		t.Errorf("bob still holds %v", roles.Roles)
+	}
+
+	entries := []AuditEntry{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "roleAuditLog"), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != ActionGrant || entries[1].Action != ActionRevoke || entries[0].By != admin.Member() {
+		t.Errorf("audit log %+v", entries)
+	}
+}
+
+func TestLedgerAdminsGrantRoles(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.Invoke(t, stub.As(admin), "grantRole", alice.Member(), AdminRole)
+	cctest.Invoke(t, stub.As(alice), "grantRole", bob.Member(), "viewer")
+	cctest.Invoke(t, stub.As(admin), "revokeRole", alice.Member(), AdminRole)
+	cctest.AssertCode(t, stub.As(alice).Invoke("revokeRole", bob.Member(), "viewer"), errcode.CodeUnauthorized, "a former ledger admin revoking a role")
+}
+
+func TestOnlyAdminsOfAdminMSPsAreAdmins(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.AssertCode(t, stub.As(admin2).Invoke("grantRole", admin2.Member(), AdminRole), errcode.CodeUnauthorized, "an admin of another MSP granting a role")
+	cctest.AssertCode(t, stub.Invoke("setAdminMspIds", `["Org2MSP"]`), errcode.CodeUnauthorized, "an admin of another MSP adding its MSP")
+	cctest.AssertCode(t, stub.As(admin).Invoke("setAdminMspIds", `[]`), errcode.CodeInvalidArgument, "removing every admin MSP")
+	cctest.AssertCode(t, stub.Invoke("setAdminMspIds", `"Org2MSP"`), errcode.CodeInvalidArgument, "admin MSPs that are no array")
+
+	cctest.Invoke(t, stub, "setAdminMspIds", `["Org1MSP","Org2MSP"]`)
+	cctest.Invoke(t, stub.As(admin2), "grantRole", bob.Member(), "viewer")
+	if got := string(cctest.Invoke(t, stub, "adminMspIds")); got != `["Org1MSP","Org2MSP"]` {
+		t.Errorf("the admin MSPs are %s", got)
+	}
+
+	// Init on an upgrade keeps the admin MSPs
+	if res := stub.As(bob).Init(); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	cctest.Invoke(t, stub.As(admin2), "revokeRole", bob.Member(), "viewer")
+}
+
+func TestRolesIncludeOtherRoles(t *testing.T) {
+	stub := newStub(t)
+	cctest.Invoke(t, stub.As(admin), "grantRole", alice.Member(), "manager")
+
+	roles := listRoles(t, stub, bob, alice.Member())
+	if len(roles.Roles) != 1 || len(roles.EffectiveRoles) != 3 {
+		t.Errorf("alice holds %v, effectively %v", roles.Roles, roles.EffectiveRoles)
+	}
+	for role, want := range map[string]bool{"viewer": true, "manager": true, AdminRole: false} {
+		result := map[string]interface{}{}
+		if err := json.Unmarshal(cctest.Invoke(t, stub.As(alice), "hasRole", role), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result["hasRole"] != want {
+			t.Errorf("hasRole %s returned %v", role, result)
+		}
+	}
+}
+
+func TestRequireTheAttributeOrTheRole(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.Invoke(t, stub.As(operator), "operate")
+	cctest.AssertCode(t, stub.As(bob).Invoke("operate"), errcode.CodeUnauthorized, "a client without the attribute or the role")
+	cctest.AssertCode(t, stub.As(admin).Invoke("operate"), errcode.CodeUnauthorized, "an admin without the role")
+	cctest.Invoke(t, stub.As(admin), "grantRole", bob.Member(), "manager")
+	cctest.Invoke(t, stub.As(bob), "operate")
+}
@@ -0,0 +1,315 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package router dispatches chaincode invocations to handlers registered
+// by function name. Every function declares its parameters, so the router
+// checks the number and type of the arguments before the handler runs and
+// reports problems as INVALID_ARGUMENT errors (see package errcode). A
+// built-in "describe" function returns the functions and parameters of the
+// chaincode as JSON.
+//
+// A chaincode builds its router once and hands every invocation to it:
+//
+//	var r = router.New("sacc", "1.0").
+//		Add("set", "Stores a value", set,
+//			router.Required("key", router.String),
+//			router.Required("value", router.String))
+//
+//	func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+//		return r.Handle(stub)
+//	}
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// DescribeFunction is the name of the built-in function returning the
+// chaincode metadata
+const DescribeFunction = "describe"
+
+// Type is the type of a parameter. Arguments always arrive as strings and
+// are parsed according to the type of their parameter.
+type Type string
+
+// Supported parameter types
+const (
+	String Type = "string"
+	Int    Type = "int"
+	Float  Type = "float"
+	Bool   Type = "bool"
+	JSON   Type = "json"
+)
+
+// Param describes a single positional parameter of a function
+type Param struct {
+	Name        string `json:"name"`
+	Type        Type   `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// Required returns a parameter that must be passed
+func Required(name string, t Type) Param {
+	return Param{Name: name, Type: t, Required: true}
+}
+
+// Optional returns a parameter that may be left out. Optional parameters
+// must follow the required ones. An empty string counts as left out.
+func Optional(name string, t Type) Param {
+	return Param{Name: name, Type: t}
+}
+
+// Describe returns a copy of the parameter with a description for the
+// describe output
+func (p Param) Describe(description string) Param {
+	p.Description = description
+	return p
+}
+
+// Handler handles an invocation whose arguments match the declared
+// parameters
+type Handler func(stub shim.ChaincodeStubInterface, args *Args) pb.Response
+
+// Function is a registered chaincode function
+type Function struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Params      []Param `json:"params"`
+	handler     Handler
+}
+
+// Metadata is the document returned by the describe function
+type Metadata struct {
+	Chaincode string      `json:"chaincode"`
+	Version   string      `json:"version"`
+	Functions []*Function `json:"functions"`
+}
+
+// Router maps function names to handlers
+type Router struct {
+	name      string
+	version   string
+	functions map[string]*Function
+	order     []string
+}
+
+// New returns a router for the named chaincode. The version is reported by
+// describe so that clients can tell which API they are talking to.
+func New(name, version string) *Router {
+	r := &Router{
+		name:      name,
+		version:   version,
+		functions: make(map[string]*Function),
+	}
+	return r.Add(DescribeFunction, "Returns the functions of this chaincode and their parameters", r.describe)
+}
+
+// Add registers a function. It panics if the name is already registered or
+// if an optional parameter is followed by a required one, since both are
+// programming errors that should surface the first time the chaincode
+// starts.
+func (r *Router) Add(name, description string, handler Handler, params ...Param) *Router {
+	if _, exists := r.functions[name]; exists {
+		panic(fmt.Sprintf("router: function %s is registered twice", name))
+	}
+	optional := false
+	for _, p := range params {
+		if !p.Required {
+			optional = true
+		} else if optional {
+			panic(fmt.Sprintf("router: required parameter %s of %s follows an optional parameter", p.Name, name))
+		}
+	}
+	if params == nil {
+		params = []Param{}
+	}
+	r.functions[name] = &Function{Name: name, Description: description, Params: params, handler: handler}
+	r.order = append(r.order, name)
+	return r
+}
+
+// Has reports whether a function is registered
+func (r *Router) Has(name string) bool {
+	_, ok := r.functions[name]
+	return ok
+}
+
+// Handle dispatches the invocation described by the stub
+func (r *Router) Handle(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	return r.Dispatch(stub, function, args)
+}
+
+// Dispatch checks the arguments against the parameters of the function and
+// calls its handler
+func (r *Router) Dispatch(stub shim.ChaincodeStubInterface, function string, args []string) pb.Response {
+	f, ok := r.functions[function]
+	if !ok {
+		return errcode.InvalidArgument("Unknown function '%s'. Expecting one of: %s", function, strings.Join(r.order, ", ")).
+			With("function", function).Response()
+	}
+	parsed, err := f.parse(args)
+	if err != nil {
+		return err.Response()
+	}
+	return f.handler(stub, parsed)
+}
+
+// Metadata returns the functions of the chaincode in registration order
+func (r *Router) Metadata() Metadata {
+	functions := make([]*Function, len(r.order))
+	for i, name := range r.order {
+		functions[i] = r.functions[name]
+	}
+	return Metadata{Chaincode: r.name, Version: r.version, Functions: functions}
+}
+
+func (r *Router) describe(stub shim.ChaincodeStubInterface, args *Args) pb.Response {
+	metadataAsBytes, err := json.Marshal(r.Metadata())
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(metadataAsBytes)
+}
+
+// usage returns the parameter list of the function for error messages,
+// e.g. "move(from, to, amount)" or "delete(name, [mode], [reason])"
+func (f *Function) usage() string {
+	names := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		if p.Required {
+			names[i] = p.Name
+		} else {
+			names[i] = "[" + p.Name + "]"
+		}
+	}
+	return f.Name + "(" + strings.Join(names, ", ") + ")"
+}
+
+// parse checks the number of arguments and converts each one to the type
+// of its parameter
+func (f *Function) parse(args []string) (*Args, *errcode.Error) {
+	required := 0
+	for _, p := range f.Params {
+		if p.Required {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(f.Params) {
+		return nil, errcode.InvalidArgument("Incorrect number of arguments. Expecting %s", f.usage()).
+			With("function", f.Name)
+	}
+
+	parsed := &Args{raw: args, values: make(map[string]interface{}, len(args))}
+	for i, arg := range args {
+		p := f.Params[i]
+		if !p.Required && len(arg) == 0 {
+			continue
+		}
+		value, err := p.parse(arg)
+		if err != nil {
+			return nil, errcode.InvalidArgument("Argument %s of %s %s", p.Name, f.usage(), err).
+				With("function", f.Name).With("param", p.Name)
+		}
+		parsed.values[p.Name] = value
+	}
+	return parsed, nil
+}
+
+// parse converts a single argument to the type of the parameter
+func (p Param) parse(arg string) (interface{}, error) {
+	switch p.Type {
+	case String:
+		return arg, nil
+	case Int:
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer, got '%s'", arg)
+		}
+		return i, nil
+	case Float:
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("must be a number, got '%s'", arg)
+		}
+		return f, nil
+	case Bool:
+		b, err := strconv.ParseBool(arg)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false, got '%s'", arg)
+		}
+		return b, nil
+	case JSON:
+		if !json.Valid([]byte(arg)) {
+			return nil, fmt.Errorf("must be a JSON document")
+		}
+		return json.RawMessage(arg), nil
+	}
+	return nil, fmt.Errorf("has unknown type %s", p.Type)
+}
+
+// Args holds the parsed arguments of an invocation. The getters return the
+// zero value for parameters that were left out; use Has to tell them apart.
+type Args struct {
+	raw    []string
+	values map[string]interface{}
+}
+
+// Raw returns the arguments as they were passed
+func (a *Args) Raw() []string {
+	return a.raw
+}
+
+// Has reports whether an argument was passed for the parameter
+func (a *Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns the value of a String parameter
+func (a *Args) String(name string) string {
+	s, _ := a.values[name].(string)
+	return s
+}
+
+// Int returns the value of an Int parameter
+func (a *Args) Int(name string) int {
+	i, _ := a.values[name].(int)
+	return i
+}
+
+// Float returns the value of a Float parameter
+func (a *Args) Float(name string) float64 {
+	f, _ := a.values[name].(float64)
+	return f
+}
+
+// Bool returns the value of a Bool parameter
+func (a *Args) Bool(name string) bool {
+	b, _ := a.values[name].(bool)
+	return b
+}
+
+// JSON returns the value of a JSON parameter
+func (a *Args) JSON(name string) json.RawMessage {
+	j, _ := a.values[name].(json.RawMessage)
+	return j
+}
+
+// Decode unmarshals the value of a JSON parameter into v
+func (a *Args) Decode(name string, v interface{}) error {
+	j, ok := a.values[name].(json.RawMessage)
+	if !ok {
+		return fmt.Errorf("Argument %s was not passed", name)
+	}
+	return json.Unmarshal(j, v)
+}
@@ -0,0 +1,158 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testRouter has a function taking every type of parameter, which stores
+// the arguments it got in parsed
+func testRouter(parsed **Args) *Router {
+	return New("test", "1.0").
+		Add("all", "Takes every type", func(stub shim.ChaincodeStubInterface, args *Args) pb.Response {
+			*parsed = args
+			return shim.Success(nil)
+		},
+			Required("s", String),
+			Required("i", Int),
+			Required("f", Float),
+			Required("b", Bool),
+			Optional("j", JSON),
+			Optional("o", String))
+}
+
+func TestDispatchParsesArguments(t *testing.T) {
+	var args *Args
+	r := testRouter(&args)
+
+	res := r.Dispatch(nil, "all", []string{"text", "42", "1.5", "true", `{"a":1}`})
+	if res.Status != shim.OK {
+		t.Fatalf("all failed: %s", res.Message)
+	}
+	if args.String("s") != "text" || args.Int("i") != 42 || args.Float("f") != 1.5 || !args.Bool("b") {
+		t.Errorf("wrong values: %v", args.values)
+	}
+	var j struct{ A int }
+	if err := args.Decode("j", &j); err != nil || j.A != 1 {
+		t.Errorf("Decode returned %v, %v", j, err)
+	}
+	if string(args.JSON("j")) != `{"a":1}` {
+		t.Errorf("JSON returned %s", args.JSON("j"))
+	}
+	if args.Has("o") || args.String("o") != "" {
+		t.Error("an argument that was not passed is present")
+	}
+	if len(args.Raw()) != 5 {
+		t.Errorf("Raw returned %v", args.Raw())
+	}
+}
+
+func TestDispatchTreatsEmptyOptionalArgumentsAsAbsent(t *testing.T) {
+	var args *Args
+	r := testRouter(&args)
+
+	res := r.Dispatch(nil, "all", []string{"", "0", "0", "false", "", ""})
+	if res.Status != shim.OK {
+		t.Fatalf("all failed: %s", res.Message)
+	}
+	if !args.Has("s") {
+		t.Error("an empty required argument is absent")
+	}
+	if args.Has("j") || args.Has("o") {
+		t.Error("an empty optional argument is present")
+	}
+	if err := args.Decode("j", new(interface{})); err == nil {
+		t.Error("Decode of an absent argument succeeded")
+	}
+}
+
+func TestDispatchRejectsInvalidArguments(t *testing.T) {
+	var args *Args
+	r := testRouter(&args)
+
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+		param    string
+	}{
+		{"unknown function", "nope", nil, ""},
+		{"too few", "all", []string{"text", "1", "1"}, ""},
+		{"too many", "all", []string{"text", "1", "1", "true", "{}", "o", "extra"}, ""},
+		{"not an int", "all", []string{"text", "one", "1", "true"}, "i"},
+		{"not a float", "all", []string{"text", "1", "x", "true"}, "f"},
+		{"not a bool", "all", []string{"text", "1", "1", "yes"}, "b"},
+		{"not JSON", "all", []string{"text", "1", "1", "true", "{"}, "j"},
+	}
+	for _, test := range tests {
+		args = nil
+		res := r.Dispatch(nil, test.function, test.args)
+		if res.Status == shim.OK || args != nil {
+			t.Errorf("%s: the handler was called", test.name)
+			continue
+		}
+		e := errcode.Parse(res.Message)
+		if e.Code != errcode.CodeInvalidArgument {
+			t.Errorf("%s: got code %s, want %s", test.name, e.Code, errcode.CodeInvalidArgument)
+		}
+		if len(test.param) > 0 && e.Details["param"] != test.param {
+			t.Errorf("%s: got param %v, want %s", test.name, e.Details["param"], test.param)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var args *Args
+	r := testRouter(&args)
+
+	res := r.Dispatch(nil, DescribeFunction, nil)
+	if res.Status != shim.OK {
+		t.Fatalf("describe failed: %s", res.Message)
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(res.Payload, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Chaincode != "test" || metadata.Version != "1.0" {
+		t.Errorf("got %s %s", metadata.Chaincode, metadata.Version)
+	}
+	if len(metadata.Functions) != 2 || metadata.Functions[0].Name != DescribeFunction || metadata.Functions[1].Name != "all" {
+		t.Fatalf("functions are not in registration order: %+v", metadata.Functions)
+	}
+	if params := metadata.Functions[1].Params; len(params) != 6 || params[1].Type != Int || params[5].Required {
+		t.Errorf("wrong parameters: %+v", params)
+	}
+}
+
+func TestAddPanicsOnProgrammingErrors(t *testing.T) {
+	handler := func(stub shim.ChaincodeStubInterface, args *Args) pb.Response { return shim.Success(nil) }
+	tests := []struct {
+		name string
+		add  func()
+	}{
+		{"registered twice", func() {
+			New("test", "1.0").Add("f", "", handler).Add("f", "", handler)
+		}},
+		{"required after optional", func() {
+			New("test", "1.0").Add("f", "", handler, Optional("a", String), Required("b", String))
+		}},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: Add did not panic", test.name)
+				}
+			}()
+			test.add()
+		}()
+	}
+}
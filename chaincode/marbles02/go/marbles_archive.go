@@ -0,0 +1,266 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Soft delete ===========================================================================
+// delete takes an optional mode, "hard" (the default) or "soft". A soft delete removes the
+// marble and its index entries like a hard delete, but first copies the marble into the
+// archive together with who deleted it, when and why. Archived marbles live under the
+// archive~name composite key, so they never show up in marble queries.
+//
+//   restore        - moves an archived marble back and rebuilds its index entries
+//   purgeArchived  - permanently removes an archived marble once the retention window has
+//                    passed; the window is archiveRetentionDays in the chaincode configuration
+//                    (see marbles_query.go) and defaults to 30 days
+//
+// Only the owner of an archived marble can restore or purge it. A marble name can only have
+// one archived copy at a time.
+//
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["delete","marble1","soft","lost in the garden"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["readArchivedMarble","marble1"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["restore","marble1"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["purgeArchived","marble1"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// delete modes
+const (
+	deleteModeHard = "hard"
+	deleteModeSoft = "soft"
+)
+
+const archiveNameIndex = "archive~name"
+
+// defaultArchiveRetentionDays applies when the chaincode configuration does not set
+// archiveRetentionDays
+const defaultArchiveRetentionDays = 30
+
+// archivedMarble is a soft deleted marble along with its deletion metadata
+type archivedMarble struct {
+	ObjectType string `json:"docType"` //archivedMarble
+	Marble     marble `json:"marble"`
+	DeletedBy  string `json:"deletedBy"`
+	DeletedAt  string `json:"deletedAt"`
+	Reason     string `json:"reason"`
+}
+
+// archiveMarble copies a marble into the archive. The caller removes the marble itself.
+func archiveMarble(stub shim.ChaincodeStubInterface, m *marble, reason string) error {
+	existing, err := getArchivedMarble(stub, m.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errcode.AlreadyExists("An archived copy of marble %s already exists, purge it first", m.Name)
+	}
+
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err)
+	}
+	now, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	return putArchivedMarble(stub, &archivedMarble{
+		ObjectType: "archivedMarble",
+		Marble:     *m,
+		DeletedBy:  invoker,
+		DeletedAt:  now.Format(time.RFC3339),
+		Reason:     reason,
+	})
+}
+
+// ===========================================================
+// restore - move a soft deleted marble back into circulation
+// ===========================================================
+func (t *SimpleChaincode) restore(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	marbleName := args.String("name")
+	fmt.Println("- start restore ", marbleName)
+
+	archived, err := getOwnArchivedMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	// the name may have been reused since the marble was deleted
+	marbleAsBytes, err := stub.GetState(marbleName)
+	if err != nil {
+		return errcode.Internal("Failed to get marble: %s", err).Response()
+	} else if marbleAsBytes != nil {
+		return errcode.AlreadyExists("Marble %s exists, it cannot be restored over", marbleName).Response()
+	}
+
+	err = putMarble(stub, &archived.Marble)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = setMarbleEndorsementPolicy(stub, &archived.Marble)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putMarbleIndexes(stub, &archived.Marble)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = delArchivedMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = setEvent(stub, "Mint", marbleEvent{Marble: marbleName, Owner: archived.Marble.Owner})
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	fmt.Println("- end restore (success)")
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// purgeArchived - permanently remove a soft deleted marble once
+// the retention window has passed
+// ===========================================================
+func (t *SimpleChaincode) purgeArchived(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	marbleName := args.String("name")
+
+	archived, err := getOwnArchivedMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return errcode.Internal("Failed to read chaincode configuration: %s", err).Response()
+	}
+	retentionDays := config.ArchiveRetentionDays
+	if retentionDays <= 0 {
+		retentionDays = defaultArchiveRetentionDays
+	}
+
+	deletedAt, err := time.Parse(time.RFC3339, archived.DeletedAt)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	now, err := getTxTime(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	purgeableAt := deletedAt.AddDate(0, 0, retentionDays)
+	if now.Before(purgeableAt) {
+		return errcode.Conflict("Archived marble %s cannot be purged before %s", marbleName, purgeableAt.Format(time.RFC3339)).Response()
+	}
+
+	err = delArchivedMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Println("- purged archived marble ", marbleName)
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// readArchivedMarble - read a soft deleted marble and its
+// deletion metadata
+// ===========================================================
+func (t *SimpleChaincode) readArchivedMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	marbleName := args.String("name")
+	archived, err := getArchivedMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	} else if archived == nil {
+		return errcode.NotFound("Archived marble does not exist: %s", marbleName).Response()
+	}
+
+	archivedJSONasBytes, err := json.Marshal(archived)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(archivedJSONasBytes)
+}
+
+// getOwnArchivedMarble reads an archived marble and checks that the invoker owns it
+func getOwnArchivedMarble(stub shim.ChaincodeStubInterface, marbleName string) (*archivedMarble, error) {
+	archived, err := getArchivedMarble(stub, marbleName)
+	if err != nil {
+		return nil, err
+	} else if archived == nil {
+		return nil, errcode.NotFound("Archived marble does not exist: %s", marbleName)
+	}
+	err = assertInvokerOwnsMarble(stub, &archived.Marble)
+	if err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// getArchivedMarble reads an archived marble from state, returning nil if there is none
+func getArchivedMarble(stub shim.ChaincodeStubInterface, marbleName string) (*archivedMarble, error) {
+	archiveKey, err := stub.CreateCompositeKey(archiveNameIndex, []string{marbleName})
+	if err != nil {
+		return nil, err
+	}
+	archivedAsBytes, err := stub.GetState(archiveKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get archived marble: %s", err)
+	} else if archivedAsBytes == nil {
+		return nil, nil
+	}
+
+	archived := &archivedMarble{}
+	err = json.Unmarshal(archivedAsBytes, archived)
+	if err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// putArchivedMarble writes an archived marble to state
+func putArchivedMarble(stub shim.ChaincodeStubInterface, archived *archivedMarble) error {
+	archiveKey, err := stub.CreateCompositeKey(archiveNameIndex, []string{archived.Marble.Name})
+	if err != nil {
+		return err
+	}
+	archivedJSONasBytes, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(archiveKey, archivedJSONasBytes)
+}
+
+// delArchivedMarble removes an archived marble from state
+func delArchivedMarble(stub shim.ChaincodeStubInterface, marbleName string) error {
+	archiveKey, err := stub.CreateCompositeKey(archiveNameIndex, []string{marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(archiveKey)
+}
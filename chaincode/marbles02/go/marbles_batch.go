@@ -0,0 +1,296 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Batch operations ======================================================================
+// initMarblesBatch, transferMarblesBatch and deleteMarblesBatch apply the single marble
+// functions to every item of a JSON array and return a report with one entry per item.
+//
+// Each batch runs in one of two modes, passed as the optional mode argument:
+//   atomic     - (default) if any item fails the whole transaction fails and nothing is
+//                written; the error has the code of the first failed item and carries the
+//                report in its details
+//   bestEffort - failed items are skipped, the remaining items are written and the report
+//                tells the client which items failed; the writes and the event of an item
+//                are held back until it succeeds, so a failed item writes nothing
+//
+// A batch may not name the same marble twice, because reads within a transaction do not see
+// the transaction's own writes. The number of items is limited by maxBatchSize in the chaincode
+// configuration (see marbles_query.go) to keep transactions within the peer's limits.
+//
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarblesBatch","[{\"name\":\"marble5\",\"color\":\"blue\",\"size\":10},{\"name\":\"marble6\",\"color\":\"red\",\"size\":20}]"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarblesBatch","[{\"name\":\"marble5\",\"newOwner\":\"Org2MSP::<id>\"}]","bestEffort"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["deleteMarblesBatch","[\"marble5\",\"marble6\"]","atomic"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// batch modes
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "bestEffort"
+)
+
+// defaultMaxBatchSize applies when the chaincode configuration does not set maxBatchSize
+const defaultMaxBatchSize = 50
+
+// batch item statuses
+const (
+	batchItemOK     = "ok"
+	batchItemFailed = "failed"
+)
+
+type batchInitItem struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	Size  int    `json:"size"`
+}
+
+type batchTransferItem struct {
+	Name     string `json:"name"`
+	NewOwner string `json:"newOwner"`
+}
+
+// batchItemResult reports the outcome of a single batch item
+type batchItemResult struct {
+	Key    string       `json:"key"`
+	Status string       `json:"status"`
+	Code   errcode.Code `json:"code,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// batchReport is returned by every batch function
+type batchReport struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []batchItemResult `json:"results"`
+}
+
+// ============================================================
+// initMarblesBatch - create several marbles owned by the invoker
+// ============================================================
+func (t *SimpleChaincode) initMarblesBatch(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   items                                             mode
+	// "[{"name":"marble5","color":"blue","size":10}]", "atomic"
+	mode, err := batchModeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	var items []batchInitItem
+	err = args.Decode("items", &items)
+	if err != nil {
+		return errcode.InvalidArgument("Failed to decode batch: %s", err).Response()
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Name
+	}
+
+	return runBatch(stub, mode, keys, func(stub shim.ChaincodeStubInterface, i int) error {
+		item := items[i]
+		return createMarble(stub, item.Name, item.Color, item.Size)
+	})
+}
+
+// ============================================================
+// transferMarblesBatch - transfer several of the invoker's marbles
+// ============================================================
+func (t *SimpleChaincode) transferMarblesBatch(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   items                                              mode
+	// "[{"name":"marble5","newOwner":"Org2MSP::<id>"}]", "bestEffort"
+	mode, err := batchModeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	var items []batchTransferItem
+	err = args.Decode("items", &items)
+	if err != nil {
+		return errcode.InvalidArgument("Failed to decode batch: %s", err).Response()
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		keys[i] = item.Name
+	}
+
+	return runBatch(stub, mode, keys, func(stub shim.ChaincodeStubInterface, i int) error {
+		item := items[i]
+		return transferMarbleTo(stub, item.Name, item.NewOwner)
+	})
+}
+
+// ============================================================
+// deleteMarblesBatch - delete several of the invoker's marbles
+// ============================================================
+func (t *SimpleChaincode) deleteMarblesBatch(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   names                    mode
+	// "["marble5","marble6"]", "atomic"
+	mode, err := batchModeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	var keys []string
+	err = args.Decode("names", &keys)
+	if err != nil {
+		return errcode.InvalidArgument("Failed to decode batch: %s", err).Response()
+	}
+
+	return runBatch(stub, mode, keys, func(stub shim.ChaincodeStubInterface, i int) error {
+		return deleteMarble(stub, keys[i], deleteModeHard, "")
+	})
+}
+
+// batchModeOf returns the requested batch mode
+func batchModeOf(args *router.Args) (string, error) {
+	mode := batchModeAtomic
+	if args.Has("mode") {
+		mode = args.String("mode")
+	}
+	if mode != batchModeAtomic && mode != batchModeBestEffort {
+		return "", errcode.InvalidArgument("Batch mode must be '%s' or '%s', got '%s'", batchModeAtomic, batchModeBestEffort, mode)
+	}
+	return mode, nil
+}
+
+// runBatch applies fn to every item of a batch and builds the report. keys[i] names the
+// marble touched by item i. fn writes through the stub it is given, whose writes are applied
+// only if fn succeeds.
+func runBatch(stub shim.ChaincodeStubInterface, mode string, keys []string, fn func(stub shim.ChaincodeStubInterface, i int) error) pb.Response {
+	config, err := getConfig(stub)
+	if err != nil {
+		return errcode.Internal("Failed to read chaincode configuration: %s", err).Response()
+	}
+	maxBatchSize := config.MaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = defaultMaxBatchSize
+	}
+	if len(keys) == 0 {
+		return errcode.InvalidArgument("Batch is empty").Response()
+	}
+	if len(keys) > maxBatchSize {
+		return errcode.InvalidArgument("Batch has %d items, the maximum is %d", len(keys), maxBatchSize).Response()
+	}
+
+	report := batchReport{Mode: mode, Results: make([]batchItemResult, len(keys))}
+	seen := make(map[string]bool, len(keys))
+	staged := &stagedStub{ChaincodeStubInterface: stub}
+	for i, key := range keys {
+		result := batchItemResult{Key: key, Status: batchItemOK}
+		if seen[key] {
+			result.Status = batchItemFailed
+			result.Code = errcode.CodeInvalidArgument
+			result.Error = "Marble appears more than once in the batch"
+		} else if err := fn(staged, i); err != nil {
+			cause := errcode.From(err)
+			result.Status = batchItemFailed
+			result.Code = cause.Code
+			result.Error = cause.Message
+		} else if err := staged.apply(); err != nil {
+			return errcode.Response(err)
+		}
+		staged.discard()
+		seen[key] = true
+
+		if result.Status == batchItemOK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+		report.Results[i] = result
+	}
+
+	reportJSONasBytes, err := json.Marshal(report)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- batch report:\n%s\n", string(reportJSONasBytes))
+
+	if mode == batchModeAtomic && report.Failed > 0 {
+		for _, result := range report.Results {
+			if result.Status == batchItemFailed {
+				return errcode.New(result.Code, "Batch failed, %d of %d items failed", report.Failed, len(keys)).
+					With("report", report).Response()
+			}
+		}
+	}
+	return shim.Success(reportJSONasBytes)
+}
+
+// stagedStub holds back the writes and the event of a batch item until the item succeeds.
+// Reads go to the ledger, which is also what they see within a transaction.
+type stagedStub struct {
+	shim.ChaincodeStubInterface
+	writes []func() error
+}
+
+func (s *stagedStub) PutState(key string, value []byte) error {
+	if len(key) == 0 {
+		return errcode.InvalidArgument("Key must not be an empty string")
+	}
+	s.writes = append(s.writes, func() error { return s.ChaincodeStubInterface.PutState(key, value) })
+	return nil
+}
+
+func (s *stagedStub) DelState(key string) error {
+	s.writes = append(s.writes, func() error { return s.ChaincodeStubInterface.DelState(key) })
+	return nil
+}
+
+func (s *stagedStub) SetStateValidationParameter(key string, ep []byte) error {
+	s.writes = append(s.writes, func() error { return s.ChaincodeStubInterface.SetStateValidationParameter(key, ep) })
+	return nil
+}
+
+func (s *stagedStub) SetEvent(name string, payload []byte) error {
+	if len(name) == 0 {
+		return errcode.InvalidArgument("Event name must not be an empty string")
+	}
+	s.writes = append(s.writes, func() error { return s.ChaincodeStubInterface.SetEvent(name, payload) })
+	return nil
+}
+
+// apply makes the writes held back so far
+func (s *stagedStub) apply() error {
+	for _, write := range s.writes {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discard drops the writes held back so far
+func (s *stagedStub) discard() {
+	s.writes = nil
+}
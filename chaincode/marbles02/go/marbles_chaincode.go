@@ -20,22 +20,62 @@ under the License.
 // ====CHAINCODE EXECUTION SAMPLES (CLI) ==================
 
 // ==== Invoke marbles ====
-// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble1","blue","35","tom"]}'
-// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble2","red","50","tom"]}'
-// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble3","blue","70","tom"]}'
-// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarble","marble2","jerry"]}'
-// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarblesBasedOnColor","blue","jerry"]}'
+// Marbles are owned by the identity that creates them. An owner is identified by the MSP ID and
+// the certificate-derived ID of the client identity, joined as "<mspid>::<id>"; the whoAmI query
+// returns the owner ID of the invoker. Only the owner can transfer or delete a marble.
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble1","blue","35"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble2","red","50"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarble","marble3","blue","70"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarble","marble2","Org2MSP::eDUwOTo6Q049amVycnkuLi4="]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferMarblesBasedOnColor","blue","Org2MSP::eDUwOTo6Q049amVycnkuLi4="]}'
 // peer chaincode invoke -C myc1 -n marbles -c '{"Args":["delete","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["delete","marble1","soft","lost in the garden"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["restore","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["initMarblesBatch","[{\"name\":\"marble5\",\"color\":\"blue\",\"size\":10}]","bestEffort"]}'
+
+// ==== Lock, escrow and retire marbles (see marbles_lifecycle.go) ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["lockMarble","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["escrowMarble","marble1","Org3MSP::<id>","86400"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["releaseEscrow","marble1"]}'
+
+// ==== Approve others to transfer marbles (see marbles_nft.go) ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["approve","Org2MSP::eDUwOTo6Q049amVycnkuLi4=","marble1"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferFrom","Org1MSP::eDUwOTo6Q049dG9tLi4u","Org2MSP::eDUwOTo6Q049amVycnkuLi4=","marble1"]}'
+
+// ==== Trade marbles (see marbles_ownership.go) ====
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["offerMarble","marble3","Org2MSP::eDUwOTo6Q049amVycnkuLi4=","marble4",""]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["offerMarble","marble2","Org2MSP::eDUwOTo6Q049amVycnkuLi4=","","25"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["acceptOffer","<offerID>"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["rejectOffer","<offerID>"]}'
+// peer chaincode invoke -C myc1 -n marbles -c '{"Args":["cancelOffer","<offerID>"]}'
 
 // ==== Query marbles ====
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["whoAmI"]}'
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["readMarble","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["readOffer","<offerID>"]}'
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarblesByRange","marble1","marble3"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarblesByRangeWithPagination","marble1","marble3","3",""]}'
 // peer chaincode query -C myc1 -n marbles -c '{"Args":["getHistoryForMarble","marble1"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarbleEndorsementPolicy","marble1"]}'
+
+// Index Query (Supported by both LevelDB and CouchDB, see marbles_index.go):
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarblesByOwner","Org1MSP::eDUwOTo6Q049dG9tLi4u"]}'
+// peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarblesBySizeRange","10","50"]}'
 
 // Rich Query (Only supported if CouchDB is used as state database):
-//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByOwner","tom"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByOwner","Org1MSP::eDUwOTo6Q049dG9tLi4u"]}'
 //   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarbles","{\"selector\":{\"owner\":\"tom\"}}"]}'
 
+// Rich Query with Pagination (Only supported if CouchDB is used as state database):
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByOwnerWithPagination","Org1MSP::eDUwOTo6Q049dG9tLi4u","3",""]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesWithPagination","{\"selector\":{\"owner\":\"tom\"}}","3",""]}'
+
+// Structured Query (Only supported if CouchDB is used as state database, see marbles_query.go):
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByFilter","{\"filters\":[{\"field\":\"color\",\"op\":\"$eq\",\"value\":\"blue\"}]}"]}'
+
+// Export and import (see marbles_export.go):
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["exportState","","","50",""]}'
+
 // INDEXES TO SUPPORT COUCHDB RICH QUERIES
 //
 // Indexes in CouchDB are required in order to make JSON queries efficient and are required for
@@ -103,10 +143,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -116,11 +159,14 @@ type SimpleChaincode struct {
 }
 
 type marble struct {
-	ObjectType string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
-	Color      string `json:"color"`
-	Size       int    `json:"size"`
-	Owner      string `json:"owner"`
+	ObjectType string        `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	Name       string        `json:"name"`    //the fieldtags are needed to keep case from bouncing around
+	Color      string        `json:"color"`
+	Size       int           `json:"size"`
+	Owner      string        `json:"owner"`  //owner ID of the client identity that owns the marble, see getInvokerOwnerID
+	Status     string        `json:"status"` //lifecycle status, see marbles_lifecycle.go
+	LockedBy   string        `json:"lockedBy,omitempty"`
+	Escrow     *marbleEscrow `json:"escrow,omitempty"`
 }
 
 // ===================================================================================
@@ -134,8 +180,33 @@ func main() {
 }
 
 // Init initializes chaincode
+// An optional JSON configuration document may be passed, see marbles_query.go.
+// Without one the defaults are stored on instantiate and kept as they are on upgrade.
 // ===========================
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	_, args := stub.GetFunctionAndParameters()
+
+	config := chaincodeConfig{}
+	if len(args) > 0 && len(args[0]) > 0 {
+		err := json.Unmarshal([]byte(args[0]), &config)
+		if err != nil {
+			return errcode.InvalidArgument("Failed to decode chaincode configuration: %s", err).Response()
+		}
+	} else {
+		// An upgrade without a configuration keeps the current one
+		exists, err := configExists(stub)
+		if err != nil {
+			return errcode.Internal("Failed to get chaincode configuration: %s", err).Response()
+		}
+		if exists {
+			return shim.Success(nil)
+		}
+	}
+
+	err := putConfig(stub, config)
+	if err != nil {
+		return errcode.Response(err)
+	}
 	return shim.Success(nil)
 }
 
@@ -145,80 +216,210 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	if function == "initMarble" { //create a new marble
-		return t.initMarble(stub, args)
-	} else if function == "transferMarble" { //change owner of a specific marble
-		return t.transferMarble(stub, args)
-	} else if function == "transferMarblesBasedOnColor" { //transfer all marbles of a certain color
-		return t.transferMarblesBasedOnColor(stub, args)
-	} else if function == "delete" { //delete a marble
-		return t.delete(stub, args)
-	} else if function == "readMarble" { //read a marble
-		return t.readMarble(stub, args)
-	} else if function == "queryMarblesByOwner" { //find marbles for owner X using rich query
-		return t.queryMarblesByOwner(stub, args)
-	} else if function == "queryMarbles" { //find marbles based on an ad hoc rich query
-		return t.queryMarbles(stub, args)
-	} else if function == "getHistoryForMarble" { //get history of values for a marble
-		return t.getHistoryForMarble(stub, args)
-	} else if function == "getMarblesByRange" { //get marbles based on range query
-		return t.getMarblesByRange(stub, args)
-	}
-
-	fmt.Println("invoke did not find func: " + function) //error
-	return shim.Error("Received unknown function invocation")
+	// Hold back the events of the handlers so that all of them are emitted, see marbles_events.go
+	events := newEventCollector(stub)
+	response := routes.Dispatch(events, function, args)
+	if response.Status != shim.OK {
+		return response
+	}
+	err := events.flush()
+	if err != nil {
+		return errcode.Internal("Failed to set event: %s", err).Response()
+	}
+	return response
+}
+
+// routes lists the functions of the chaincode and their parameters. The router checks the
+// arguments of every invocation against the parameters and hands the parsed arguments to the
+// handler, and the built-in "describe" function returns the list as JSON. It is built once,
+// since the chaincode keeps no state of its own.
+var routes = new(SimpleChaincode).buildRoutes()
+
+// buildRoutes registers the handlers of the chaincode. Handlers that are also used by other
+// functions, e.g. the batch functions in marbles_batch.go, are thin wrappers around a function
+// taking typed arguments.
+func (t *SimpleChaincode) buildRoutes() *router.Router {
+	return router.New("marbles", "1.0").
+		Add("initMarble", "Create a new marble", t.initMarble,
+			router.Required("name", router.String),
+			router.Required("color", router.String),
+			router.Required("size", router.Int)).
+		Add("transferMarble", "Change owner of a specific marble", t.transferMarble,
+			router.Required("name", router.String),
+			router.Required("newOwner", router.String)).
+		Add("transferMarblesBasedOnColor", "Transfer all marbles of a certain color", t.transferMarblesBasedOnColor,
+			router.Required("color", router.String),
+			router.Required("newOwner", router.String)).
+		Add("delete", "Delete a marble", t.delete,
+			router.Required("name", router.String),
+			router.Optional("mode", router.String).Describe("hard (default) or soft"),
+			router.Optional("reason", router.String)).
+		Add("readMarble", "Read a marble", t.readMarble,
+			router.Required("name", router.String)).
+		Add("queryMarblesByOwner", "Find marbles for owner X using rich query", t.queryMarblesByOwner,
+			router.Required("owner", router.String)).
+		Add("queryMarbles", "Find marbles based on an ad hoc rich query", t.queryMarbles,
+			router.Required("queryString", router.JSON)).
+		Add("getHistoryForMarble", "Get history of values for a marble", t.getHistoryForMarble,
+			router.Required("name", router.String)).
+		Add("getMarblesByRange", "Get marbles based on range query", t.getMarblesByRange,
+			router.Required("startKey", router.String),
+			router.Required("endKey", router.String)).
+		Add("getMarblesByRangeWithPagination", "Get a page of marbles based on range query", t.getMarblesByRangeWithPagination,
+			router.Required("startKey", router.String),
+			router.Required("endKey", router.String),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("queryMarblesByOwnerWithPagination", "Find a page of marbles for owner X using rich query", t.queryMarblesByOwnerWithPagination,
+			router.Required("owner", router.String),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("queryMarblesWithPagination", "Find a page of marbles based on an ad hoc rich query", t.queryMarblesWithPagination,
+			router.Required("queryString", router.JSON),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("queryMarblesByFilter", "Find marbles based on a validated structured query", t.queryMarblesByFilter,
+			router.Required("query", router.JSON)).
+		Add("queryMarblesByFilterWithPagination", "Find a page of marbles based on a validated structured query", t.queryMarblesByFilterWithPagination,
+			router.Required("query", router.JSON),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("getMarbleEndorsementPolicy", "Get the orgs that must endorse changes to a marble", t.getMarbleEndorsementPolicy,
+			router.Required("name", router.String)).
+		Add("ownerOf", "Get the owner ID of a marble", t.ownerOf,
+			router.Required("name", router.String)).
+		Add("balanceOf", "Count the marbles of an owner", t.balanceOf,
+			router.Required("owner", router.String)).
+		Add("approve", "Let another identity transfer a marble; empty to clear", t.approve,
+			router.Required("approved", router.String),
+			router.Required("name", router.String)).
+		Add("getApproved", "Get the identity approved to transfer a marble", t.getApproved,
+			router.Required("name", router.String)).
+		Add("setApprovalForAll", "Let an operator transfer all marbles of the invoker, or stop it", t.setApprovalForAll,
+			router.Required("operator", router.String),
+			router.Required("approved", router.Bool)).
+		Add("isApprovedForAll", "Check whether an operator may transfer all marbles of an owner", t.isApprovedForAll,
+			router.Required("owner", router.String),
+			router.Required("operator", router.String)).
+		Add("transferFrom", "Transfer a marble owned by a given owner", t.transferFrom,
+			router.Required("from", router.String),
+			router.Required("to", router.String),
+			router.Required("name", router.String)).
+		Add("tokenURI", "Get the metadata URI of a marble", t.tokenURI,
+			router.Required("name", router.String)).
+		Add("whoAmI", "Get the owner ID of the invoking identity", t.whoAmI).
+		Add("offerMarble", "Offer a marble to another owner for a swap or a sale", t.offerMarble,
+			router.Required("marble", router.String),
+			router.Required("counterparty", router.String),
+			router.Required("requestedMarble", router.String).Describe("empty for a sale"),
+			router.Required("price", router.String).Describe("tokens of the payment chaincode, empty for a swap")).
+		Add("acceptOffer", "Accept an offer made to the invoker", t.acceptOffer,
+			router.Required("offerID", router.String)).
+		Add("rejectOffer", "Reject an offer made to the invoker", t.rejectOffer,
+			router.Required("offerID", router.String)).
+		Add("cancelOffer", "Withdraw an offer made by the invoker", t.cancelOffer,
+			router.Required("offerID", router.String)).
+		Add("readOffer", "Read an offer", t.readOffer,
+			router.Required("offerID", router.String)).
+		Add("migrateOwner", "Hand a marble with a free-text owner to an owner ID", t.migrateOwner,
+			router.Required("name", router.String),
+			router.Required("newOwner", router.String)).
+		Add("getMarblesByOwner", "Find marbles for owner X using the owner~name index", t.getMarblesByOwner,
+			router.Required("owner", router.String)).
+		Add("getMarblesBySizeRange", "Find marbles within a size range using the size~name index", t.getMarblesBySizeRange,
+			router.Required("minSize", router.Int),
+			router.Required("maxSize", router.Int)).
+		Add("lockMarble", "Freeze a marble", t.lockMarble,
+			router.Required("name", router.String)).
+		Add("unlockMarble", "Unfreeze a marble", t.unlockMarble,
+			router.Required("name", router.String)).
+		Add("retireMarble", "Take a marble out of circulation", t.retireMarble,
+			router.Required("name", router.String)).
+		Add("escrowMarble", "Place a marble in escrow", t.escrowMarble,
+			router.Required("name", router.String),
+			router.Required("holder", router.String),
+			router.Required("seconds", router.Int)).
+		Add("releaseEscrow", "Release a marble from escrow", t.releaseEscrow,
+			router.Required("name", router.String),
+			router.Optional("recipient", router.String)).
+		Add("restore", "Bring back a soft deleted marble", t.restore,
+			router.Required("name", router.String)).
+		Add("purgeArchived", "Permanently remove a soft deleted marble", t.purgeArchived,
+			router.Required("name", router.String)).
+		Add("readArchivedMarble", "Read a soft deleted marble", t.readArchivedMarble,
+			router.Required("name", router.String)).
+		Add("exportState", "Export a page of marbles as NDJSON", t.exportState,
+			router.Required("startKey", router.String),
+			router.Required("endKey", router.String),
+			router.Required("pageSize", router.Int),
+			router.Required("bookmark", router.String)).
+		Add("importState", "Import marbles exported with exportState", t.importState,
+			router.Required("records", router.String),
+			router.Optional("force", router.Bool)).
+		Add("initMarblesBatch", "Create several marbles", t.initMarblesBatch,
+			router.Required("items", router.JSON),
+			router.Optional("mode", router.String).Describe("atomic (default) or bestEffort")).
+		Add("transferMarblesBatch", "Transfer several marbles", t.transferMarblesBatch,
+			router.Required("items", router.JSON),
+			router.Optional("mode", router.String).Describe("atomic (default) or bestEffort")).
+		Add("deleteMarblesBatch", "Delete several marbles", t.deleteMarblesBatch,
+			router.Required("names", router.JSON),
+			router.Optional("mode", router.String).Describe("atomic (default) or bestEffort"))
 }
 
 // ============================================================
-// initMarble - create a new marble, store into chaincode state
+// initMarble - create a new marble owned by the invoker, store into chaincode state
 // ============================================================
-func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
+func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0       1       2     3
-	// "asdf", "blue", "35", "bob"
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
-	}
+	//   name    color   size
+	// "asdf", "blue", "35"
 
-	// ==== Input sanitation ====
 	fmt.Println("- start init marble")
-	if len(args[0]) <= 0 {
-		return shim.Error("1st argument must be a non-empty string")
+	err := createMarble(stub, args.String("name"), args.String("color"), args.Int("size"))
+	if err != nil {
+		return errcode.Response(err)
 	}
-	if len(args[1]) <= 0 {
-		return shim.Error("2nd argument must be a non-empty string")
+
+	// ==== Marble saved and indexed. Return success ====
+	fmt.Println("- end init marble")
+	return shim.Success(nil)
+}
+
+// createMarble creates a marble owned by the invoker, for initMarble and initMarblesBatch
+func createMarble(stub shim.ChaincodeStubInterface, marbleName, color string, size int) error {
+
+	// ==== Input sanitation ====
+	color = strings.ToLower(color)
+	if len(marbleName) <= 0 {
+		return errcode.InvalidArgument("name must be a non-empty string")
 	}
-	if len(args[2]) <= 0 {
-		return shim.Error("3rd argument must be a non-empty string")
+	if len(color) <= 0 {
+		return errcode.InvalidArgument("color must be a non-empty string")
 	}
-	if len(args[3]) <= 0 {
-		return shim.Error("4th argument must be a non-empty string")
+	if size < 0 {
+		return errcode.InvalidArgument("size must not be negative")
 	}
-	marbleName := args[0]
-	color := strings.ToLower(args[1])
-	owner := strings.ToLower(args[3])
-	size, err := strconv.Atoi(args[2])
+	owner, err := getInvokerOwnerID(stub)
 	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err)
 	}
 
 	// ==== Check if marble already exists ====
 	marbleAsBytes, err := stub.GetState(marbleName)
 	if err != nil {
-		return shim.Error("Failed to get marble: " + err.Error())
+		return errcode.Internal("Failed to get marble: %s", err)
 	} else if marbleAsBytes != nil {
 		fmt.Println("This marble already exists: " + marbleName)
-		return shim.Error("This marble already exists: " + marbleName)
+		return errcode.AlreadyExists("This marble already exists: %s", marbleName)
 	}
 
 	// ==== Create marble object and marshal to JSON ====
 	objectType := "marble"
-	marble := &marble{objectType, marbleName, color, size, owner}
+	marble := &marble{ObjectType: objectType, Name: marbleName, Color: color, Size: size, Owner: owner, Status: marbleActive}
 	marbleJSONasBytes, err := json.Marshal(marble)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 	//Alternatively, build the marble json string manually if you don't want to use struct marshalling
 	//marbleJSONasString := `{"docType":"Marble",  "name": "` + marbleName + `", "color": "` + color + `", "size": ` + strconv.Itoa(size) + `, "owner": "` + owner + `"}`
@@ -227,137 +428,176 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 	// === Save marble to state ===
 	err = stub.PutState(marbleName, marbleJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
-	//  ==== Index the marble to enable color-based range queries, e.g. return all blue marbles ====
+	// ==== Only the owner's org may endorse changes to the marble, see marbles_endorsement.go ====
+	err = setMarbleEndorsementPolicy(stub, marble)
+	if err != nil {
+		return err
+	}
+
+	//  ==== Index the marble to enable color, owner and size based range queries, e.g. return all blue marbles ====
 	//  An 'index' is a normal key/value entry in state.
 	//  The key is a composite key, with the elements that you want to range query on listed first.
-	//  In our case, the composite key is based on indexName~color~name.
+	//  In our case, the composite keys are based on indexName~color~name, indexName~owner~name and indexName~size~name.
 	//  This will enable very efficient state range queries based on composite keys matching indexName~color~*
-	indexName := "color~name"
-	colorNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{marble.Color, marble.Name})
+	//  The indexes are maintained in marbles_index.go.
+	err = putMarbleIndexes(stub, marble)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the marble.
-	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
-	value := []byte{0x00}
-	stub.PutState(colorNameIndexKey, value)
 
-	// ==== Marble saved and indexed. Return success ====
-	fmt.Println("- end init marble")
-	return shim.Success(nil)
+	return setEvent(stub, "Mint", marbleEvent{Marble: marbleName, Owner: owner})
 }
 
 // ===============================================
 // readMarble - read a marble from chaincode state
 // ===============================================
-func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
-
-	name = args[0]
+func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	name := args.String("name")
 	valAsbytes, err := stub.GetState(name) //get the marble from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", name).Response()
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Marble does not exist: %s", name).Response()
 	}
 
 	return shim.Success(valAsbytes)
 }
 
 // ==================================================
-// delete - remove a marble key/value pair from state
+// delete - remove a marble key/value pair from state.
+// A soft delete moves the marble to the archive so it
+// can be restored later.
 // ==================================================
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var jsonResp string
+func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   name       mode (optional)   reason (optional)
+	// "marble1", "soft",            "reason"
+	mode := deleteModeHard
+	if args.Has("mode") {
+		mode = args.String("mode")
+	}
+
+	err := deleteMarble(stub, args.String("name"), mode, args.String("reason"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// deleteMarble deletes one of the invoker's marbles, for delete and deleteMarblesBatch
+func deleteMarble(stub shim.ChaincodeStubInterface, marbleName, mode, reason string) error {
 	var marbleJSON marble
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if mode != deleteModeHard && mode != deleteModeSoft {
+		return errcode.InvalidArgument("Delete mode must be '%s' or '%s'", deleteModeHard, deleteModeSoft)
 	}
-	marbleName := args[0]
 
-	// to maintain the color~name index, we need to read the marble first and get its color
+	// to maintain the indexes, we need to read the marble first and get its color, owner and size
 	valAsbytes, err := stub.GetState(marbleName) //get the marble from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", marbleName)
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble does not exist: " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Marble does not exist: %s", marbleName)
 	}
 
 	err = json.Unmarshal([]byte(valAsbytes), &marbleJSON)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to decode JSON of: %s", marbleName)
 	}
 
-	err = stub.DelState(marbleName) //remove the marble from chaincode state
+	// only the owner may delete a marble, and not while it is locked or in escrow
+	err = assertInvokerOwnsMarble(stub, &marbleJSON)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return err
+	}
+	if status := statusOf(&marbleJSON); status == marbleLocked || status == marbleEscrowed {
+		return errcode.Conflict("Marble %s is %s", marbleName, status)
+	}
+
+	// a soft delete keeps a copy of the marble in the archive, see marbles_archive.go
+	if mode == deleteModeSoft {
+		err = archiveMarble(stub, &marbleJSON, reason)
+		if err != nil {
+			return errcode.Wrap(err, "Failed to archive marble")
+		}
 	}
 
-	// maintain the index
-	indexName := "color~name"
-	colorNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{marbleJSON.Color, marbleJSON.Name})
+	err = stub.DelState(marbleName) //remove the marble from chaincode state
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Internal("Failed to delete state:%s", err)
 	}
 
-	//  Delete index entry to state.
-	err = stub.DelState(colorNameIndexKey)
+	// maintain the indexes
+	err = delMarbleIndexes(stub, &marbleJSON)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errcode.Internal("Failed to delete state:%s", err)
 	}
-	return shim.Success(nil)
+	err = clearApproval(stub, marbleName)
+	if err != nil {
+		return errcode.Internal("Failed to delete state:%s", err)
+	}
+
+	return setEvent(stub, "Burn", marbleEvent{Marble: marbleName, Owner: marbleJSON.Owner})
 }
 
 // ===========================================================
-// transfer a marble by setting a new owner name on the marble
+// transfer a marble by setting a new owner ID on the marble.
+// Only the current owner of the marble, the identity it
+// approved for the marble and its operators may transfer it,
+// see marbles_nft.go.
 // ===========================================================
-func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   name    newOwner
+	// "name", "Org2MSP::<id>"
+	marbleName := args.String("name")
+	newOwner := args.String("newOwner")
+	fmt.Println("- start transferMarble ", marbleName, newOwner)
 
-	//   0       1
-	// "name", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	err := transferMarbleTo(stub, marbleName, newOwner)
+	if err != nil {
+		return errcode.Response(err)
 	}
 
-	marbleName := args[0]
-	newOwner := strings.ToLower(args[1])
-	fmt.Println("- start transferMarble ", marbleName, newOwner)
+	fmt.Println("- end transferMarble (success)")
+	return shim.Success(nil)
+}
+
+// transferMarbleTo transfers a marble the invoker may transfer, for transferMarble,
+// transferFrom and the functions transferring several marbles
+func transferMarbleTo(stub shim.ChaincodeStubInterface, marbleName, newOwner string) error {
+	err := validateOwnerID(newOwner)
+	if err != nil {
+		return err
+	}
 
 	marbleAsBytes, err := stub.GetState(marbleName)
 	if err != nil {
-		return shim.Error("Failed to get marble:" + err.Error())
+		return errcode.Internal("Failed to get marble:%s", err)
 	} else if marbleAsBytes == nil {
-		return shim.Error("Marble does not exist")
+		return errcode.NotFound("Marble does not exist")
 	}
 
 	marbleToTransfer := marble{}
 	err = json.Unmarshal(marbleAsBytes, &marbleToTransfer) //unmarshal it aka JSON.parse()
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	marbleToTransfer.Owner = newOwner //change the owner
 
-	marbleJSONasBytes, _ := json.Marshal(marbleToTransfer)
-	err = stub.PutState(marbleName, marbleJSONasBytes) //rewrite the marble
+	err = assertInvokerMayTransfer(stub, &marbleToTransfer)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 
-	fmt.Println("- end transferMarble (success)")
-	return shim.Success(nil)
+	// only active marbles can change hands
+	err = assertMarbleActive(&marbleToTransfer)
+	if err != nil {
+		return err
+	}
+
+	return changeMarbleOwner(stub, &marbleToTransfer, newOwner) //change the owner and rewrite the marble
 }
 
 // ===========================================================================================
@@ -371,106 +611,132 @@ func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args
 // time and commit time.
 // Therefore, range queries are a safe option for performing update transactions based on query results.
 // ===========================================================================================
-func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
-	startKey := args[0]
-	endKey := args[1]
+	startKey := args.String("startKey")
+	endKey := args.String("endKey")
 
 	resultsIterator, err := stub.GetStateByRange(startKey, endKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	queryResults, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return errcode.Response(err)
+	}
 
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
+	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", string(queryResults))
 
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+	return shim.Success(queryResults)
+}
+
+// ===========================================================================================
+// getMarblesByRangeWithPagination performs a range query based on the start and end keys
+// provided, returning at most pageSize marbles per call. The bookmark returned in the
+// response metadata is passed back in to fetch the next page; an empty bookmark starts
+// from startKey.
+// ===========================================================================================
+func (t *SimpleChaincode) getMarblesByRangeWithPagination(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   startKey   endKey     pageSize  bookmark
+	// "marble1", "marble9", "10",     "bookmark"
+	startKey := args.String("startKey")
+	endKey := args.String("endKey")
+	pageSize, err := pageSizeOf(args)
+	if err != nil {
+		return errcode.Response(err)
 	}
-	buffer.WriteString("]")
+	bookmark := args.String("bookmark")
 
-	fmt.Printf("- getMarblesByRange queryResult:\n%s\n", buffer.String())
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	defer resultsIterator.Close()
 
-	return shim.Success(buffer.Bytes())
+	queryResults, err := constructPaginatedQueryResponseFromIterator(resultsIterator, responseMetadata)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	fmt.Printf("- getMarblesByRangeWithPagination queryResult:\n%s\n", string(queryResults))
+
+	return shim.Success(queryResults)
 }
 
 // ==== Example: GetStateByPartialCompositeKey/RangeQuery =========================================
-// transferMarblesBasedOnColor will transfer marbles of a given color to a certain new owner.
+// transferMarblesBasedOnColor will transfer the invoker's active marbles of a given color to a
+// certain new owner. Marbles of that color owned by someone else, or that are locked, in escrow
+// or retired, are left untouched.
 // Uses a GetStateByPartialCompositeKey (range query) against color~name 'index'.
 // Committing peers will re-execute range queries to guarantee that result sets are stable
 // between endorsement time and commit time. The transaction is invalidated by the
 // committing peers if the result set has changed between endorsement time and commit time.
 // Therefore, range queries are a safe option for performing update transactions based on query results.
 // ===========================================================================================
-func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0       1
-	// "color", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
-	color := args[0]
-	newOwner := strings.ToLower(args[1])
+	//   color    newOwner
+	// "color", "Org2MSP::<id>"
+	color := args.String("color")
+	newOwner := args.String("newOwner")
 	fmt.Println("- start transferMarblesBasedOnColor ", color, newOwner)
 
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err).Response()
+	}
+
 	// Query the color~name index by color
 	// This will execute a key range query on all keys starting with 'color'
-	coloredMarbleResultsIterator, err := stub.GetStateByPartialCompositeKey("color~name", []string{color})
+	coloredMarbleResultsIterator, err := stub.GetStateByPartialCompositeKey(colorNameIndex, []string{color})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer coloredMarbleResultsIterator.Close()
 
-	// Iterate through result set and for each marble found, transfer to newOwner
+	// Iterate through result set and for each marble owned by the invoker, transfer to newOwner
 	var i int
-	for i = 0; coloredMarbleResultsIterator.HasNext(); i++ {
+	for coloredMarbleResultsIterator.HasNext() {
 		// Note that we don't get the value (2nd return variable), we'll just get the marble name from the composite key
 		responseRange, err := coloredMarbleResultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 
 		// get the color and name from color~name composite key
 		objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 		returnedColor := compositeKeyParts[0]
 		returnedMarbleName := compositeKeyParts[1]
 		fmt.Printf("- found a marble from index:%s color:%s name:%s\n", objectType, returnedColor, returnedMarbleName)
 
+		// Skip marbles that belong to someone else or can't be transferred
+		marbleAsBytes, err := stub.GetState(returnedMarbleName)
+		if err != nil {
+			return errcode.Internal("Failed to get marble:%s", err).Response()
+		}
+		foundMarble := marble{}
+		err = json.Unmarshal(marbleAsBytes, &foundMarble)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		if foundMarble.Owner != invoker || statusOf(&foundMarble) != marbleActive {
+			continue
+		}
+
 		// Now call the transfer function for the found marble.
 		// Re-use the same function that is used to transfer individual marbles
-		response := t.transferMarble(stub, []string{returnedMarbleName, newOwner})
+		err = transferMarbleTo(stub, returnedMarbleName, newOwner)
 		// if the transfer failed break out of loop and return error
-		if response.Status != shim.OK {
-			return shim.Error("Transfer failed: " + response.Message)
+		if err != nil {
+			return errcode.Wrap(err, "Transfer failed").Response()
 		}
+		i++
 	}
 
 	responsePayload := fmt.Sprintf("Transferred %d %s marbles to %s", i, color, newOwner)
@@ -492,26 +758,27 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 // ============================================================================================
 
 // ===== Example: Parameterized rich query =================================================
-// queryMarblesByOwner queries for marbles based on a passed in owner.
+// queryMarblesByOwner queries for marbles based on a passed in owner ID.
 // This is an example of a parameterized query where the query logic is baked into the chaincode,
 // and accepting a single query parameter (owner).
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	//   0
-	// "bob"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
+func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	owner := strings.ToLower(args[0])
+	//   owner
+	// "Org1MSP::<id>"
+	owner := args.String("owner")
 
-	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"marble\",\"owner\":\"%s\"}}", owner)
+	queryString, err := buildQueryString(marbleQuery{
+		Filters: []queryFilter{{Field: "owner", Op: "$eq", Value: owner}},
+	})
+	if err != nil {
+		return errcode.Response(err)
+	}
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	return shim.Success(queryResults)
 }
@@ -520,22 +787,23 @@ func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface,
 // queryMarbles uses a query string to perform a query for marbles.
 // Query string matching state database syntax is passed in and executed as is.
 // Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the queryMarblesForOwner example for parameterized queries.
+// If this is not desired, follow the queryMarblesForOwner example for parameterized queries,
+// use queryMarblesByFilter, or disable raw queries on the channel (see marbles_query.go).
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) queryMarbles(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0
-	// "queryString"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	//   queryString
+	err := checkRawQueriesAllowed(stub)
+	if err != nil {
+		return errcode.Response(err)
 	}
 
-	queryString := args[0]
+	queryString := string(args.JSON("queryString"))
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	return shim.Success(queryResults)
 }
@@ -554,51 +822,188 @@ func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, queryString
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing QueryRecords
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	queryResults, err := constructQueryResponseFromIterator(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
 
-	bArrayMemberAlreadyWritten := false
+	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", string(queryResults))
+
+	return queryResults, nil
+}
+
+// ===== Example: Parameterized rich query with pagination ===================================
+// queryMarblesByOwnerWithPagination queries for a page of marbles owned by the passed in owner.
+// The bookmark returned in the response metadata is passed back in to fetch the next page.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (t *SimpleChaincode) queryMarblesByOwnerWithPagination(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   owner            pageSize  bookmark
+	// "Org1MSP::<id>", "10",     "bookmark"
+	owner := args.String("owner")
+	pageSize, err := pageSizeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	bookmark := args.String("bookmark")
+
+	queryString, err := buildQueryString(marbleQuery{
+		Filters: []queryFilter{{Field: "owner", Op: "$eq", Value: owner}},
+	})
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ===== Example: Ad hoc rich query with pagination ========================================
+// queryMarblesWithPagination uses a query string, page size and bookmark to perform a query
+// for a page of marbles. The query string is passed in and executed as is, as in queryMarbles.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (t *SimpleChaincode) queryMarblesWithPagination(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   queryString    pageSize  bookmark
+	// "queryString", "10",     "bookmark"
+	err := checkRawQueriesAllowed(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	queryString := string(args.JSON("queryString"))
+	pageSize, err := pageSizeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	bookmark := args.String("bookmark")
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// =========================================================================================
+// getQueryResultForQueryStringWithPagination executes the passed in query string with
+// pagination info. Result set is built and returned as a byte array containing the JSON
+// results and the response metadata.
+// =========================================================================================
+func getQueryResultForQueryStringWithPagination(stub shim.ChaincodeStubInterface, queryString string, pageSize int32, bookmark string) ([]byte, error) {
+
+	fmt.Printf("- getQueryResultForQueryStringWithPagination queryString:\n%s\n", queryString)
+
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(queryString, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	queryResults, err := constructPaginatedQueryResponseFromIterator(resultsIterator, responseMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("- getQueryResultForQueryStringWithPagination queryResult:\n%s\n", string(queryResults))
+
+	return queryResults, nil
+}
+
+// queryResult is a single key/record pair as returned by the range and rich queries
+type queryResult struct {
+	Key    string          `json:"Key"`
+	Record json.RawMessage `json:"Record"`
+}
+
+// paginatedQueryResponse wraps a page of query results with the metadata needed
+// to request the next page
+type paginatedQueryResponse struct {
+	Records          []queryResult             `json:"Records"`
+	ResponseMetadata paginatedResponseMetadata `json:"ResponseMetadata"`
+}
+
+type paginatedResponseMetadata struct {
+	RecordsCount int32  `json:"RecordsCount"`
+	Bookmark     string `json:"Bookmark"`
+}
+
+// ===========================================================================================
+// collectQueryResults reads every remaining entry from the iterator into a slice of
+// queryResults. Marble records are JSON and are embedded as-is; any other value is
+// embedded as a JSON string so that the response is always well formed.
+// ===========================================================================================
+func collectQueryResults(resultsIterator shim.StateQueryIteratorInterface) ([]queryResult, error) {
+	results := []queryResult{}
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		record := json.RawMessage(queryResponse.Value)
+		if !json.Valid(queryResponse.Value) {
+			record, err = json.Marshal(string(queryResponse.Value))
+			if err != nil {
+				return nil, err
+			}
 		}
-		buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResponse.Key)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(queryResponse.Value))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		results = append(results, queryResult{Key: queryResponse.Key, Record: record})
 	}
-	buffer.WriteString("]")
-
-	fmt.Printf("- getQueryResultForQueryString queryResult:\n%s\n", buffer.String())
+	return results, nil
+}
 
-	return buffer.Bytes(), nil
+// ===========================================================================================
+// constructQueryResponseFromIterator constructs a JSON array containing query results from
+// a given result iterator
+// ===========================================================================================
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) ([]byte, error) {
+	results, err := collectQueryResults(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(results)
 }
 
-func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+// ===========================================================================================
+// constructPaginatedQueryResponseFromIterator constructs a JSON object containing a page of
+// query results along with the fetched record count and the bookmark for the next page
+// ===========================================================================================
+func constructPaginatedQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, responseMetadata *pb.QueryResponseMetadata) ([]byte, error) {
+	results, err := collectQueryResults(resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+	response := paginatedQueryResponse{Records: results}
+	if responseMetadata != nil {
+		response.ResponseMetadata.RecordsCount = responseMetadata.FetchedRecordsCount
+		response.ResponseMetadata.Bookmark = responseMetadata.Bookmark
+	}
+	return json.Marshal(response)
+}
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+// pageSizeOf returns the pageSize argument as the int32 expected by the paginated query APIs
+func pageSizeOf(args *router.Args) (int32, error) {
+	pageSize := args.Int("pageSize")
+	if pageSize <= 0 || pageSize > math.MaxInt32 {
+		return 0, errcode.InvalidArgument("page size must be a positive 32-bit integer, got %d", pageSize)
 	}
+	return int32(pageSize), nil
+}
+
+func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	marbleName := args[0]
+	marbleName := args.String("name")
 
 	fmt.Printf("- start getHistoryForMarble: %s\n", marbleName)
 
 	resultsIterator, err := stub.GetHistoryForKey(marbleName)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer resultsIterator.Close()
 
@@ -610,7 +1015,7 @@ func (t *SimpleChaincode) getHistoryForMarble(stub shim.ChaincodeStubInterface,
 	for resultsIterator.HasNext() {
 		response, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
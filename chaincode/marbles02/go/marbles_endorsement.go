@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Key-level endorsement =================================================================
+// Every marble key carries its own endorsement policy requiring a peer of the owner's org, so
+// a marble of Org1MSP can only be changed by transactions that an Org1MSP peer endorsed, even
+// if the chaincode-wide policy would accept any org. The policy is set when a marble is
+// created, restored or imported and whenever it changes owner. The keys holding the
+// approvals and operators an owner set get the same policy, so that another org cannot
+// approve itself for the owner's marbles. A transaction is validated
+// against the policy the key had before it, so a transfer needs the endorsement of the
+// current owner's org and the new policy applies from the next transaction on.
+//
+// Key-level endorsement policies need Fabric 1.3 or later with the V1_3 application
+// capability enabled on the channel.
+//
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["getMarbleEndorsementPolicy","marble1"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// marbleEndorsementPolicy is the key-level endorsement policy of a marble. Policy holds the
+// serialized SignaturePolicyEnvelope and is empty if the marble has none, in which case the
+// chaincode-wide policy applies.
+type marbleEndorsementPolicy struct {
+	Marble string   `json:"marble"`
+	Orgs   []string `json:"orgs"`
+	Policy []byte   `json:"policy,omitempty"`
+}
+
+// setMarbleEndorsementPolicy requires a peer of the owner's org to endorse changes to the
+// marble
+func setMarbleEndorsementPolicy(stub shim.ChaincodeStubInterface, m *marble) error {
+	return setOwnerEndorsementPolicy(stub, m.Name, m.Owner)
+}
+
+// setOwnerEndorsementPolicy requires a peer of the owner's org to endorse changes to a key
+// that belongs to the owner, such as a marble or the approvals the owner gave
+func setOwnerEndorsementPolicy(stub shim.ChaincodeStubInterface, key, owner string) error {
+	mspID, _, err := splitOwnerID(owner)
+	if err != nil {
+		return err
+	}
+	ep, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return err
+	}
+	err = ep.AddOrgs(statebased.RoleTypePeer, mspID)
+	if err != nil {
+		return errcode.Internal("Failed to build the endorsement policy of %s: %s", key, err)
+	}
+	policy, err := ep.Policy()
+	if err != nil {
+		return errcode.Internal("Failed to build the endorsement policy of %s: %s", key, err)
+	}
+	err = stub.SetStateValidationParameter(key, policy)
+	if err != nil {
+		return errcode.Internal("Failed to set the endorsement policy of %s: %s", key, err)
+	}
+	return nil
+}
+
+// ===========================================================
+// getMarbleEndorsementPolicy returns the orgs whose peers must
+// endorse changes to a marble
+// ===========================================================
+func (t *SimpleChaincode) getMarbleEndorsementPolicy(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	marbleName := args.String("name")
+
+	_, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	policy, err := stub.GetStateValidationParameter(marbleName)
+	if err != nil {
+		return errcode.Internal("Failed to get the endorsement policy of %s: %s", marbleName, err).Response()
+	}
+	result := marbleEndorsementPolicy{Marble: marbleName, Orgs: []string{}, Policy: policy}
+	if len(policy) > 0 {
+		ep, err := statebased.NewStateEP(policy)
+		if err != nil {
+			return errcode.Internal("Failed to parse the endorsement policy of %s: %s", marbleName, err).Response()
+		}
+		result.Orgs = ep.ListOrgs()
+	}
+
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(resultJSONasBytes)
+}
@@ -0,0 +1,101 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Events ================================================================================
+// Fabric keeps a single chaincode event per transaction: every call to SetEvent replaces the
+// previous one. So that a batch, e.g. initMarblesBatch creating several marbles, reports every
+// marble, Invoke collects the events set by the handlers and emits them together once the
+// transaction succeeded, as one event named after them whose payload is the JSON array of
+// their payloads, in the order they were set:
+//   Mint  [{"marble":"marble5","owner":"Org1MSP::<id>"},{"marble":"marble6","owner":"Org1MSP::<id>"}]
+// A transaction setting events of different names emits a single MarbleEvents event instead,
+// holding the name and payload of each:
+//   MarbleEvents  [{"name":"Burn","payload":{...}},{"name":"Mint","payload":{...}}]
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// mixedEventName is the name of the event of a transaction setting events of different names
+const mixedEventName = "MarbleEvents"
+
+// namedEvent is an entry of the payload of a MarbleEvents event
+type namedEvent struct {
+	Name    string          `json:"name"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// eventCollector is passed to the handlers in place of the stub and holds back the events
+// they set until flush
+type eventCollector struct {
+	shim.ChaincodeStubInterface
+	events []namedEvent
+}
+
+// newEventCollector wraps the stub of a transaction
+func newEventCollector(stub shim.ChaincodeStubInterface) *eventCollector {
+	return &eventCollector{ChaincodeStubInterface: stub}
+}
+
+// SetEvent records an event, whose payload must be JSON, to be emitted by flush
+func (c *eventCollector) SetEvent(name string, payload []byte) error {
+	c.events = append(c.events, namedEvent{Name: name, Payload: json.RawMessage(payload)})
+	return nil
+}
+
+// flush sets the event of the transaction from the events recorded so far
+func (c *eventCollector) flush() error {
+	if len(c.events) == 0 {
+		return nil
+	}
+	name := c.events[0].Name
+	payloads := []json.RawMessage{}
+	for _, event := range c.events {
+		if event.Name != name {
+			name = mixedEventName
+		}
+		payloads = append(payloads, event.Payload)
+	}
+
+	var eventJSONasBytes []byte
+	var err error
+	if name == mixedEventName {
+		eventJSONasBytes, err = json.Marshal(c.events)
+	} else {
+		eventJSONasBytes, err = json.Marshal(payloads)
+	}
+	if err != nil {
+		return err
+	}
+	return c.ChaincodeStubInterface.SetEvent(name, eventJSONasBytes)
+}
+
+// setEvent marshals the event payload and sets it as an event of the transaction
+func setEvent(stub shim.ChaincodeStubInterface, eventName string, payload interface{}) error {
+	payloadJSONasBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(eventName, payloadJSONasBytes)
+}
@@ -0,0 +1,302 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== State export and import ===============================================================
+// exportState and importState move marbles between channels, or seed a test network, using
+// newline-delimited JSON (NDJSON). Every line is one record:
+//   {"key":"marble1","docType":"marble","value":{"docType":"marble","name":"marble1",...}}
+// where value is the marble document exactly as it is stored in state.
+//
+// exportState(startKey, endKey, pageSize, bookmark) exports one page of marbles in key order
+// and returns {"Records":"<ndjson>","ResponseMetadata":{"RecordsCount":n,"Bookmark":"..."}}.
+// The Records string can be passed to importState as is. Only marbles are exported: index
+// entries are regenerated on import, and offers, archived marbles and the chaincode
+// configuration stay on the source channel.
+//
+// importState(records, force) writes every record and its index entries in a single
+// transaction. The import fails if a record would overwrite an existing marble, unless force is
+// "true", in which case the existing marble and its index entries are replaced, along with its
+// approval if the owner changes. Escrowed marbles, and marbles locked by an open offer, are
+// never overwritten. Imported marbles keep their owners, so importing is limited to admins of
+// the MSPs listed in importMspIds in the chaincode configuration (see marbles_query.go);
+// importing is disabled when none are listed.
+//
+// Offers stay on the source channel, so a marble locked by an offer is imported active. An
+// escrow cannot be moved without its holder, so escrowed marbles are refused: release them on
+// the source channel first.
+//
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["exportState","","","50",""]}'
+//   peer chaincode invoke -C myc2 -n marbles -c '{"Args":["importState","{\"key\":\"marble1\",\"docType\":\"marble\",\"value\":{...}}\n...","false"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// stateRecord is a single line of an export
+type stateRecord struct {
+	Key     string          `json:"key"`
+	DocType string          `json:"docType"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// exportResponse is a page of exported records with the metadata needed to request the
+// next page
+type exportResponse struct {
+	Records          string                    `json:"Records"`
+	ResponseMetadata paginatedResponseMetadata `json:"ResponseMetadata"`
+}
+
+// ===========================================================================================
+// exportState exports a page of marbles in the key range [startKey, endKey) as NDJSON
+// ===========================================================================================
+func (t *SimpleChaincode) exportState(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   startKey   endKey     pageSize  bookmark
+	// "marble1", "marble9", "50",     "bookmark"
+	startKey := args.String("startKey")
+	endKey := args.String("endKey")
+	pageSize, err := pageSizeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	bookmark := args.String("bookmark")
+
+	resultsIterator, responseMetadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	defer resultsIterator.Close()
+
+	var buffer bytes.Buffer
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errcode.Response(err)
+		}
+
+		var doc struct {
+			ObjectType string `json:"docType"`
+		}
+		err = json.Unmarshal(queryResponse.Value, &doc)
+		if err != nil || doc.ObjectType != "marble" {
+			continue
+		}
+
+		recordJSONasBytes, err := json.Marshal(stateRecord{Key: queryResponse.Key, DocType: doc.ObjectType, Value: queryResponse.Value})
+		if err != nil {
+			return errcode.Response(err)
+		}
+		buffer.Write(recordJSONasBytes)
+		buffer.WriteString("\n")
+	}
+
+	response := exportResponse{Records: buffer.String()}
+	if responseMetadata != nil {
+		response.ResponseMetadata.RecordsCount = responseMetadata.FetchedRecordsCount
+		response.ResponseMetadata.Bookmark = responseMetadata.Bookmark
+	}
+	responseJSONasBytes, err := json.Marshal(response)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(responseJSONasBytes)
+}
+
+// ===========================================================================================
+// importState writes the marbles of an export and regenerates their index entries
+// ===========================================================================================
+func (t *SimpleChaincode) importState(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   records    force (optional)
+	// "records", "true"
+	force := args.Bool("force")
+
+	err := checkImportAllowed(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	marbles, err := parseStateRecords(args.String("records"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	for _, m := range marbles {
+		marbleAsBytes, err := stub.GetState(m.Name)
+		if err != nil {
+			return errcode.Internal("Failed to get marble: %s", err).Response()
+		}
+		if marbleAsBytes != nil {
+			if !force {
+				return errcode.AlreadyExists("Marble %s already exists, import with force to overwrite it", m.Name).Response()
+			}
+			existing := &marble{}
+			err = json.Unmarshal(marbleAsBytes, existing)
+			if err != nil {
+				return errcode.Internal("Failed to decode JSON of: %s", m.Name).Response()
+			}
+			if statusOf(existing) == marbleEscrowed || strings.HasPrefix(existing.LockedBy, offerLockPrefix) {
+				return errcode.Conflict("Marble %s is held by an escrow or an open offer and cannot be overwritten", m.Name).Response()
+			}
+			err = delMarbleIndexes(stub, existing)
+			if err != nil {
+				return errcode.Response(err)
+			}
+			// an approval given by the previous owner does not carry over
+			if existing.Owner != m.Owner {
+				err = clearApproval(stub, m.Name)
+				if err != nil {
+					return errcode.Response(err)
+				}
+			}
+		}
+
+		err = putMarble(stub, m)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		err = setMarbleEndorsementPolicy(stub, m)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		err = putMarbleIndexes(stub, m)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	fmt.Printf("- imported %d marbles\n", len(marbles))
+	return shim.Success([]byte(fmt.Sprintf("{\"imported\":%d}", len(marbles))))
+}
+
+// parseStateRecords decodes and validates the NDJSON records of an import. Blank lines are
+// ignored.
+func parseStateRecords(records string) ([]*marble, error) {
+	var marbles []*marble
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(records, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var record stateRecord
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			return nil, errcode.InvalidArgument("Line %d: failed to decode record: %s", i+1, err)
+		}
+		if record.DocType != "marble" {
+			return nil, errcode.InvalidArgument("Line %d: docType must be 'marble', got '%s'", i+1, record.DocType)
+		}
+
+		m := &marble{}
+		err = json.Unmarshal(record.Value, m)
+		if err != nil {
+			return nil, errcode.InvalidArgument("Line %d: failed to decode marble: %s", i+1, err)
+		}
+		if m.ObjectType != "marble" || m.Name != record.Key || len(m.Name) == 0 {
+			return nil, errcode.InvalidArgument("Line %d: value is not a marble named '%s'", i+1, record.Key)
+		}
+		if m.Size < 0 {
+			return nil, errcode.InvalidArgument("Line %d: size must not be negative", i+1)
+		}
+		if _, ok := marbleTransitions[statusOf(m)]; !ok {
+			return nil, errcode.InvalidArgument("Line %d: unknown status '%s'", i+1, m.Status)
+		}
+		err = importLifecycle(m)
+		if err != nil {
+			return nil, errcode.Wrap(err, "Line %d", i+1)
+		}
+		err = validateOwnerID(m.Owner)
+		if err != nil {
+			return nil, errcode.Wrap(err, "Line %d", i+1)
+		}
+
+		// reads within a transaction do not see its own writes, see marbles_batch.go
+		if seen[m.Name] {
+			return nil, errcode.InvalidArgument("Line %d: marble %s appears more than once", i+1, m.Name)
+		}
+		seen[m.Name] = true
+		marbles = append(marbles, m)
+	}
+	if len(marbles) == 0 {
+		return nil, errcode.InvalidArgument("No records to import")
+	}
+	return marbles, nil
+}
+
+// importLifecycle checks the lifecycle fields of an imported marble. A lock held by an offer is
+// released, as the offer is not imported; escrowed marbles are refused.
+func importLifecycle(m *marble) error {
+	if statusOf(m) == marbleEscrowed || m.Escrow != nil {
+		return errcode.InvalidArgument("Marble %s is escrowed, release the escrow before exporting it", m.Name)
+	}
+	switch {
+	case statusOf(m) != marbleLocked:
+		if len(m.LockedBy) > 0 {
+			return errcode.InvalidArgument("Marble %s is %s but locked by '%s'", m.Name, statusOf(m), m.LockedBy)
+		}
+	case strings.HasPrefix(m.LockedBy, offerLockPrefix):
+		m.Status = marbleActive
+		m.LockedBy = ""
+	case m.LockedBy != lockedByOwner:
+		return errcode.InvalidArgument("Marble %s is locked by '%s'", m.Name, m.LockedBy)
+	}
+	return nil
+}
+
+// checkImportAllowed returns an error unless the invoker is an admin of one of the MSPs
+// allowed to import state
+func checkImportAllowed(stub shim.ChaincodeStubInterface) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return errcode.Internal("Failed to read chaincode configuration: %s", err)
+	}
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, importMSPID := range config.ImportMSPIDs {
+		if importMSPID == mspID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return errcode.Unauthorized("Clients of %s are not allowed to import state", mspID)
+	}
+	err = identities.AssertRole(stub, identity.RoleAdmin)
+	if err != nil {
+		return errcode.Wrap(err, "Only admins may import state")
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Composite key indexes =================================================================
+// Every marble is indexed under three composite keys:
+//   color~name  - used by transferMarblesBasedOnColor
+//   owner~name  - used by getMarblesByOwner
+//   size~name   - used by getMarblesBySizeRange
+// Unlike rich queries these indexes are plain state entries, so the index-backed queries work
+// on both LevelDB and CouchDB peers. The indexes are written by initMarble, moved by
+// changeMarbleOwner and removed by delete.
+//
+// Sizes are zero-padded to a fixed width and stored one digit per key attribute, so that the
+// lexical order of the size~name keys matches the numeric order of the sizes and a size range
+// splits into a few blocks of sizes sharing their leading digits, e.g. [100, 312] into 100-299,
+// 300-309, 310, 311 and 312. Each block is read with one partial key query, so getMarblesBySizeRange
+// never reads index entries outside the range.
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	colorNameIndex = "color~name"
+	ownerNameIndex = "owner~name"
+	sizeNameIndex  = "size~name"
+)
+
+// sizeKeyWidth is wide enough to hold any non-negative int64
+const sizeKeyWidth = 19
+
+// encodeSize returns the sortable, zero-padded form of a marble size
+func encodeSize(size int) string {
+	return fmt.Sprintf("%0*d", sizeKeyWidth, size)
+}
+
+// sizeAttributes returns the digits of the zero-padded size, one per attribute
+func sizeAttributes(size int) []string {
+	return strings.Split(encodeSize(size), "")
+}
+
+// putIndexEntry saves an index entry to state. Only the key name is needed, no need to store
+// a duplicate copy of the marble.
+// Note - passing a 'nil' value will effectively delete the key from state, therefore we pass
+// null character as value
+func putIndexEntry(stub shim.ChaincodeStubInterface, indexName string, attributes []string, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(indexName, append(attributes, marbleName))
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+// delIndexEntry removes an index entry from state
+func delIndexEntry(stub shim.ChaincodeStubInterface, indexName string, attributes []string, marbleName string) error {
+	indexKey, err := stub.CreateCompositeKey(indexName, append(attributes, marbleName))
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+// putMarbleIndexes writes all index entries for a marble
+func putMarbleIndexes(stub shim.ChaincodeStubInterface, m *marble) error {
+	err := putIndexEntry(stub, colorNameIndex, []string{m.Color}, m.Name)
+	if err != nil {
+		return err
+	}
+	err = putIndexEntry(stub, ownerNameIndex, []string{m.Owner}, m.Name)
+	if err != nil {
+		return err
+	}
+	return putIndexEntry(stub, sizeNameIndex, sizeAttributes(m.Size), m.Name)
+}
+
+// delMarbleIndexes removes all index entries for a marble
+func delMarbleIndexes(stub shim.ChaincodeStubInterface, m *marble) error {
+	err := delIndexEntry(stub, colorNameIndex, []string{m.Color}, m.Name)
+	if err != nil {
+		return err
+	}
+	err = delIndexEntry(stub, ownerNameIndex, []string{m.Owner}, m.Name)
+	if err != nil {
+		return err
+	}
+	return delIndexEntry(stub, sizeNameIndex, sizeAttributes(m.Size), m.Name)
+}
+
+// ===========================================================================================
+// getMarblesByOwner returns all marbles of an owner using the owner~name index.
+// Works on any state database.
+// ===========================================================================================
+func (t *SimpleChaincode) getMarblesByOwner(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   owner
+	// "Org1MSP::<id>"
+	owner := args.String("owner")
+
+	ownedMarbleResultsIterator, err := stub.GetStateByPartialCompositeKey(ownerNameIndex, []string{owner})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	defer ownedMarbleResultsIterator.Close()
+
+	var results []queryResult
+	for ownedMarbleResultsIterator.HasNext() {
+		responseRange, err := ownedMarbleResultsIterator.Next()
+		if err != nil {
+			return errcode.Response(err)
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		results, err = appendIndexedMarble(stub, results, compositeKeyParts[1])
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	return marshalQueryResults(results)
+}
+
+// ===========================================================================================
+// getMarblesBySizeRange returns the marbles whose size lies in [minSize, maxSize], in
+// ascending size order, using the size~name index. Works on any state database.
+// Only the index entries of sizes within the range are read, see sizeRangePrefixes.
+// ===========================================================================================
+func (t *SimpleChaincode) getMarblesBySizeRange(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   minSize  maxSize
+	// "10",    "50"
+	minSize := args.Int("minSize")
+	if minSize < 0 {
+		return errcode.InvalidArgument("minSize must not be negative").Response()
+	}
+	maxSize := args.Int("maxSize")
+	if maxSize < minSize {
+		return errcode.InvalidArgument("maxSize must not be less than minSize").Response()
+	}
+
+	var err error
+	var results []queryResult
+	for _, prefix := range sizeRangePrefixes(nil, encodeSize(minSize), encodeSize(maxSize)) {
+		results, err = appendSizeIndexedMarbles(stub, results, prefix)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	return marshalQueryResults(results)
+}
+
+// sizeRangePrefixes splits the range of zero-padded sizes [lo, hi], both of the same width,
+// into blocks of sizes sharing their leading digits. It returns the leading digits of each
+// block, after prefix, in ascending order. A block covers all sizes with its leading digits,
+// so the leading digits of the blocks of [095, 312] are 095 to 099, 1, 2, 30, and 310 to 312.
+func sizeRangePrefixes(prefix []string, lo, hi string) [][]string {
+	if strings.Trim(lo, "0") == "" && strings.Trim(hi, "9") == "" {
+		return [][]string{prefix}
+	}
+	// Copy the prefix so that the blocks don't share their backing arrays
+	with := func(digit byte) []string {
+		return append(append([]string{}, prefix...), string(digit))
+	}
+	if lo[0] == hi[0] {
+		return sizeRangePrefixes(with(lo[0]), lo[1:], hi[1:])
+	}
+	prefixes := sizeRangePrefixes(with(lo[0]), lo[1:], strings.Repeat("9", len(lo)-1))
+	for digit := lo[0] + 1; digit < hi[0]; digit++ {
+		prefixes = append(prefixes, with(digit))
+	}
+	return append(prefixes, sizeRangePrefixes(with(hi[0]), strings.Repeat("0", len(hi)-1), hi[1:])...)
+}
+
+// appendSizeIndexedMarbles appends the marbles of the size~name index entries whose size
+// starts with the given digits to results
+func appendSizeIndexedMarbles(stub shim.ChaincodeStubInterface, results []queryResult, digits []string) ([]queryResult, error) {
+	sizedMarbleResultsIterator, err := stub.GetStateByPartialCompositeKey(sizeNameIndex, digits)
+	if err != nil {
+		return nil, err
+	}
+	defer sizedMarbleResultsIterator.Close()
+
+	for sizedMarbleResultsIterator.HasNext() {
+		responseRange, err := sizedMarbleResultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+		results, err = appendIndexedMarble(stub, results, compositeKeyParts[len(compositeKeyParts)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// appendIndexedMarble reads the marble named by an index entry and appends it to results
+func appendIndexedMarble(stub shim.ChaincodeStubInterface, results []queryResult, marbleName string) ([]queryResult, error) {
+	marbleAsBytes, err := stub.GetState(marbleName)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get marble: %s", err)
+	} else if marbleAsBytes == nil {
+		return nil, errcode.NotFound("Index refers to a marble that does not exist: %s", marbleName)
+	}
+	return append(results, queryResult{Key: marbleName, Record: marbleAsBytes}), nil
+}
+
+// marshalQueryResults returns query results as a JSON array success response
+func marshalQueryResults(results []queryResult) pb.Response {
+	if results == nil {
+		results = []queryResult{}
+	}
+	queryResults, err := json.Marshal(results)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(queryResults)
+}
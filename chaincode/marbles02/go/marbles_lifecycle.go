@@ -0,0 +1,319 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Marble lifecycle ======================================================================
+// Every marble has a status:
+//   active   - the normal state; only active marbles can be transferred or offered
+//   locked   - frozen by its owner, or by an open offer while the trade is pending
+//   escrowed - held by an escrow agent until released or until the escrow expires
+//   retired  - permanently out of circulation, e.g. lost; a retired marble can only be deleted
+//
+// Allowed transitions:
+//   active   -> locked    lockMarble, offerMarble
+//   locked   -> active    unlockMarble, acceptOffer, rejectOffer, cancelOffer
+//   active   -> escrowed  escrowMarble
+//   escrowed -> active    releaseEscrow
+//   active   -> retired   retireMarble
+//
+// Marbles written before statuses were introduced have no status and are treated as active.
+//
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["lockMarble","marble1"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["unlockMarble","marble1"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["escrowMarble","marble1","Org3MSP::<id>","86400"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["releaseEscrow","marble1","Org2MSP::<id>"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["retireMarble","marble1"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// marble statuses
+const (
+	marbleActive   = "active"
+	marbleLocked   = "locked"
+	marbleEscrowed = "escrowed"
+	marbleRetired  = "retired"
+)
+
+// lockedByOwner is recorded on marbles locked with lockMarble; marbles locked by an offer
+// record offerLockPrefix followed by the offer ID
+const (
+	lockedByOwner   = "owner"
+	offerLockPrefix = "offer:"
+)
+
+// marbleTransitions lists the statuses a marble may move to from each status
+var marbleTransitions = map[string][]string{
+	marbleActive:   {marbleLocked, marbleEscrowed, marbleRetired},
+	marbleLocked:   {marbleActive},
+	marbleEscrowed: {marbleActive},
+	marbleRetired:  {},
+}
+
+// marbleEscrow records who holds an escrowed marble and until when
+type marbleEscrow struct {
+	Holder  string `json:"holder"`
+	Since   string `json:"since"`
+	Expires string `json:"expires"`
+}
+
+// statusOf returns the status of a marble, treating marbles without one as active
+func statusOf(m *marble) string {
+	if len(m.Status) == 0 {
+		return marbleActive
+	}
+	return m.Status
+}
+
+// assertMarbleActive returns an error unless the marble is active
+func assertMarbleActive(m *marble) error {
+	if status := statusOf(m); status != marbleActive {
+		return errcode.Conflict("Marble %s is %s", m.Name, status)
+	}
+	return nil
+}
+
+// setMarbleStatus moves a marble to a new status, checking the transition against
+// marbleTransitions. The marble is not written to state.
+func setMarbleStatus(m *marble, newStatus string) error {
+	current := statusOf(m)
+	for _, allowed := range marbleTransitions[current] {
+		if allowed == newStatus {
+			m.Status = newStatus
+			return nil
+		}
+	}
+	return errcode.Conflict("Marble %s cannot move from %s to %s", m.Name, current, newStatus)
+}
+
+// putMarble writes a marble to state
+func putMarble(stub shim.ChaincodeStubInterface, m *marble) error {
+	marbleJSONasBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(m.Name, marbleJSONasBytes)
+}
+
+// getTxTime returns the transaction timestamp set by the client
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// ===========================================================
+// lockMarble - the owner freezes an active marble
+// ===========================================================
+func (t *SimpleChaincode) lockMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	return changeOwnMarbleStatus(stub, args.String("name"), marbleLocked)
+}
+
+// ===========================================================
+// unlockMarble - the owner unfreezes a marble they locked.
+// Marbles locked by an open offer are unlocked by closing the offer.
+// ===========================================================
+func (t *SimpleChaincode) unlockMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	return changeOwnMarbleStatus(stub, args.String("name"), marbleActive)
+}
+
+// ===========================================================
+// retireMarble - the owner takes an active marble out of circulation
+// ===========================================================
+func (t *SimpleChaincode) retireMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	return changeOwnMarbleStatus(stub, args.String("name"), marbleRetired)
+}
+
+// changeOwnMarbleStatus implements the owner-driven status changes
+func changeOwnMarbleStatus(stub shim.ChaincodeStubInterface, marbleName string, newStatus string) pb.Response {
+	m, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertInvokerOwnsMarble(stub, m)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	if statusOf(m) == marbleLocked && m.LockedBy != lockedByOwner {
+		return errcode.Conflict("Marble %s is locked by %s", m.Name, m.LockedBy).Response()
+	}
+	if statusOf(m) == marbleEscrowed {
+		return errcode.Conflict("Marble %s is in escrow, use releaseEscrow", m.Name).Response()
+	}
+
+	err = setMarbleStatus(m, newStatus)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	m.LockedBy = ""
+	if newStatus == marbleLocked {
+		m.LockedBy = lockedByOwner
+	}
+
+	err = putMarble(stub, m)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- marble %s is now %s\n", m.Name, newStatus)
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// escrowMarble - the owner places an active marble in the custody
+// of an escrow holder for the given number of seconds
+// ===========================================================
+func (t *SimpleChaincode) escrowMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   name       holder           seconds
+	// "marble1", "Org3MSP::<id>", "86400"
+	m, err := getMarble(stub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertInvokerOwnsMarble(stub, m)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	holder := args.String("holder")
+	err = validateOwnerID(holder)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if holder == m.Owner {
+		return errcode.InvalidArgument("The owner cannot be the escrow holder").Response()
+	}
+
+	seconds := args.Int("seconds")
+	if seconds <= 0 {
+		return errcode.InvalidArgument("seconds must be a positive number").Response()
+	}
+
+	now, err := getTxTime(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = setMarbleStatus(m, marbleEscrowed)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	m.Escrow = &marbleEscrow{
+		Holder:  holder,
+		Since:   now.Format(time.RFC3339),
+		Expires: now.Add(time.Duration(seconds) * time.Second).Format(time.RFC3339),
+	}
+
+	err = putMarble(stub, m)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- marble %s escrowed with %s until %s\n", m.Name, holder, m.Escrow.Expires)
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// releaseEscrow - end the escrow of a marble. Until the escrow expires
+// the holder may release it either back to the owner or to a new owner
+// named in the optional recipient argument. Once it has expired the holder can
+// only return the marble to the owner, and the owner may reclaim it.
+// ===========================================================
+func (t *SimpleChaincode) releaseEscrow(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   name       recipient (optional)
+	// "marble1", "Org2MSP::<id>"
+	m, err := getMarble(stub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if statusOf(m) != marbleEscrowed || m.Escrow == nil {
+		return errcode.Conflict("Marble %s is not in escrow", m.Name).Response()
+	}
+
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err).Response()
+	}
+
+	recipient := m.Owner
+	if args.Has("recipient") {
+		recipient = args.String("recipient")
+		err = validateOwnerID(recipient)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	expires, err := time.Parse(time.RFC3339, m.Escrow.Expires)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	now, err := getTxTime(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	expired := !now.Before(expires)
+
+	switch invoker {
+	case m.Escrow.Holder:
+		// the holder decides where the marble goes until the escrow expires
+		if expired && recipient != m.Owner {
+			return errcode.Conflict("Escrow of marble %s expired at %s, the holder can only return it to the owner", m.Name, m.Escrow.Expires).Response()
+		}
+	case m.Owner:
+		if !expired {
+			return errcode.Conflict("Escrow of marble %s does not expire until %s", m.Name, m.Escrow.Expires).Response()
+		}
+		if recipient != m.Owner {
+			return errcode.Conflict("The owner can only reclaim an expired escrow").Response()
+		}
+	default:
+		return errcode.Unauthorized("Only the escrow holder or, after expiry, the owner can release the escrow").Response()
+	}
+
+	err = setMarbleStatus(m, marbleActive)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	m.Escrow = nil
+
+	if recipient != m.Owner {
+		err = changeMarbleOwner(stub, m, recipient)
+	} else {
+		err = putMarble(stub, m)
+	}
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- marble %s released from escrow to %s\n", m.Name, recipient)
+	return shim.Success(nil)
+}
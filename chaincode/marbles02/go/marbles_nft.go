@@ -0,0 +1,381 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Non-fungible token interface ==========================================================
+// Marbles are unique assets, so they are also exposed through an ERC-721 style interface. The
+// token ID is the marble name and owners are owner IDs as returned by whoAmI.
+//
+// Besides its owner, a marble may be transferred by:
+//   - the one identity the owner approved for that marble with approve. The approval is
+//     cleared whenever the marble changes owner or is deleted.
+//   - any operator the owner approved for all of its marbles with setApprovalForAll.
+// Both are checked by transferMarble, so they also apply to transferFrom and the batch
+// transfers. Everything else, e.g. locking, offering or deleting a marble, stays with the owner.
+//
+// tokenURI returns the configured tokenUriBase followed by the marble name, see
+// marbles_query.go, or a data URI holding the ERC-721 metadata JSON if no base is configured.
+//
+// initMarble and restore emit a Mint event and delete emits a Burn event, both carrying the
+// marble name and owner. approve and setApprovalForAll emit Approval and ApprovalForAll.
+// The batch functions emit one event for all of their marbles, see marbles_events.go.
+//
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["ownerOf","marble1"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["balanceOf","Org1MSP::<id>"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["approve","Org2MSP::<id>","marble1"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["getApproved","marble1"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["setApprovalForAll","Org3MSP::<id>","true"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["isApprovedForAll","Org1MSP::<id>","Org3MSP::<id>"]}'
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["transferFrom","Org1MSP::<id>","Org2MSP::<id>","marble1"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["tokenURI","marble1"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	approvalIndex = "approval"
+	operatorIndex = "operator"
+)
+
+// marbleEvent is the payload of the Mint and Burn events
+type marbleEvent struct {
+	Marble string `json:"marble"`
+	Owner  string `json:"owner"`
+}
+
+// approvalEvent is the payload of the Approval event. Approved is empty when the owner
+// cleared the approval.
+type approvalEvent struct {
+	Marble   string `json:"marble"`
+	Owner    string `json:"owner"`
+	Approved string `json:"approved"`
+}
+
+// operatorEvent is the payload of the ApprovalForAll event
+type operatorEvent struct {
+	Owner    string `json:"owner"`
+	Operator string `json:"operator"`
+	Approved bool   `json:"approved"`
+}
+
+// tokenMetadata is the ERC-721 metadata JSON of a marble
+type tokenMetadata struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Properties  map[string]string `json:"properties"`
+}
+
+// ===========================================================
+// ownerOf returns the owner ID of a marble
+// ===========================================================
+func (t *SimpleChaincode) ownerOf(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	m, err := getMarble(stub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(m.Owner))
+}
+
+// ===========================================================
+// balanceOf returns the number of marbles an owner holds,
+// counted with the owner~name index
+// ===========================================================
+func (t *SimpleChaincode) balanceOf(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	owner := args.String("owner")
+	err := validateOwnerID(owner)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	ownedMarbleResultsIterator, err := stub.GetStateByPartialCompositeKey(ownerNameIndex, []string{owner})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	defer ownedMarbleResultsIterator.Close()
+
+	count := 0
+	for ownedMarbleResultsIterator.HasNext() {
+		_, err := ownedMarbleResultsIterator.Next()
+		if err != nil {
+			return errcode.Response(err)
+		}
+		count++
+	}
+	return shim.Success([]byte(strconv.Itoa(count)))
+}
+
+// ===========================================================
+// approve lets one identity transfer a marble on behalf of its
+// owner, replacing any previous approval. An empty owner ID
+// clears the approval. The owner and its operators may approve.
+// ===========================================================
+func (t *SimpleChaincode) approve(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	//   approved         name
+	// "Org2MSP::<id>", "marble1"
+	approved, marbleName := args.String("approved"), args.String("name")
+
+	m, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err).Response()
+	}
+	if m.Owner != invoker {
+		operator, err := isOperator(stub, m.Owner, invoker)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		if !operator {
+			return errcode.Unauthorized("Marble %s is not owned by the invoker, nor is the invoker an operator of its owner", marbleName).Response()
+		}
+	}
+
+	approvalKey, err := stub.CreateCompositeKey(approvalIndex, []string{marbleName})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if len(approved) == 0 {
+		err = stub.DelState(approvalKey)
+	} else {
+		err = validateOwnerID(approved)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		if approved == m.Owner {
+			return errcode.InvalidArgument("The owner of marble %s cannot be approved for it", marbleName).Response()
+		}
+		err = stub.PutState(approvalKey, []byte(approved))
+		if err != nil {
+			return errcode.Response(err)
+		}
+		err = setOwnerEndorsementPolicy(stub, approvalKey, m.Owner)
+	}
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = setEvent(stub, "Approval", approvalEvent{Marble: marbleName, Owner: m.Owner, Approved: approved})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// getApproved returns the identity approved for a marble, or
+// an empty payload if there is none
+// ===========================================================
+func (t *SimpleChaincode) getApproved(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	marbleName := args.String("name")
+	_, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	approved, err := getApprovedFor(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(approved))
+}
+
+// ===========================================================
+// setApprovalForAll lets an operator transfer all marbles of
+// the invoker, or withdraws that permission
+// ===========================================================
+func (t *SimpleChaincode) setApprovalForAll(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	//   operator         approved
+	// "Org3MSP::<id>", "true"
+	operator := args.String("operator")
+	approved := args.Bool("approved")
+	err := validateOwnerID(operator)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	owner, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err).Response()
+	}
+	if operator == owner {
+		return errcode.InvalidArgument("The invoker cannot be its own operator").Response()
+	}
+
+	operatorKey, err := stub.CreateCompositeKey(operatorIndex, []string{owner, operator})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if approved {
+		err = stub.PutState(operatorKey, []byte{0x00})
+		if err != nil {
+			return errcode.Response(err)
+		}
+		err = setOwnerEndorsementPolicy(stub, operatorKey, owner)
+	} else {
+		err = stub.DelState(operatorKey)
+	}
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = setEvent(stub, "ApprovalForAll", operatorEvent{Owner: owner, Operator: operator, Approved: approved})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// isApprovedForAll returns whether an operator may transfer
+// all marbles of an owner
+// ===========================================================
+func (t *SimpleChaincode) isApprovedForAll(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	//   owner            operator
+	// "Org1MSP::<id>", "Org3MSP::<id>"
+	operator, err := isOperator(stub, args.String("owner"), args.String("operator"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(strconv.FormatBool(operator)))
+}
+
+// ===========================================================
+// transferFrom transfers a marble after checking that it is
+// owned by `from`. The transfer itself, including the check
+// that the invoker may make it, is done by transferMarbleTo.
+// ===========================================================
+func (t *SimpleChaincode) transferFrom(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	//   from             to               name
+	// "Org1MSP::<id>", "Org2MSP::<id>", "marble1"
+	from, to, marbleName := args.String("from"), args.String("to"), args.String("name")
+
+	m, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if m.Owner != from {
+		return errcode.Conflict("Marble %s is not owned by %s", marbleName, from).Response()
+	}
+	err = transferMarbleTo(stub, marbleName, to)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// ===========================================================
+// tokenURI returns the URI of the metadata of a marble
+// ===========================================================
+func (t *SimpleChaincode) tokenURI(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	m, err := getMarble(stub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return errcode.Internal("Failed to read chaincode configuration: %s", err).Response()
+	}
+	if len(config.TokenURIBase) > 0 {
+		return shim.Success([]byte(config.TokenURIBase + url.PathEscape(m.Name)))
+	}
+
+	metadataJSONasBytes, err := json.Marshal(tokenMetadata{
+		Name:        m.Name,
+		Description: fmt.Sprintf("A %s marble of size %d", m.Color, m.Size),
+		Properties:  map[string]string{"color": m.Color, "size": strconv.Itoa(m.Size)},
+	})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte("data:application/json;base64," + base64.StdEncoding.EncodeToString(metadataJSONasBytes)))
+}
+
+// assertInvokerMayTransfer returns an error unless the invoker is the owner of the marble,
+// is approved for it or is an operator of its owner
+func assertInvokerMayTransfer(stub shim.ChaincodeStubInterface, m *marble) error {
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err)
+	}
+	if m.Owner == invoker {
+		return nil
+	}
+	approved, err := getApprovedFor(stub, m.Name)
+	if err != nil {
+		return err
+	}
+	if approved == invoker {
+		return nil
+	}
+	operator, err := isOperator(stub, m.Owner, invoker)
+	if err != nil {
+		return err
+	}
+	if operator {
+		return nil
+	}
+	return errcode.Unauthorized("Marble %s is not owned by the invoker, nor approved for transfer by it", m.Name)
+}
+
+// getApprovedFor returns the identity approved for a marble, or "" if there is none
+func getApprovedFor(stub shim.ChaincodeStubInterface, marbleName string) (string, error) {
+	approvalKey, err := stub.CreateCompositeKey(approvalIndex, []string{marbleName})
+	if err != nil {
+		return "", err
+	}
+	approved, err := stub.GetState(approvalKey)
+	if err != nil {
+		return "", errcode.Internal("Failed to get approval of %s: %s", marbleName, err)
+	}
+	return string(approved), nil
+}
+
+// clearApproval removes the approval of a marble, if any
+func clearApproval(stub shim.ChaincodeStubInterface, marbleName string) error {
+	approvalKey, err := stub.CreateCompositeKey(approvalIndex, []string{marbleName})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(approvalKey)
+}
+
+// isOperator returns whether an operator may transfer all marbles of an owner
+func isOperator(stub shim.ChaincodeStubInterface, owner, operator string) (bool, error) {
+	operatorKey, err := stub.CreateCompositeKey(operatorIndex, []string{owner, operator})
+	if err != nil {
+		return false, err
+	}
+	value, err := stub.GetState(operatorKey)
+	if err != nil {
+		return false, errcode.Internal("Failed to get operators of %s: %s", owner, err)
+	}
+	return value != nil, nil
+}
@@ -0,0 +1,559 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Ownership and trading ==================================================================
+// A marble is owned by the client identity that created it. The owner ID combines the MSP ID
+// of the identity with the ID derived from its certificate by the client identity library:
+//   "<mspid>::<cid.GetID()>"
+// Only the owner may delete a marble. The owner may also approve others to transfer it, see
+// marbles_nft.go.
+//
+// Two owners can trade marbles without trusting a third party using offers:
+//   - offerMarble(marble, counterparty, requestedMarble, price) is called by the owner of
+//     `marble`. If requestedMarble is set the offer is a swap for the counterparty's marble,
+//     and if price is set the counterparty pays the offerer that many tokens. The offered
+//     marble is locked until the offer closes.
+//   - acceptOffer(offerID) is called by the counterparty and performs all sides of the trade
+//     in a single transaction, so neither party can end up with both marbles, or with both the
+//     marble and the payment.
+//   - rejectOffer(offerID) is called by the counterparty, cancelOffer(offerID) by the offerer.
+// The offer ID is the transaction ID of the offerMarble transaction and is returned in its
+// response payload and in the OfferCreated event, see marbles_events.go.
+//
+// Payments are made in the token chaincode (chaincode/token) instantiated on the same channel
+// under the name set as paymentChaincode in the chaincode configuration (see marbles_query.go);
+// offers with a price are refused when none is set. acceptOffer calls its transfer function as
+// the counterparty, whose token account has the same ID as their owner ID, so the payment and
+// the marble change hands in the same transaction or not at all.
+//
+// Marbles created before owner IDs were introduced have a free-text owner such as "tom", which
+// no identity matches, so nobody can transfer, trade or delete them. Admins of the MSPs allowed
+// to import state (see marbles_export.go) can hand each of them to its owner's identity with
+// migrateOwner, which also adds the index entries the marble is missing:
+//   peer chaincode invoke -C myc1 -n marbles -c '{"Args":["migrateOwner","marble1","Org1MSP::<id>"]}'
+// ============================================================================================
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// ownerIDSeparator joins the MSP ID and certificate-derived ID of an owner
+const ownerIDSeparator = "::"
+
+const offerIndex = "offer"
+
+// offer statuses
+const (
+	offerOpen      = "open"
+	offerAccepted  = "accepted"
+	offerRejected  = "rejected"
+	offerCancelled = "cancelled"
+)
+
+type marbleOffer struct {
+	ObjectType       string `json:"docType"` //docType is used to distinguish the various types of objects in state database
+	ID               string `json:"id"`
+	Marble           string `json:"marble"`
+	Offerer          string `json:"offerer"`
+	Counterparty     string `json:"counterparty"`
+	RequestedMarble  string `json:"requestedMarble,omitempty"`  //set for swaps
+	Price            int    `json:"price,omitempty"`            //tokens paid by the counterparty
+	PaymentChaincode string `json:"paymentChaincode,omitempty"` //set if there is a price
+	Status           string `json:"status"`
+}
+
+// getInvokerOwnerID returns the owner ID of the client identity that submitted the transaction
+func getInvokerOwnerID(stub shim.ChaincodeStubInterface) (string, error) {
+	id, err := cid.New(stub)
+	if err != nil {
+		return "", err
+	}
+	mspID, err := id.GetMSPID()
+	if err != nil {
+		return "", err
+	}
+	certID, err := id.GetID()
+	if err != nil {
+		return "", err
+	}
+	return mspID + ownerIDSeparator + certID, nil
+}
+
+// identities looks up the NodeOU role of clients. The MSPs of the samples use the default
+// NodeOU identifiers; add WithNodeOUs for MSPs configured otherwise.
+var identities = identity.New(identity.DefaultNodeOUs)
+
+// splitOwnerID returns the MSP ID and certificate-derived ID making up an owner ID
+func splitOwnerID(ownerID string) (string, string, error) {
+	parts := strings.SplitN(ownerID, ownerIDSeparator, 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", errcode.InvalidArgument("Owner ID must have the form <mspid>%s<id>, got '%s'", ownerIDSeparator, ownerID)
+	}
+	return parts[0], parts[1], nil
+}
+
+// validateOwnerID checks that ownerID is well formed
+func validateOwnerID(ownerID string) error {
+	_, _, err := splitOwnerID(ownerID)
+	return err
+}
+
+// assertInvokerOwnsMarble returns an error unless the invoker is the owner of the marble
+func assertInvokerOwnsMarble(stub shim.ChaincodeStubInterface, m *marble) error {
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err)
+	}
+	if m.Owner != invoker {
+		return errcode.Unauthorized("Marble %s is not owned by the invoker", m.Name)
+	}
+	return nil
+}
+
+// getMarble reads a marble from chaincode state
+func getMarble(stub shim.ChaincodeStubInterface, marbleName string) (*marble, error) {
+	marbleAsBytes, err := stub.GetState(marbleName)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get marble: %s", err)
+	} else if marbleAsBytes == nil {
+		return nil, errcode.NotFound("Marble does not exist: %s", marbleName)
+	}
+
+	m := &marble{}
+	err = json.Unmarshal(marbleAsBytes, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// changeMarbleOwner sets the new owner on the marble, rewrites it to state, hands its
+// endorsement policy to the new owner's org, clears its approval and moves its owner~name
+// index entry. Callers are responsible for checking that
+// the change is authorized.
+func changeMarbleOwner(stub shim.ChaincodeStubInterface, m *marble, newOwner string) error {
+	err := delIndexEntry(stub, ownerNameIndex, []string{m.Owner}, m.Name)
+	if err != nil {
+		return err
+	}
+
+	m.Owner = newOwner
+
+	err = putMarble(stub, m)
+	if err != nil {
+		return err
+	}
+	err = setMarbleEndorsementPolicy(stub, m)
+	if err != nil {
+		return err
+	}
+	err = clearApproval(stub, m.Name)
+	if err != nil {
+		return err
+	}
+	return putIndexEntry(stub, ownerNameIndex, []string{m.Owner}, m.Name)
+}
+
+// ===========================================================
+// whoAmI returns the owner ID of the invoker, which is the value
+// other owners pass to transferMarble and offerMarble
+// ===========================================================
+func (t *SimpleChaincode) whoAmI(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	ownerID, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err).Response()
+	}
+	return shim.Success([]byte(ownerID))
+}
+
+// ===========================================================
+// offerMarble - offer one of the invoker's marbles to another owner,
+// for one of their marbles, for a price, or both
+// ===========================================================
+func (t *SimpleChaincode) offerMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   marble     counterparty     requestedMarble  price
+	// "marble1", "Org2MSP::<id>", "marble4",       ""     (swap)
+	// "marble1", "Org2MSP::<id>", "",              "25"   (sale)
+	marbleName := args.String("marble")
+	counterparty := args.String("counterparty")
+	requestedMarbleName := args.String("requestedMarble")
+	fmt.Println("- start offerMarble ", marbleName, counterparty, requestedMarbleName, args.String("price"))
+
+	offered, err := getMarble(stub, marbleName)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertInvokerOwnsMarble(stub, offered)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertMarbleActive(offered)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = validateOwnerID(counterparty)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if counterparty == offered.Owner {
+		return errcode.InvalidArgument("Cannot make an offer to yourself").Response()
+	}
+
+	price := 0
+	if len(args.String("price")) > 0 {
+		price, err = strconv.Atoi(args.String("price"))
+		if err != nil || price < 0 {
+			return errcode.InvalidArgument("price must be empty or a non-negative numeric string").Response()
+		}
+	}
+	paymentChaincode := ""
+	if price > 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return errcode.Internal("Failed to read chaincode configuration: %s", err).Response()
+		}
+		if len(config.PaymentChaincode) == 0 {
+			return errcode.InvalidArgument("Offers with a price need a payment chaincode, none is configured").Response()
+		}
+		paymentChaincode = config.PaymentChaincode
+	}
+
+	if len(requestedMarbleName) > 0 {
+		if requestedMarbleName == marbleName {
+			return errcode.InvalidArgument("Cannot swap a marble for itself").Response()
+		}
+		requested, err := getMarble(stub, requestedMarbleName)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		if requested.Owner != counterparty {
+			return errcode.Conflict("Marble %s is not owned by the counterparty", requestedMarbleName).Response()
+		}
+	}
+
+	offer := &marbleOffer{
+		ObjectType:       "marbleOffer",
+		ID:               stub.GetTxID(),
+		Marble:           marbleName,
+		Offerer:          offered.Owner,
+		Counterparty:     counterparty,
+		RequestedMarble:  requestedMarbleName,
+		Price:            price,
+		PaymentChaincode: paymentChaincode,
+		Status:           offerOpen,
+	}
+	offerJSONasBytes, err := putOffer(stub, offer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	// Lock the offered marble so it can't be transferred while the offer is open
+	err = setMarbleStatus(offered, marbleLocked)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	offered.LockedBy = offerLockPrefix + offer.ID
+	err = putMarble(stub, offered)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = stub.SetEvent("OfferCreated", offerJSONasBytes)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	fmt.Println("- end offerMarble")
+	return shim.Success(offerJSONasBytes)
+}
+
+// ===========================================================
+// acceptOffer - the counterparty accepts an open offer. The offered
+// marble moves to the counterparty, for swaps the requested marble
+// moves to the offerer, and for sales the counterparty pays the price,
+// all in the same transaction.
+// ===========================================================
+func (t *SimpleChaincode) acceptOffer(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	offer, err := getOffer(stub, args.String("offerID"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertOfferParty(stub, offer, offer.Counterparty)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	// Both marbles must still be held by the parties named on the offer
+	offered, err := getMarble(stub, offer.Marble)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if offered.Owner != offer.Offerer {
+		return errcode.Conflict("Marble %s is no longer owned by the offerer", offer.Marble).Response()
+	}
+	if statusOf(offered) != marbleLocked || offered.LockedBy != offerLockPrefix+offer.ID {
+		return errcode.Conflict("Marble %s is no longer held for this offer", offer.Marble).Response()
+	}
+
+	if len(offer.RequestedMarble) > 0 {
+		requested, err := getMarble(stub, offer.RequestedMarble)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		if requested.Owner != offer.Counterparty {
+			return errcode.Conflict("Marble %s is no longer owned by the counterparty", offer.RequestedMarble).Response()
+		}
+		err = assertMarbleActive(requested)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		err = changeMarbleOwner(stub, requested, offer.Offerer)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	if offer.Price > 0 {
+		err = payOffer(stub, offer)
+		if err != nil {
+			return errcode.Response(err)
+		}
+	}
+
+	err = setMarbleStatus(offered, marbleActive)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	offered.LockedBy = ""
+	err = changeMarbleOwner(stub, offered, offer.Counterparty)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	return closeOffer(stub, offer, offerAccepted, "OfferAccepted")
+}
+
+// ===========================================================
+// rejectOffer - the counterparty turns down an open offer
+// ===========================================================
+func (t *SimpleChaincode) rejectOffer(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	offer, err := getOffer(stub, args.String("offerID"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertOfferParty(stub, offer, offer.Counterparty)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = releaseOfferLock(stub, offer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	return closeOffer(stub, offer, offerRejected, "OfferRejected")
+}
+
+// ===========================================================
+// cancelOffer - the offerer withdraws an open offer
+// ===========================================================
+func (t *SimpleChaincode) cancelOffer(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	offer, err := getOffer(stub, args.String("offerID"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = assertOfferParty(stub, offer, offer.Offerer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = releaseOfferLock(stub, offer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	return closeOffer(stub, offer, offerCancelled, "OfferCancelled")
+}
+
+// ===========================================================
+// readOffer - read an offer from chaincode state
+// ===========================================================
+func (t *SimpleChaincode) readOffer(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	offer, err := getOffer(stub, args.String("offerID"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	offerJSONasBytes, err := json.Marshal(offer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(offerJSONasBytes)
+}
+
+// payOffer transfers the price of an offer from the invoking counterparty to the offerer in
+// the payment chaincode. The payment is part of the transaction, so it is undone if the trade
+// fails, and the trade fails if the payment does.
+func payOffer(stub shim.ChaincodeStubInterface, offer *marbleOffer) error {
+	args := [][]byte{[]byte("transfer"), []byte(offer.Offerer), []byte(strconv.Itoa(offer.Price))}
+	response := stub.InvokeChaincode(offer.PaymentChaincode, args, "")
+	if response.Status != shim.OK {
+		return errcode.Conflict("Payment of %d to the offerer failed: %s", offer.Price, response.Message).
+			With("paymentChaincode", offer.PaymentChaincode)
+	}
+	return nil
+}
+
+// ===========================================================
+// migrateOwner - hand a marble with a free-text owner from before
+// owner IDs were introduced to an identity. Limited to admins of
+// the MSPs allowed to import state.
+// ===========================================================
+func (t *SimpleChaincode) migrateOwner(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   name       newOwner
+	// "marble1", "Org1MSP::<id>"
+	err := checkImportAllowed(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	m, err := getMarble(stub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if validateOwnerID(m.Owner) == nil {
+		return errcode.Conflict("Marble %s already has an owner ID", m.Name).Response()
+	}
+	newOwner := args.String("newOwner")
+	err = validateOwnerID(newOwner)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	legacyOwner := m.Owner
+	err = changeMarbleOwner(stub, m, newOwner)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putMarbleIndexes(stub, m)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- marble %s of %s migrated to %s\n", m.Name, legacyOwner, newOwner)
+	return shim.Success(nil)
+}
+
+// assertOfferParty checks that the offer is still open and that the invoker is the given party
+func assertOfferParty(stub shim.ChaincodeStubInterface, offer *marbleOffer, party string) error {
+	if offer.Status != offerOpen {
+		return errcode.Conflict("Offer %s is %s", offer.ID, offer.Status)
+	}
+	invoker, err := getInvokerOwnerID(stub)
+	if err != nil {
+		return errcode.Internal("Failed to get owner ID of the invoker: %s", err)
+	}
+	if invoker != party {
+		return errcode.Unauthorized("The invoker is not allowed to act on offer %s", offer.ID)
+	}
+	return nil
+}
+
+// releaseOfferLock unlocks the offered marble if it is still locked by the offer
+func releaseOfferLock(stub shim.ChaincodeStubInterface, offer *marbleOffer) error {
+	offered, err := getMarble(stub, offer.Marble)
+	if err != nil {
+		return err
+	}
+	if statusOf(offered) != marbleLocked || offered.LockedBy != offerLockPrefix+offer.ID {
+		return nil
+	}
+	err = setMarbleStatus(offered, marbleActive)
+	if err != nil {
+		return err
+	}
+	offered.LockedBy = ""
+	return putMarble(stub, offered)
+}
+
+// closeOffer moves an offer to its final status and emits the matching event
+func closeOffer(stub shim.ChaincodeStubInterface, offer *marbleOffer, status, eventName string) pb.Response {
+	offer.Status = status
+	offerJSONasBytes, err := putOffer(stub, offer)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = stub.SetEvent(eventName, offerJSONasBytes)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	fmt.Printf("- offer %s %s\n", offer.ID, status)
+	return shim.Success(offerJSONasBytes)
+}
+
+func getOffer(stub shim.ChaincodeStubInterface, offerID string) (*marbleOffer, error) {
+	offerKey, err := stub.CreateCompositeKey(offerIndex, []string{offerID})
+	if err != nil {
+		return nil, err
+	}
+	offerAsBytes, err := stub.GetState(offerKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get offer: %s", err)
+	} else if offerAsBytes == nil {
+		return nil, errcode.NotFound("Offer does not exist: %s", offerID)
+	}
+
+	offer := &marbleOffer{}
+	err = json.Unmarshal(offerAsBytes, offer)
+	if err != nil {
+		return nil, err
+	}
+	return offer, nil
+}
+
+func putOffer(stub shim.ChaincodeStubInterface, offer *marbleOffer) ([]byte, error) {
+	offerKey, err := stub.CreateCompositeKey(offerIndex, []string{offer.ID})
+	if err != nil {
+		return nil, err
+	}
+	offerJSONasBytes, err := json.Marshal(offer)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(offerKey, offerJSONasBytes)
+	if err != nil {
+		return nil, err
+	}
+	return offerJSONasBytes, nil
+}
@@ -0,0 +1,360 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// ==== Structured queries ====================================================================
+// A structured query lets clients describe a marble query as a list of typed filters instead
+// of handing a raw CouchDB selector to the chaincode. Every field and operator is checked
+// against an allow-list and the final selector is serialized with encoding/json, so client
+// input can never change the shape of the query.
+//
+// Example structured query:
+//   {"filters":[{"field":"owner","op":"$eq","value":"Org1MSP::<id>"},{"field":"size","op":"$gt","value":10}],
+//    "sort":[{"field":"size","order":"desc"}],
+//    "limit":10,
+//    "use_index":["_design/indexSizeSortDoc","indexSizeSortDesc"]}
+//
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByFilter","{\"filters\":[{\"field\":\"color\",\"op\":\"$eq\",\"value\":\"blue\"}]}"]}'
+//   peer chaincode query -C myc1 -n marbles -c '{"Args":["queryMarblesByFilterWithPagination","{\"filters\":[{\"field\":\"color\",\"op\":\"$in\",\"value\":[\"blue\",\"red\"]}]}","3",""]}'
+// ============================================================================================
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// marbleQuery is the client-facing description of a structured marble query
+type marbleQuery struct {
+	Filters  []queryFilter `json:"filters"`
+	Sort     []querySort   `json:"sort,omitempty"`
+	Limit    int           `json:"limit,omitempty"`
+	UseIndex []string      `json:"use_index,omitempty"`
+}
+
+// queryFilter restricts a single marble field using a CouchDB comparison operator
+type queryFilter struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+// querySort orders results by a single marble field, "asc" or "desc"
+type querySort struct {
+	Field string `json:"field"`
+	Order string `json:"order"`
+}
+
+// couchQuery is the CouchDB query document generated from a marbleQuery
+type couchQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []map[string]string    `json:"sort,omitempty"`
+	Limit    int                    `json:"limit,omitempty"`
+	UseIndex []string               `json:"use_index,omitempty"`
+}
+
+// field kinds accepted by structured queries
+const (
+	fieldKindString = "string"
+	fieldKindNumber = "number"
+)
+
+// queryableMarbleFields is the allow-list of marble fields that may appear in a structured
+// query, along with the JSON kind of their values
+var queryableMarbleFields = map[string]string{
+	"name":   fieldKindString,
+	"color":  fieldKindString,
+	"size":   fieldKindNumber,
+	"owner":  fieldKindString,
+	"status": fieldKindString,
+}
+
+// lowercasedMarbleFields are stored in lower case by initMarble, so filter values for them
+// are lowercased to match
+var lowercasedMarbleFields = map[string]bool{
+	"color": true,
+}
+
+// scalar and list comparison operators accepted by structured queries
+var scalarQueryOperators = map[string]bool{
+	"$eq":  true,
+	"$ne":  true,
+	"$gt":  true,
+	"$gte": true,
+	"$lt":  true,
+	"$lte": true,
+}
+
+var listQueryOperators = map[string]bool{
+	"$in":  true,
+	"$nin": true,
+}
+
+// ============================================================================================
+// queryMarblesByFilter runs a structured query (see the top of this file) against the state
+// database. Only available on state databases that support rich query (e.g. CouchDB)
+// ============================================================================================
+func (t *SimpleChaincode) queryMarblesByFilter(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   query
+	// "structuredQuery"
+	queryString, err := buildQueryStringFromJSON(args.JSON("query"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	queryResults, err := getQueryResultForQueryString(stub, queryString)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// ============================================================================================
+// queryMarblesByFilterWithPagination runs a structured query and returns a single page of
+// results along with the bookmark for the next page.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// ============================================================================================
+func (t *SimpleChaincode) queryMarblesByFilterWithPagination(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+
+	//   query              pageSize  bookmark
+	// "structuredQuery", "10",     "bookmark"
+	queryString, err := buildQueryStringFromJSON(args.JSON("query"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	pageSize, err := pageSizeOf(args)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	bookmark := args.String("bookmark")
+
+	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, pageSize, bookmark)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(queryResults)
+}
+
+// buildQueryStringFromJSON parses a client supplied structured query and converts it to
+// a CouchDB query string
+func buildQueryStringFromJSON(queryJSON []byte) (string, error) {
+	var query marbleQuery
+	decoder := json.NewDecoder(bytes.NewReader(queryJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&query); err != nil {
+		return "", errcode.InvalidArgument("Failed to decode structured query: %s", err)
+	}
+	return buildQueryString(query)
+}
+
+// buildQueryString validates a structured query and serializes it to a CouchDB query string.
+// The selector always restricts results to marble documents.
+func buildQueryString(query marbleQuery) (string, error) {
+	selector := map[string]interface{}{"docType": "marble"}
+
+	for _, filter := range query.Filters {
+		kind, ok := queryableMarbleFields[filter.Field]
+		if !ok {
+			return "", errcode.InvalidArgument("Field '%s' cannot be queried", filter.Field)
+		}
+		value, err := normalizeFilterValue(filter, kind)
+		if err != nil {
+			return "", err
+		}
+
+		conditions, ok := selector[filter.Field].(map[string]interface{})
+		if !ok {
+			conditions = map[string]interface{}{}
+			selector[filter.Field] = conditions
+		}
+		if _, exists := conditions[filter.Op]; exists {
+			return "", errcode.InvalidArgument("Operator '%s' is used more than once on field '%s'", filter.Op, filter.Field)
+		}
+		conditions[filter.Op] = value
+	}
+
+	var sort []map[string]string
+	for _, s := range query.Sort {
+		if _, ok := queryableMarbleFields[s.Field]; !ok {
+			return "", errcode.InvalidArgument("Field '%s' cannot be sorted on", s.Field)
+		}
+		order := strings.ToLower(s.Order)
+		if order == "" {
+			order = "asc"
+		}
+		if order != "asc" && order != "desc" {
+			return "", errcode.InvalidArgument("Sort order must be 'asc' or 'desc', got '%s'", s.Order)
+		}
+		sort = append(sort, map[string]string{s.Field: order})
+	}
+
+	if query.Limit < 0 {
+		return "", errcode.InvalidArgument("Limit must not be negative")
+	}
+
+	if len(query.UseIndex) > 2 {
+		return "", errcode.InvalidArgument("use_index takes a design document and an optional index name")
+	}
+	for _, index := range query.UseIndex {
+		if len(index) == 0 {
+			return "", errcode.InvalidArgument("use_index entries must be non-empty strings")
+		}
+	}
+
+	queryBytes, err := json.Marshal(couchQuery{
+		Selector: selector,
+		Sort:     sort,
+		Limit:    query.Limit,
+		UseIndex: query.UseIndex,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(queryBytes), nil
+}
+
+// normalizeFilterValue checks that a filter's operator and value suit the field's kind and
+// returns the value to place in the selector
+func normalizeFilterValue(filter queryFilter, kind string) (interface{}, error) {
+	if scalarQueryOperators[filter.Op] {
+		return normalizeScalarValue(filter.Field, filter.Value, kind)
+	}
+	if listQueryOperators[filter.Op] {
+		list, ok := filter.Value.([]interface{})
+		if !ok {
+			return nil, errcode.InvalidArgument("Operator '%s' on field '%s' expects a list of values", filter.Op, filter.Field)
+		}
+		values := make([]interface{}, len(list))
+		for i, v := range list {
+			value, err := normalizeScalarValue(filter.Field, v, kind)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+	return nil, errcode.InvalidArgument("Operator '%s' is not supported", filter.Op)
+}
+
+func normalizeScalarValue(field string, value interface{}, kind string) (interface{}, error) {
+	switch kind {
+	case fieldKindString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errcode.InvalidArgument("Field '%s' expects a string value", field)
+		}
+		if lowercasedMarbleFields[field] {
+			s = strings.ToLower(s)
+		}
+		return s, nil
+	case fieldKindNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return nil, errcode.InvalidArgument("Field '%s' expects a numeric value", field)
+		}
+		return n, nil
+	}
+	return nil, errcode.Internal("Field '%s' has an unknown kind", field)
+}
+
+// ==== Chaincode configuration ===============================================================
+// Channel specific settings are passed as a JSON document to Init, e.g. to turn off
+// ad hoc (raw selector) queries on a production channel:
+//   peer chaincode instantiate -C prod -n marbles -v 1.0 -c '{"Args":["init","{\"disableRawQueries\":true,\"maxBatchSize\":20,\"archiveRetentionDays\":90}"]}'
+// The settings are kept in state under a composite key so they can't collide with marble names,
+// and can only be changed by an upgrade, which calls Init again. An upgrade without a
+// configuration document keeps the current settings; pass the whole document to change them.
+// ============================================================================================
+
+const configIndex = "config"
+
+// chaincodeConfig holds the channel specific settings of the marbles chaincode
+type chaincodeConfig struct {
+	DisableRawQueries    bool     `json:"disableRawQueries"`
+	MaxBatchSize         int      `json:"maxBatchSize,omitempty"`         //see marbles_batch.go
+	ArchiveRetentionDays int      `json:"archiveRetentionDays,omitempty"` //see marbles_archive.go
+	ImportMSPIDs         []string `json:"importMspIds,omitempty"`         //see marbles_export.go
+	TokenURIBase         string   `json:"tokenUriBase,omitempty"`         //see marbles_nft.go
+	PaymentChaincode     string   `json:"paymentChaincode,omitempty"`     //see marbles_ownership.go
+}
+
+// putConfig stores the chaincode configuration in state
+func putConfig(stub shim.ChaincodeStubInterface, config chaincodeConfig) error {
+	configKey, err := stub.CreateCompositeKey(configIndex, []string{"marbles"})
+	if err != nil {
+		return err
+	}
+	configJSONasBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, configJSONasBytes)
+}
+
+// configExists returns true if a chaincode configuration has been stored
+func configExists(stub shim.ChaincodeStubInterface) (bool, error) {
+	configKey, err := stub.CreateCompositeKey(configIndex, []string{"marbles"})
+	if err != nil {
+		return false, err
+	}
+	configJSONasBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return false, err
+	}
+	return configJSONasBytes != nil, nil
+}
+
+// getConfig reads the chaincode configuration from state, returning the defaults
+// when none has been stored
+func getConfig(stub shim.ChaincodeStubInterface) (chaincodeConfig, error) {
+	config := chaincodeConfig{}
+	configKey, err := stub.CreateCompositeKey(configIndex, []string{"marbles"})
+	if err != nil {
+		return config, err
+	}
+	configJSONasBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, err
+	}
+	if configJSONasBytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(configJSONasBytes, &config)
+	return config, err
+}
+
+// checkRawQueriesAllowed returns an error if raw selector queries are disabled on this channel
+func checkRawQueriesAllowed(stub shim.ChaincodeStubInterface) error {
+	config, err := getConfig(stub)
+	if err != nil {
+		return errcode.Internal("Failed to read chaincode configuration: %s", err)
+	}
+	if config.DisableRawQueries {
+		return errcode.Unauthorized("Raw selector queries are disabled on this channel, use queryMarblesByFilter instead")
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/statebased"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var (
+	alice = cctest.NewClient("Org1MSP", "alice")
+	bob   = cctest.NewClient("Org2MSP", "bob")
+	carol = cctest.NewClient("Org3MSP", "carol")
+	dave  = cctest.NewClient("Org2MSP", "dave")
+	admin = cctest.NewClientWith("Org1MSP", "admin", cctest.CertOptions{OUs: []string{"admin"}})
+)
+
+// newStub returns a stub holding marble1 to marble3 of alice
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("marbles", new(SimpleChaincode))
+	if res := stub.As(alice).Init("init"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	cctest.Invoke(t, stub, "initMarblesBatch", `[{"name":"marble1","color":"blue","size":35},{"name":"marble2","color":"red","size":50},{"name":"marble3","color":"blue","size":70}]`)
+	stub.Events()
+	return stub
+}
+
+// readMarble returns a marble as stored, or nil if it does not exist
+func readMarble(t *testing.T, stub *cctest.Stub, name string) *marble {
+	marbleAsBytes := stub.State[name]
+	if marbleAsBytes == nil {
+		return nil
+	}
+	m := &marble{}
+	if err := json.Unmarshal(marbleAsBytes, m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func assertOwner(t *testing.T, stub *cctest.Stub, name string, owner *cctest.Client) {
+	if m := readMarble(t, stub, name); m == nil || m.Owner != owner.Member() {
+		t.Errorf("%s is owned by %+v, want %s", name, m, owner.Member())
+	}
+}
+
+func decodeReport(t *testing.T, payload []byte) batchReport {
+	report := batchReport{}
+	if err := json.Unmarshal(payload, &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestAtomicBatchWritesNothingIfAnItemFails(t *testing.T) {
+	stub := newStub(t)
+
+	res := stub.As(alice).Invoke("initMarblesBatch", `[{"name":"marble4","color":"green","size":5},{"name":"marble1","color":"green","size":5}]`)
+	e := cctest.AssertCode(t, res, errcode.CodeAlreadyExists, "a batch creating an existing marble")
+	if e != nil && !strings.Contains(e.Message, "1 of 2 items failed") {
+		t.Errorf("got %s", e.Message)
+	}
+	if readMarble(t, stub, "marble4") != nil {
+		t.Error("the atomic batch created marble4")
+	}
+	if events := stub.Events(); len(events) != 0 {
+		t.Errorf("the failed batch emitted %v", events)
+	}
+
+	res = stub.Invoke("transferMarblesBatch", `[{"name":"marble1","newOwner":"`+bob.Member()+`"},{"name":"marble1","newOwner":"`+carol.Member()+`"}]`)
+	cctest.AssertCode(t, res, errcode.CodeInvalidArgument, "a batch naming a marble twice")
+	assertOwner(t, stub, "marble1", alice)
+
+	res = stub.As(bob).Invoke("deleteMarblesBatch", `["marble1","marble2"]`)
+	cctest.AssertCode(t, res, errcode.CodeUnauthorized, "a batch deleting the marbles of another owner")
+	if readMarble(t, stub, "marble1") == nil || readMarble(t, stub, "marble2") == nil {
+		t.Error("the atomic batch deleted a marble")
+	}
+
+	report := decodeReport(t, cctest.Invoke(t, stub.As(alice), "deleteMarblesBatch", `["marble1","marble2"]`))
+	if report.Mode != batchModeAtomic || report.Succeeded != 2 || report.Failed != 0 {
+		t.Errorf("got %+v", report)
+	}
+	if readMarble(t, stub, "marble1") != nil || readMarble(t, stub, "marble2") != nil {
+		t.Error("the batch did not delete the marbles")
+	}
+	events := stub.Events()
+	if len(events) != 1 || events[0].EventName != "Burn" || strings.Count(string(events[0].Payload), `"marble"`) != 2 {
+		t.Errorf("the batch emitted %v, want one Burn event for both marbles", events)
+	}
+}
+
+func TestBestEffortBatchReportsEachItem(t *testing.T) {
+	stub := newStub(t)
+
+	items := `[{"name":"marble1","newOwner":"` + bob.Member() + `"},` +
+		`{"name":"nope","newOwner":"` + bob.Member() + `"},` +
+		`{"name":"marble1","newOwner":"` + carol.Member() + `"},` +
+		`{"name":"marble2","newOwner":"bob"}]`
+	report := decodeReport(t, cctest.Invoke(t, stub.As(alice), "transferMarblesBatch", items, batchModeBestEffort))
+	if report.Mode != batchModeBestEffort || report.Succeeded != 1 || report.Failed != 3 {
+		t.Fatalf("got %+v", report)
+	}
+	codes := []errcode.Code{"", errcode.CodeNotFound, errcode.CodeInvalidArgument, errcode.CodeInvalidArgument}
+	for i, result := range report.Results {
+		if result.Code != codes[i] || (result.Status == batchItemOK) != (len(codes[i]) == 0) {
+			t.Errorf("item %d: got %+v, want code %q", i, result, codes[i])
+		}
+	}
+	assertOwner(t, stub, "marble1", bob)
+	assertOwner(t, stub, "marble2", alice)
+}
+
+func TestBestEffortBatchDropsTheWritesOfFailedItems(t *testing.T) {
+	stub := newStub(t)
+
+	// the second item fails after it wrote
+	err := stub.As(alice).Call(func(stub shim.ChaincodeStubInterface) error {
+		res := runBatch(stub, batchModeBestEffort, []string{"k1", "k2"}, func(stub shim.ChaincodeStubInterface, i int) error {
+			key := []string{"k1", "k2"}[i]
+			if err := stub.PutState(key, []byte("x")); err != nil {
+				return err
+			}
+			if err := stub.SetEvent("Written", []byte(key)); err != nil {
+				return err
+			}
+			if i == 1 {
+				return errcode.Conflict("Item failed after it wrote")
+			}
+			return nil
+		})
+		if res.Status != shim.OK {
+			t.Errorf("the batch failed: %s", res.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.State["k1"] == nil || stub.State["k2"] != nil {
+		t.Errorf("the batch wrote k1 %q and k2 %q", stub.State["k1"], stub.State["k2"])
+	}
+	if events := stub.Events(); len(events) != 1 || string(events[0].Payload) != "k1" {
+		t.Errorf("the batch emitted %v", events)
+	}
+}
+
+func TestBatchArguments(t *testing.T) {
+	stub := newStub(t)
+	cctest.Invoke(t, stub, "initMarblesBatch", `[{"name":"marble4","color":"red","size":1}]`, "")
+	tests := [][]string{
+		{"initMarblesBatch", `[]`},
+		{"initMarblesBatch", `[{"name":"marble5","color":"red","size":1}]`, "sometimes"},
+		{"initMarblesBatch", `{"name":"marble5"}`},
+		{"deleteMarblesBatch", `["marble1"]`, "Atomic"},
+		{"deleteMarblesBatch", `["m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m","m"]`},
+	}
+	for _, args := range tests {
+		cctest.AssertCode(t, stub.As(alice).Invoke(args...), errcode.CodeInvalidArgument, strings.Join(args, " "))
+	}
+	if readMarble(t, stub, "marble5") != nil {
+		t.Error("a rejected batch created marble5")
+	}
+}
+
+func TestLocking(t *testing.T) {
+	stub := newStub(t).As(alice)
+
+	cctest.Invoke(t, stub, "lockMarble", "marble1")
+	cctest.AssertCode(t, stub.Invoke("transferMarble", "marble1", bob.Member()), errcode.CodeConflict, "transferring a locked marble")
+	cctest.AssertCode(t, stub.Invoke("lockMarble", "marble1"), errcode.CodeConflict, "locking a locked marble")
+	cctest.AssertCode(t, stub.As(bob).Invoke("unlockMarble", "marble1"), errcode.CodeUnauthorized, "unlocking the marble of another owner")
+	cctest.Invoke(t, stub.As(alice), "unlockMarble", "marble1")
+	cctest.Invoke(t, stub, "transferMarble", "marble1", bob.Member())
+	assertOwner(t, stub, "marble1", bob)
+
+	cctest.Invoke(t, stub, "retireMarble", "marble2")
+	cctest.AssertCode(t, stub.Invoke("unlockMarble", "marble2"), errcode.CodeConflict, "unlocking a retired marble")
+	cctest.AssertCode(t, stub.Invoke("transferMarble", "marble2", bob.Member()), errcode.CodeConflict, "transferring a retired marble")
+	cctest.Invoke(t, stub, "delete", "marble2")
+}
+
+func TestEscrowReleasedByTheHolder(t *testing.T) {
+	stub := newStub(t)
+	now := time.Now()
+	stub.At(now)
+
+	cctest.AssertCode(t, stub.As(alice).Invoke("escrowMarble", "marble1", alice.Member(), "60"), errcode.CodeInvalidArgument, "escrow with the owner")
+	cctest.AssertCode(t, stub.Invoke("escrowMarble", "marble1", carol.Member(), "0"), errcode.CodeInvalidArgument, "escrow without a duration")
+	cctest.Invoke(t, stub, "escrowMarble", "marble1", carol.Member(), "60")
+	if m := readMarble(t, stub, "marble1"); m.Status != marbleEscrowed || m.Escrow.Holder != carol.Member() {
+		t.Fatalf("escrowed %+v", m)
+	}
+
+	cctest.AssertCode(t, stub.Invoke("transferMarble", "marble1", bob.Member()), errcode.CodeConflict, "transferring an escrowed marble")
+	cctest.AssertCode(t, stub.Invoke("lockMarble", "marble1"), errcode.CodeConflict, "locking an escrowed marble")
+	cctest.AssertCode(t, stub.Invoke("releaseEscrow", "marble1"), errcode.CodeConflict, "the owner releasing the escrow before it expires")
+	cctest.AssertCode(t, stub.As(dave).Invoke("releaseEscrow", "marble1"), errcode.CodeUnauthorized, "a third party releasing the escrow")
+
+	cctest.Invoke(t, stub.As(carol), "releaseEscrow", "marble1", bob.Member())
+	m := readMarble(t, stub, "marble1")
+	if m.Status != marbleActive || m.Escrow != nil || m.Owner != bob.Member() {
+		t.Errorf("released %+v", m)
+	}
+	cctest.AssertCode(t, stub.Invoke("releaseEscrow", "marble1"), errcode.CodeConflict, "releasing a marble that is not in escrow")
+}
+
+func TestExpiredEscrowReturnsToTheOwner(t *testing.T) {
+	stub := newStub(t)
+	now := time.Now()
+	stub.At(now)
+
+	cctest.Invoke(t, stub.As(alice), "escrowMarble", "marble1", carol.Member(), "60")
+	stub.At(now.Add(2 * time.Minute))
+	cctest.AssertCode(t, stub.As(alice).Invoke("releaseEscrow", "marble1", bob.Member()), errcode.CodeConflict, "the owner releasing an expired escrow to another owner")
+	cctest.AssertCode(t, stub.As(carol).Invoke("releaseEscrow", "marble1", bob.Member()), errcode.CodeConflict, "the holder releasing an expired escrow to another owner")
+
+	cctest.Invoke(t, stub.As(alice), "releaseEscrow", "marble1")
+	if m := readMarble(t, stub, "marble1"); m.Status != marbleActive || m.Owner != alice.Member() {
+		t.Errorf("reclaimed %+v", m)
+	}
+}
+
+// endorsingOrgs returns the orgs whose peers must endorse changes to a marble
+func endorsingOrgs(t *testing.T, stub *cctest.Stub, name string) []string {
+	policy := marbleEndorsementPolicy{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "getMarbleEndorsementPolicy", name), &policy); err != nil {
+		t.Fatal(err)
+	}
+	return policy.Orgs
+}
+
+func TestEndorsementPolicyFollowsTheOwner(t *testing.T) {
+	stub := newStub(t)
+
+	if orgs := endorsingOrgs(t, stub, "marble1"); len(orgs) != 1 || orgs[0] != alice.MSPID {
+		t.Errorf("a new marble is endorsed by %v", orgs)
+	}
+	cctest.Invoke(t, stub.As(alice), "transferMarble", "marble1", bob.Member())
+	if orgs := endorsingOrgs(t, stub, "marble1"); len(orgs) != 1 || orgs[0] != bob.MSPID {
+		t.Errorf("a transferred marble is endorsed by %v", orgs)
+	}
+	cctest.AssertCode(t, stub.Invoke("getMarbleEndorsementPolicy", "nope"), errcode.CodeNotFound, "the policy of a missing marble")
+
+	// Approvals are endorsed by the owner's org, not by the approved's
+	cctest.Invoke(t, stub.As(alice), "approve", carol.Member(), "marble2")
+	cctest.Invoke(t, stub, "setApprovalForAll", dave.Member(), "true")
+	approvalKey, _ := stub.CreateCompositeKey(approvalIndex, []string{"marble2"})
+	operatorKey, _ := stub.CreateCompositeKey(operatorIndex, []string{alice.Member(), dave.Member()})
+	for _, key := range []string{approvalKey, operatorKey} {
+		policy, _ := stub.GetStateValidationParameter(key)
+		ep, err := statebased.NewStateEP(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orgs := ep.ListOrgs(); len(orgs) != 1 || orgs[0] != alice.MSPID {
+			t.Errorf("%q is endorsed by %v", key, orgs)
+		}
+	}
+}
+
+func TestApprovedTransfers(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", alice.Member(), carol.Member(), "marble1"), errcode.CodeUnauthorized, "a transfer without approval")
+	cctest.AssertCode(t, stub.As(alice).Invoke("approve", alice.Member(), "marble1"), errcode.CodeInvalidArgument, "approving the owner")
+	cctest.AssertCode(t, stub.As(bob).Invoke("approve", bob.Member(), "marble1"), errcode.CodeUnauthorized, "approving the marble of another owner")
+
+	cctest.Invoke(t, stub.As(alice), "approve", carol.Member(), "marble1")
+	if events := stub.Events(); len(events) != 1 || events[0].EventName != "Approval" {
+		t.Errorf("approve emitted %v", events)
+	}
+	if approved := string(cctest.Invoke(t, stub, "getApproved", "marble1")); approved != carol.Member() {
+		t.Errorf("getApproved returned %s", approved)
+	}
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", bob.Member(), carol.Member(), "marble1"), errcode.CodeConflict, "a transfer from the wrong owner")
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", alice.Member(), carol.Member(), "marble2"), errcode.CodeUnauthorized, "a transfer of a marble that was not approved")
+
+	cctest.Invoke(t, stub.As(carol), "transferFrom", alice.Member(), bob.Member(), "marble1")
+	assertOwner(t, stub, "marble1", bob)
+	if approved := string(cctest.Invoke(t, stub, "getApproved", "marble1")); approved != "" {
+		t.Errorf("the approval survived the transfer: %s", approved)
+	}
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", bob.Member(), carol.Member(), "marble1"), errcode.CodeUnauthorized, "a second transfer by the formerly approved")
+
+	// An operator may transfer and approve all marbles of the owner
+	cctest.Invoke(t, stub.As(alice), "setApprovalForAll", dave.Member(), "true")
+	if ok := string(cctest.Invoke(t, stub, "isApprovedForAll", alice.Member(), dave.Member())); ok != "true" {
+		t.Errorf("isApprovedForAll returned %s", ok)
+	}
+	cctest.Invoke(t, stub.As(dave), "transferMarble", "marble2", dave.Member())
+	cctest.Invoke(t, stub.As(dave), "approve", carol.Member(), "marble3")
+	cctest.Invoke(t, stub.As(carol), "transferFrom", alice.Member(), carol.Member(), "marble3")
+	assertOwner(t, stub, "marble2", dave)
+	assertOwner(t, stub, "marble3", carol)
+
+	cctest.Invoke(t, stub.As(bob), "transferMarble", "marble1", alice.Member())
+	cctest.Invoke(t, stub.As(alice), "setApprovalForAll", dave.Member(), "false")
+	cctest.AssertCode(t, stub.As(dave).Invoke("transferMarble", "marble1", dave.Member()), errcode.CodeUnauthorized, "a transfer by a former operator")
+
+	if n := string(cctest.Invoke(t, stub, "balanceOf", alice.Member())); n != "1" {
+		t.Errorf("alice holds %s marbles", n)
+	}
+	if owner := string(cctest.Invoke(t, stub, "ownerOf", "marble3")); owner != carol.Member() {
+		t.Errorf("ownerOf returned %s", owner)
+	}
+}
+
+func TestTokenURI(t *testing.T) {
+	stub := newStub(t)
+	uri := string(cctest.Invoke(t, stub, "tokenURI", "marble1"))
+	if !strings.HasPrefix(uri, "data:application/json;base64,") {
+		t.Errorf("got %s", uri)
+	}
+	cctest.AssertCode(t, stub.Invoke("tokenURI", "nope"), errcode.CodeNotFound, "the URI of a missing marble")
+}
+
+func TestMigrateOwner(t *testing.T) {
+	stub := cctest.NewStub("marbles", new(SimpleChaincode))
+	if res := stub.As(alice).Init("init", `{"importMspIds":["Org1MSP"]}`); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	// marble9 was created before owner IDs, without index entries
+	err := stub.Call(func(stub shim.ChaincodeStubInterface) error {
+		return stub.PutState("marble9", []byte(`{"docType":"marble","name":"marble9","color":"green","size":9,"owner":"tom","status":"active"}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cctest.AssertCode(t, stub.As(bob).Invoke("transferMarble", "marble9", bob.Member()), errcode.CodeUnauthorized, "a transfer of a marble with a free-text owner")
+
+	cctest.AssertCode(t, stub.As(bob).Invoke("migrateOwner", "marble9", bob.Member()), errcode.CodeUnauthorized, "a migration by a client of another MSP")
+	cctest.AssertCode(t, stub.As(alice).Invoke("migrateOwner", "marble9", bob.Member()), errcode.CodeUnauthorized, "a migration by a client that is no admin")
+	cctest.AssertCode(t, stub.As(admin).Invoke("migrateOwner", "marble9", "bob"), errcode.CodeInvalidArgument, "a migration to a free-text owner")
+	cctest.Invoke(t, stub, "migrateOwner", "marble9", bob.Member())
+	assertOwner(t, stub, "marble9", bob)
+	cctest.AssertCode(t, stub.Invoke("migrateOwner", "marble9", alice.Member()), errcode.CodeConflict, "migrating a marble that has an owner ID")
+
+	if n := string(cctest.Invoke(t, stub, "balanceOf", bob.Member())); n != "1" {
+		t.Errorf("the owner~name index holds %s marbles of bob", n)
+	}
+	cctest.Invoke(t, stub.As(bob), "transferMarble", "marble9", carol.Member())
+	assertOwner(t, stub, "marble9", carol)
+}
+
+// importRecord returns an export record of a marble of owner with the given lifecycle fields
+func importRecord(name, owner, lifecycle string) string {
+	return `{"key":"` + name + `","docType":"marble","value":{"docType":"marble","name":"` + name +
+		`","color":"red","size":5,"owner":"` + owner + `",` + lifecycle + `}}`
+}
+
+func TestImportState(t *testing.T) {
+	stub := cctest.NewStub("marbles", new(SimpleChaincode))
+	if res := stub.As(alice).Init("init", `{"importMspIds":["Org1MSP"]}`); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	cctest.Invoke(t, stub, "initMarblesBatch", `[{"name":"marble1","color":"blue","size":35},{"name":"marble2","color":"red","size":50}]`)
+	cctest.Invoke(t, stub, "approve", bob.Member(), "marble1")
+	cctest.Invoke(t, stub, "escrowMarble", "marble2", carol.Member(), "3600")
+
+	offered := importRecord("marble4", bob.Member(), `"status":"locked","lockedBy":"offer:1"`)
+	otherAdmin := cctest.NewClientWith("Org2MSP", "admin", cctest.CertOptions{OUs: []string{"admin"}})
+	cctest.AssertCode(t, stub.As(alice).Invoke("importState", offered), errcode.CodeUnauthorized, "an import by a client that is no admin")
+	cctest.AssertCode(t, stub.As(otherAdmin).Invoke("importState", offered), errcode.CodeUnauthorized, "an import by an admin of another MSP")
+	cctest.Invoke(t, stub.As(admin), "importState", offered)
+	if m := readMarble(t, stub, "marble4"); m == nil || m.Status != marbleActive || len(m.LockedBy) > 0 {
+		t.Errorf("the marble locked by an offer was imported as %+v", m)
+	}
+
+	escrowed := importRecord("marble5", bob.Member(), `"status":"escrowed","escrow":{"holder":"`+carol.Member()+`","since":"","expires":""}`)
+	cctest.AssertCode(t, stub.Invoke("importState", escrowed), errcode.CodeInvalidArgument, "importing an escrowed marble")
+	cctest.AssertCode(t, stub.Invoke("importState", importRecord("marble5", bob.Member(), `"status":"active","lockedBy":"owner"`)), errcode.CodeInvalidArgument, "importing an active marble with a lock")
+
+	// Forcing the import never overwrites an escrow, and drops the approvals of the previous owner
+	cctest.AssertCode(t, stub.Invoke("importState", importRecord("marble2", bob.Member(), `"status":"active"`), "true"), errcode.CodeConflict, "overwriting an escrowed marble")
+	cctest.Invoke(t, stub, "importState", importRecord("marble1", bob.Member(), `"status":"active"`), "true")
+	assertOwner(t, stub, "marble1", bob)
+	if approved := string(cctest.Invoke(t, stub, "getApproved", "marble1")); len(approved) > 0 {
+		t.Errorf("the approval of alice survived the import: %s", approved)
+	}
+}
+
+// testPayments stands in for the token chaincode: transfer(to, amount) pays
+// up to limit tokens and records the payment
+type testPayments struct {
+	limit int
+	paid  map[string]int
+}
+
+func (p *testPayments) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (p *testPayments) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, args := stub.GetFunctionAndParameters()
+	amount, err := strconv.Atoi(args[1])
+	if function != "transfer" || err != nil {
+		return shim.Error("unexpected payment " + strings.Join(args, " "))
+	}
+	if amount > p.limit {
+		return errcode.Conflict("Insufficient balance").Response()
+	}
+	p.paid[args[0]] += amount
+	return shim.Success(nil)
+}
+
+// offer invokes offerMarble and returns the ID of the offer
+func offer(t *testing.T, stub *cctest.Stub, args ...string) string {
+	offer := marbleOffer{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, append([]string{"offerMarble"}, args...)...), &offer); err != nil {
+		t.Fatal(err)
+	}
+	return offer.ID
+}
+
+func TestSalesArePaidInTheTokenChaincode(t *testing.T) {
+	stub := newStub(t)
+	cctest.AssertCode(t, stub.As(alice).Invoke("offerMarble", "marble1", bob.Member(), "", "30"), errcode.CodeInvalidArgument, "a sale without a payment chaincode")
+
+	payments := &testPayments{limit: 100, paid: map[string]int{}}
+	stub.MockPeerChaincode("token", shim.NewMockStub("token", payments))
+	if res := stub.As(alice).Init("init", `{"paymentChaincode":"token"}`); res.Status != shim.OK {
+		t.Fatalf("the upgrade failed: %s", res.Message)
+	}
+
+	id := offer(t, stub.As(alice), "marble1", bob.Member(), "", "30")
+	cctest.Invoke(t, stub.As(bob), "acceptOffer", id)
+	assertOwner(t, stub, "marble1", bob)
+	if payments.paid[alice.Member()] != 30 {
+		t.Errorf("alice was paid %v", payments.paid)
+	}
+
+	// The marble stays with the offerer if the payment fails
+	id = offer(t, stub.As(alice), "marble2", bob.Member(), "", "500")
+	cctest.AssertCode(t, stub.As(bob).Invoke("acceptOffer", id), errcode.CodeConflict, "accepting an offer above the balance")
+	assertOwner(t, stub, "marble2", alice)
+	if m := readMarble(t, stub, "marble2"); m.Status != marbleLocked {
+		t.Errorf("the marble of the open offer is %s", m.Status)
+	}
+}
@@ -84,9 +84,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"strconv"
 	"strings"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -131,90 +132,73 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
 	fmt.Println("invoke is running " + function)
 
-	// Handle different functions
-	switch function {
-	case "initMarble":
-		//create a new marble
-		return t.initMarble(stub, args)
-	case "readMarble":
-		//read a marble
-		return t.readMarble(stub, args)
-	case "readMarblePrivateDetails":
-		//read a marble private details
-		return t.readMarblePrivateDetails(stub, args)
-	case "transferMarble":
-		//change owner of a specific marble
-		return t.transferMarble(stub, args)
-	case "transferMarblesBasedOnColor":
-		//transfer all marbles of a certain color
-		return t.transferMarblesBasedOnColor(stub, args)
-	case "delete":
-		//delete a marble
-		return t.delete(stub, args)
-	case "queryMarblesByOwner":
-		//find marbles for owner X using rich query
-		return t.queryMarblesByOwner(stub, args)
-	case "queryMarbles":
-		//find marbles based on an ad hoc rich query
-		return t.queryMarbles(stub, args)
-	case "getMarblesByRange":
-		//get marbles based on range query
-		return t.getMarblesByRange(stub, args)
-	default:
-		//error
-		fmt.Println("invoke did not find func: " + function)
-		return shim.Error("Received unknown function invocation")
-	}
+	return routes.Dispatch(stub, function, args)
+}
+
+// routes lists the functions of the chaincode and their parameters. The router checks
+// the arguments of every invocation, and the built-in "describe" function returns the
+// list as JSON. It is built once since the chaincode keeps no state of its own.
+var routes = new(SimpleChaincode).buildRoutes()
+
+// buildRoutes registers the handlers of the chaincode
+func (t *SimpleChaincode) buildRoutes() *router.Router {
+	name := router.Required("name", router.String)
+	return router.New("marblesp", "1.0").
+		Add("initMarble", "Create a new marble", t.initMarble,
+			name,
+			router.Required("color", router.String),
+			router.Required("size", router.Int),
+			router.Required("owner", router.String),
+			router.Required("price", router.Int)).
+		Add("readMarble", "Read a marble", t.readMarble, name).
+		Add("readMarblePrivateDetails", "Read a marble private details", t.readMarblePrivateDetails, name).
+		Add("transferMarble", "Change owner of a specific marble", t.transferMarble,
+			name,
+			router.Required("newOwner", router.String)).
+		Add("transferMarblesBasedOnColor", "Transfer all marbles of a certain color", t.transferMarblesBasedOnColor,
+			router.Required("color", router.String),
+			router.Required("newOwner", router.String)).
+		Add("delete", "Delete a marble", t.delete, name).
+		Add("queryMarblesByOwner", "Find marbles for owner X using rich query", t.queryMarblesByOwner,
+			router.Required("owner", router.String)).
+		Add("queryMarbles", "Find marbles based on an ad hoc rich query", t.queryMarbles,
+			router.Required("queryString", router.JSON)).
+		Add("getMarblesByRange", "Get marbles based on range query", t.getMarblesByRange,
+			router.Required("startKey", router.String),
+			router.Required("endKey", router.String))
 }
 
 // ============================================================
 // initMarble - create a new marble, store into chaincode state
 // ============================================================
-func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var err error
-
-	//  0-name  1-color  2-size  3-owner  4-price
+func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	//  name    color    size   owner    price
 	// "asdf",  "blue",  "35",   "bob",   "99"
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
-	}
 
 	// ==== Input sanitation ====
 	fmt.Println("- start init marble")
-	if len(args[0]) == 0 {
-		return shim.Error("1st argument must be a non-empty string")
+	marbleName := args.String("name")
+	color := strings.ToLower(args.String("color"))
+	owner := strings.ToLower(args.String("owner"))
+	size := args.Int("size")
+	price := args.Int("price")
+	if len(marbleName) == 0 {
+		return errcode.InvalidArgument("name must be a non-empty string").Response()
 	}
-	if len(args[1]) == 0 {
-		return shim.Error("2nd argument must be a non-empty string")
-	}
-	if len(args[2]) == 0 {
-		return shim.Error("3rd argument must be a non-empty string")
-	}
-	if len(args[3]) == 0 {
-		return shim.Error("4th argument must be a non-empty string")
-	}
-	if len(args[4]) == 0 {
-		return shim.Error("5th argument must be a non-empty string")
-	}
-	marbleName := args[0]
-	color := strings.ToLower(args[1])
-	owner := strings.ToLower(args[3])
-	size, err := strconv.Atoi(args[2])
-	if err != nil {
-		return shim.Error("3rd argument must be a numeric string")
+	if len(color) == 0 {
+		return errcode.InvalidArgument("color must be a non-empty string").Response()
 	}
-	price, err := strconv.Atoi(args[4])
-	if err != nil {
-		return shim.Error("5th argument must be a numeric string")
+	if len(owner) == 0 {
+		return errcode.InvalidArgument("owner must be a non-empty string").Response()
 	}
 
 	// ==== Check if marble already exists ====
 	marbleAsBytes, err := stub.GetPrivateData("collectionMarbles", marbleName)
 	if err != nil {
-		return shim.Error("Failed to get marble: " + err.Error())
+		return errcode.Internal("Failed to get marble: %s", err).Response()
 	} else if marbleAsBytes != nil {
 		fmt.Println("This marble already exists: " + marbleName)
-		return shim.Error("This marble already exists: " + marbleName)
+		return errcode.AlreadyExists("This marble already exists: %s", marbleName).Response()
 	}
 
 	// ==== Create marble object and marshal to JSON ====
@@ -222,7 +206,7 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 	marble := &marble{objectType, marbleName, color, size, owner}
 	marbleJSONasBytes, err := json.Marshal(marble)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	//Alternatively, build the marble json string manually if you don't want to use struct marshalling
 	//marbleJSONasString := `{"docType":"Marble",  "name": "` + marbleName + `", "color": "` + color + `", "size": ` + strconv.Itoa(size) + `, "owner": "` + owner + `"}`
@@ -231,7 +215,7 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 	// === Save marble to state ===
 	err = stub.PutPrivateData("collectionMarbles", marbleName, marbleJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	// ==== Save marble private details ====
@@ -239,11 +223,11 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 	marblePrivateDetails := &marblePrivateDetails{objectType, marbleName, price}
 	marblePrivateDetailsBytes, err := json.Marshal(marblePrivateDetails)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	err = stub.PutPrivateData("collectionMarblePrivateDetails", marbleName, marblePrivateDetailsBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	//  ==== Index the marble to enable color-based range queries, e.g. return all blue marbles ====
@@ -254,7 +238,7 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 	indexName := "color~name"
 	colorNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{marble.Color, marble.Name})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	//  Save index entry to state. Only the key name is needed, no need to store a duplicate copy of the marble.
 	//  Note - passing a 'nil' value will effectively delete the key from state, therefore we pass null character as value
@@ -269,22 +253,13 @@ func (t *SimpleChaincode) initMarble(stub shim.ChaincodeStubInterface, args []st
 // ===============================================
 // readMarble - read a marble from chaincode state
 // ===============================================
-func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
-
-	name = args[0]
+func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	name := args.String("name")
 	valAsbytes, err := stub.GetPrivateData("collectionMarbles", name) //get the marble from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", name).Response()
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Marble does not exist: %s", name).Response()
 	}
 
 	return shim.Success(valAsbytes)
@@ -293,22 +268,13 @@ func (t *SimpleChaincode) readMarble(stub shim.ChaincodeStubInterface, args []st
 // ===============================================
 // readMarblereadMarblePrivateDetails - read a marble private details from chaincode state
 // ===============================================
-func (t *SimpleChaincode) readMarblePrivateDetails(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var name, jsonResp string
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the marble to query")
-	}
-
-	name = args[0]
+func (t *SimpleChaincode) readMarblePrivateDetails(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	name := args.String("name")
 	valAsbytes, err := stub.GetPrivateData("collectionMarblePrivateDetails", name) //get the marble private details from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get private details for " + name + ": " + err.Error() + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get private details for %s: %s", name, err).Response()
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble private details does not exist: " + name + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Marble private details does not exist: %s", name).Response()
 	}
 
 	return shim.Success(valAsbytes)
@@ -317,52 +283,45 @@ func (t *SimpleChaincode) readMarblePrivateDetails(stub shim.ChaincodeStubInterf
 // ==================================================
 // delete - remove a marble key/value pair from state
 // ==================================================
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var jsonResp string
+func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 	var marbleJSON marble
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	marbleName := args[0]
+	marbleName := args.String("name")
 
 	// to maintain the color~name index, we need to read the marble first and get its color
 	valAsbytes, err := stub.GetPrivateData("collectionMarbles", marbleName) //get the marble from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to get state for %s", marbleName).Response()
 	} else if valAsbytes == nil {
-		jsonResp = "{\"Error\":\"Marble does not exist: " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.NotFound("Marble does not exist: %s", marbleName).Response()
 	}
 
 	err = json.Unmarshal([]byte(valAsbytes), &marbleJSON)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to decode JSON of: " + marbleName + "\"}"
-		return shim.Error(jsonResp)
+		return errcode.Internal("Failed to decode JSON of: %s", marbleName).Response()
 	}
 
 	err = stub.DelPrivateData("collectionMarbles", marbleName) //remove the marble from chaincode state
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errcode.Internal("Failed to delete state:%s", err).Response()
 	}
 
 	// maintain the index
 	indexName := "color~name"
 	colorNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{marbleJSON.Color, marbleJSON.Name})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	//  Delete index entry to state.
 	err = stub.DelPrivateData("collectionMarbles", colorNameIndexKey)
 	if err != nil {
-		return shim.Error("Failed to delete state:" + err.Error())
+		return errcode.Internal("Failed to delete state:%s", err).Response()
 	}
 
 	//  Delete private details of marble
 	err = stub.DelPrivateData("collectionMarblePrivateDetails", marbleName)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 
 	return shim.Success(nil)
@@ -371,40 +330,42 @@ func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string
 // ===========================================================
 // transfer a marble by setting a new owner name on the marble
 // ===========================================================
-func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0       1
+	//   name    newOwner
 	// "name", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	marbleName := args.String("name")
+	newOwner := strings.ToLower(args.String("newOwner"))
+	fmt.Println("- start transferMarble ", marbleName, newOwner)
+
+	err := changeMarbleOwner(stub, marbleName, newOwner)
+	if err != nil {
+		return errcode.Response(err)
 	}
 
-	marbleName := args[0]
-	newOwner := strings.ToLower(args[1])
-	fmt.Println("- start transferMarble ", marbleName, newOwner)
+	fmt.Println("- end transferMarble (success)")
+	return shim.Success(nil)
+}
 
+// changeMarbleOwner sets a new owner name on a marble, for transferMarble and
+// transferMarblesBasedOnColor
+func changeMarbleOwner(stub shim.ChaincodeStubInterface, marbleName, newOwner string) error {
 	marbleAsBytes, err := stub.GetPrivateData("collectionMarbles", marbleName)
 	if err != nil {
-		return shim.Error("Failed to get marble:" + err.Error())
+		return errcode.Internal("Failed to get marble:%s", err)
 	} else if marbleAsBytes == nil {
-		return shim.Error("Marble does not exist")
+		return errcode.NotFound("Marble does not exist")
 	}
 
 	marbleToTransfer := marble{}
 	err = json.Unmarshal(marbleAsBytes, &marbleToTransfer) //unmarshal it aka JSON.parse()
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
 	marbleToTransfer.Owner = newOwner //change the owner
 
 	marbleJSONasBytes, _ := json.Marshal(marbleToTransfer)
-	err = stub.PutPrivateData("collectionMarbles", marbleName, marbleJSONasBytes) //rewrite the marble
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-
-	fmt.Println("- end transferMarble (success)")
-	return shim.Success(nil)
+	return stub.PutPrivateData("collectionMarbles", marbleName, marbleJSONasBytes) //rewrite the marble
 }
 
 // ===========================================================================================
@@ -418,18 +379,14 @@ func (t *SimpleChaincode) transferMarble(stub shim.ChaincodeStubInterface, args
 // time and commit time.
 // Therefore, range queries are a safe option for performing update transactions based on query results.
 // ===========================================================================================
-func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
-	startKey := args[0]
-	endKey := args[1]
+	startKey := args.String("startKey")
+	endKey := args.String("endKey")
 
 	resultsIterator, err := stub.GetPrivateDataByRange("collectionMarbles", startKey, endKey)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer resultsIterator.Close()
 
@@ -441,7 +398,7 @@ func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, ar
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 		// Add a comma before array members, suppress it for the first array member
 		if bArrayMemberAlreadyWritten == true {
@@ -473,23 +430,19 @@ func (t *SimpleChaincode) getMarblesByRange(stub shim.ChaincodeStubInterface, ar
 // committing peers if the result set has changed between endorsement time and commit time.
 // Therefore, range queries are a safe option for performing update transactions based on query results.
 // ===========================================================================================
-func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0       1
+	//   color    newOwner
 	// "color", "bob"
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
-	}
-
-	color := args[0]
-	newOwner := strings.ToLower(args[1])
+	color := args.String("color")
+	newOwner := strings.ToLower(args.String("newOwner"))
 	fmt.Println("- start transferMarblesBasedOnColor ", color, newOwner)
 
 	// Query the color~name index by color
 	// This will execute a key range query on all keys starting with 'color'
 	coloredMarbleResultsIterator, err := stub.GetPrivateDataByPartialCompositeKey("collectionMarbles", "color~name", []string{color})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	defer coloredMarbleResultsIterator.Close()
 
@@ -499,13 +452,13 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 		// Note that we don't get the value (2nd return variable), we'll just get the marble name from the composite key
 		responseRange, err := coloredMarbleResultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 
 		// get the color and name from color~name composite key
 		objectType, compositeKeyParts, err := stub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errcode.Response(err)
 		}
 		returnedColor := compositeKeyParts[0]
 		returnedMarbleName := compositeKeyParts[1]
@@ -513,10 +466,10 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 
 		// Now call the transfer function for the found marble.
 		// Re-use the same function that is used to transfer individual marbles
-		response := t.transferMarble(stub, []string{returnedMarbleName, newOwner})
+		err = changeMarbleOwner(stub, returnedMarbleName, newOwner)
 		// if the transfer failed break out of loop and return error
-		if response.Status != shim.OK {
-			return shim.Error("Transfer failed: " + response.Message)
+		if err != nil {
+			return errcode.Wrap(err, "Transfer failed").Response()
 		}
 	}
 
@@ -544,21 +497,17 @@ func (t *SimpleChaincode) transferMarblesBasedOnColor(stub shim.ChaincodeStubInt
 // and accepting a single query parameter (owner).
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	//   0
+	//   owner
 	// "bob"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-
-	owner := strings.ToLower(args[0])
+	owner := strings.ToLower(args.String("owner"))
 
 	queryString := fmt.Sprintf("{\"selector\":{\"docType\":\"marble\",\"owner\":\"%s\"}}", owner)
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	return shim.Success(queryResults)
 }
@@ -570,19 +519,14 @@ func (t *SimpleChaincode) queryMarblesByOwner(stub shim.ChaincodeStubInterface,
 // If this is not desired, follow the queryMarblesForOwner example for parameterized queries.
 // Only available on state databases that support rich query (e.g. CouchDB)
 // =========================================================================================
-func (t *SimpleChaincode) queryMarbles(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	//   0
-	// "queryString"
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
+func (t *SimpleChaincode) queryMarbles(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
 
-	queryString := args[0]
+	//   queryString
+	queryString := string(args.JSON("queryString"))
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
 	return shim.Success(queryResults)
 }
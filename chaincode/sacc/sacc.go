@@ -7,8 +7,15 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
@@ -17,76 +24,477 @@ import (
 type SimpleAsset struct {
 }
 
+// routes lists the functions of the chaincode and their parameters
+var routes = router.New("sacc", "1.0").
+	Add("set", "Stores a value, overwriting any existing value",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(set(stub, args.String("key"), args.String("value")))
+		},
+		router.Required("key", router.String),
+		router.Required("value", router.String)).
+	Add("setWithTTL", "Stores a value that expires after a number of seconds",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(setWithTTL(stub, args.String("key"), args.String("value"), args.Int("seconds")))
+		},
+		router.Required("key", router.String),
+		router.Required("value", router.String),
+		router.Required("seconds", router.Int)).
+	Add("get", "Returns the value of a key",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(get(stub, args.String("key")))
+		},
+		router.Required("key", router.String)).
+	Add("getWithMeta", "Returns the value of a key with its version and last writer",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(getWithMeta(stub, args.String("key")))
+		},
+		router.Required("key", router.String)).
+	Add("setIfVersion", "Stores a value if the key is still at the expected version",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(setIfVersion(stub, args.String("key"), args.String("value"), args.Int("expectedVersion")))
+		},
+		router.Required("key", router.String),
+		router.Required("value", router.String),
+		router.Required("expectedVersion", router.Int).Describe("0 to create the key")).
+	Add("setBinary", "Stores binary data with its content type",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(setBinary(stub, args.String("key"), args.String("data"), args.String("contentType"), args.String("encoding")))
+		},
+		router.Required("key", router.String),
+		router.Required("data", router.String),
+		router.Required("contentType", router.String),
+		router.Optional("encoding", router.String).Describe("raw (default) or base64")).
+	Add("setReference", "Stores the SHA-256 and URI of data kept off-chain",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(setReference(stub, args.String("key"), args.String("sha256"), args.String("uri"), args.String("contentType"), args.Int("size")))
+		},
+		router.Required("key", router.String),
+		router.Required("sha256", router.String).Describe("hex encoded"),
+		router.Required("uri", router.String),
+		router.Required("contentType", router.String),
+		router.Optional("size", router.Int)).
+	Add("verify", "Checks data against the SHA-256 stored for a key",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(verify(stub, args.String("key"), args.String("data"), args.String("encoding")))
+		},
+		router.Required("key", router.String),
+		router.Required("data", router.String),
+		router.Optional("encoding", router.String).Describe("raw (default) or base64")).
+	Add("del", "Deletes a key",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(del(stub, args.String("key")))
+		},
+		router.Required("key", router.String)).
+	Add("list", "Returns a page of the keys starting with a prefix, with their values",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(list(stub, args.String("prefix"), args.Int("pageSize"), args.String("bookmark")))
+		},
+		router.Required("prefix", router.String).Describe("empty to list all keys"),
+		router.Required("pageSize", router.Int),
+		router.Optional("bookmark", router.String)).
+	Add("setIfAbsent", "Stores a value unless the key exists",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(setIfAbsent(stub, args.String("key"), args.String("value")))
+		},
+		router.Required("key", router.String),
+		router.Required("value", router.String)).
+	Add("compareAndSet", "Replaces the value of a key if it still has the expected value",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(compareAndSet(stub, args.String("key"), args.String("expected"), args.String("new")))
+		},
+		router.Required("key", router.String),
+		router.Required("expected", router.String),
+		router.Required("new", router.String)).
+	Add("sweepExpired", "Deletes a page of expired keys",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(sweepExpired(stub, args.Int("pageSize")))
+		},
+		router.Required("pageSize", router.Int)).
+	Add("exportState", "Exports a page of assets as NDJSON",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(exportState(stub, args.String("startKey"), args.String("endKey"), args.Int("pageSize"), args.String("bookmark")))
+		},
+		router.Required("startKey", router.String),
+		router.Required("endKey", router.String),
+		router.Required("pageSize", router.Int),
+		router.Required("bookmark", router.String)).
+	Add("importState", "Imports assets exported with exportState",
+		func(stub shim.ChaincodeStubInterface, args *router.Args) peer.Response {
+			return respond(importState(stub, args.String("records"), args.Bool("force")))
+		},
+		router.Required("records", router.String),
+		router.Optional("force", router.Bool).Describe("overwrite existing keys"))
+
 // Init is called during chaincode instantiation to initialize any
 // data. Note that chaincode upgrade also calls this function to reset
 // or to migrate data.
 func (t *SimpleAsset) Init(stub shim.ChaincodeStubInterface) peer.Response {
 	// Get the args from the transaction proposal
 	args := stub.GetStringArgs()
-	if len(args) != 2 {
-		return shim.Error("Incorrect arguments. Expecting a key and a value")
+	if len(args) != 2 && len(args) != 3 {
+		return errcode.InvalidArgument("Incorrect arguments. Expecting a key, a value and an optional maximum value size").Response()
 	}
 
 	// Set up any variables or assets here by calling stub.PutState()
+	if len(args) == 3 {
+		if err := putMaxValueSize(stub, args[2]); err != nil {
+			return errcode.Response(err)
+		}
+	}
 
 	// We store the key and the value on the ledger
-	err := stub.PutState(args[0], []byte(args[1]))
+	_, err := putAsset(stub, args[0], args[1])
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Failed to create asset: %s", args[0]))
+		return errcode.Wrap(err, "Failed to create asset: %s", args[0]).Response()
 	}
 	return shim.Success(nil)
 }
 
-// Invoke is called per transaction on the chaincode. Each transaction is
-// either a 'get' or a 'set' on the asset created by Init function. The Set
-// method may create a new asset by specifying a new key-value pair.
+// Invoke is called per transaction on the chaincode. Each transaction
+// calls one of the functions registered in routes, e.g. a 'get' or a 'set'
+// on the asset created by Init function. The Set method may create a new
+// asset by specifying a new key-value pair. Unknown functions are rejected.
 func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
-	// Extract the function and args from the transaction proposal
-	fn, args := stub.GetFunctionAndParameters()
+	return routes.Handle(stub)
+}
 
-	var result string
-	var err error
-	if fn == "set" {
-		result, err = set(stub, args)
-	} else { // assume 'get' even if fn is nil
-		result, err = get(stub, args)
-	}
+// respond returns the result of a function as success payload, or its error
+// (see package errcode)
+func respond(result string, err error) peer.Response {
 	if err != nil {
-		return shim.Error(err.Error())
+		return errcode.Response(err)
 	}
-
-	// Return the result as success payload
 	return shim.Success([]byte(result))
 }
 
+// pageSizeOf returns a page size as the int32 expected by the paginated query
+// APIs
+func pageSizeOf(pageSize int) (int32, error) {
+	if pageSize <= 0 || pageSize > math.MaxInt32 {
+		return 0, errcode.InvalidArgument("Page size must be a positive 32-bit integer, got %d", pageSize)
+	}
+	return int32(pageSize), nil
+}
+
 // Set stores the asset (both key and value) on the ledger. If the key exists,
-// it will override the value with the new one
-func set(stub shim.ChaincodeStubInterface, args []string) (string, error) {
-	if len(args) != 2 {
-		return "", fmt.Errorf("Incorrect arguments. Expecting a key and a value")
+// it will override the value with the new one and drop any TTL
+func set(stub shim.ChaincodeStubInterface, key, value string) (string, error) {
+	if _, err := putAsset(stub, key, value); err != nil {
+		return "", err
 	}
+	if err := clearExpiry(stub, key); err != nil {
+		return "", err
+	}
+	return value, nil
+}
 
-	err := stub.PutState(args[0], []byte(args[1]))
+// putAsset writes the text value of an asset and records the write in its
+// metadata, see sacc_meta.go
+func putAsset(stub shim.ChaincodeStubInterface, key, value string) (*assetMeta, error) {
+	return putContent(stub, key, []byte(value), textContentType)
+}
+
+// Get returns the value of the specified asset key. Expired keys are not
+// found.
+func get(stub shim.ChaincodeStubInterface, key string) (string, error) {
+	value, err := stub.GetState(key)
 	if err != nil {
-		return "", fmt.Errorf("Failed to set asset: %s", args[0])
+		return "", fmt.Errorf("Failed to get asset: %s with error: %s", key, err)
+	}
+	if value == nil {
+		return "", errcode.NotFound("Asset not found: %s", key).With("key", key)
+	}
+	expired, err := isExpired(stub, key)
+	if err != nil {
+		return "", err
+	}
+	if expired {
+		return "", errcode.NotFound("Asset not found: %s", key).With("key", key)
+	}
+	return string(value), nil
+}
+
+// del removes the specified asset key from the ledger
+func del(stub shim.ChaincodeStubInterface, key string) (string, error) {
+	if _, err := get(stub, key); err != nil {
+		return "", err
+	}
+	// The tombstone is written first, as it keeps the version of the value
+	if err := delMeta(stub, key); err != nil {
+		return "", err
+	}
+	if err := stub.DelState(key); err != nil {
+		return "", fmt.Errorf("Failed to delete asset: %s with error: %s", key, err)
+	}
+	if err := clearExpiry(stub, key); err != nil {
+		return "", err
 	}
-	return args[1], nil
+	return key, nil
 }
 
-// Get returns the value of the specified asset key
-func get(stub shim.ChaincodeStubInterface, args []string) (string, error) {
-	if len(args) != 1 {
-		return "", fmt.Errorf("Incorrect arguments. Expecting a key")
+// setIfAbsent stores the asset only if the key does not exist yet, or has
+// expired
+func setIfAbsent(stub shim.ChaincodeStubInterface, key, value string) (string, error) {
+	_, err := get(stub, key)
+	if err == nil {
+		return "", errcode.AlreadyExists("Asset already exists: %s", key).With("key", key)
 	}
+	if errcode.CodeOf(err) != errcode.CodeNotFound {
+		return "", err
+	}
+	return set(stub, key, value)
+}
 
-	value, err := stub.GetState(args[0])
+// compareAndSet replaces the value of the asset with newValue only if its
+// current value is expected. Clients read the value, compute the new one
+// and use compareAndSet to make sure nobody changed it in between.
+func compareAndSet(stub shim.ChaincodeStubInterface, key, expected, newValue string) (string, error) {
+	current, err := get(stub, key)
 	if err != nil {
-		return "", fmt.Errorf("Failed to get asset: %s with error: %s", args[0], err)
+		return "", err
 	}
-	if value == nil {
-		return "", fmt.Errorf("Asset not found: %s", args[0])
+	if current != expected {
+		return "", errcode.Conflict("Asset %s does not have the expected value", key).
+			With("key", key).With("current", current)
 	}
-	return string(value), nil
+	return set(stub, key, newValue)
+}
+
+// kvRecord is a key and its value as returned by list. Values that are not
+// valid UTF-8 are base64 encoded.
+type kvRecord struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// responseMetadata tells the client how many records a page holds and how
+// to request the next page
+type responseMetadata struct {
+	RecordsCount int32  `json:"RecordsCount"`
+	Bookmark     string `json:"Bookmark"`
+}
+
+// listResponse is a page of keys along with the bookmark of the next page
+type listResponse struct {
+	Records          []kvRecord       `json:"Records"`
+	ResponseMetadata responseMetadata `json:"ResponseMetadata"`
+}
+
+// list returns one page of the assets whose key starts with prefix, in key
+// order. An empty prefix lists every asset. Expired keys are left out, so a
+// page may hold fewer records than RecordsCount.
+func list(stub shim.ChaincodeStubInterface, prefix string, pageSize int, bookmark string) (string, error) {
+	size, err := pageSizeOf(pageSize)
+	if err != nil {
+		return "", err
+	}
+
+	// keys are UTF-8 strings, so no key with the prefix sorts after prefix+MaxRune
+	endKey := ""
+	if len(prefix) > 0 {
+		endKey = prefix + string(utf8.MaxRune)
+	}
+	iterator, metadata, err := stub.GetStateByRangeWithPagination(prefix, endKey, size, bookmark)
+	if err != nil {
+		return "", fmt.Errorf("Failed to list assets: %s", err)
+	}
+	defer iterator.Close()
+
+	response := listResponse{Records: []kvRecord{}}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("Failed to list assets: %s", err)
+		}
+		expired, err := isExpired(stub, kv.Key)
+		if err != nil {
+			return "", err
+		}
+		if expired {
+			continue
+		}
+		value, encoding := encodeValue(kv.Value)
+		response.Records = append(response.Records, kvRecord{Key: kv.Key, Value: value, Encoding: encoding})
+	}
+	if metadata != nil {
+		response.ResponseMetadata.RecordsCount = metadata.FetchedRecordsCount
+		response.ResponseMetadata.Bookmark = metadata.Bookmark
+	}
+	result, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// stateRecord is one line of an export. Exports are newline-delimited JSON
+// (NDJSON), one record per line, where value is the asset value as a JSON string,
+// base64 encoded if encoding says so, and expires, set for keys with a TTL, is
+// the expiry in Unix seconds. Off-chain references carry the reference instead
+// of a value:
+//
+//	{"key":"a","docType":"asset","value":"10"}
+//	{"key":"session1","docType":"asset","value":"x","expires":1546300800}
+//	{"key":"logo","docType":"asset","value":"iVBORw0KGgo=","encoding":"base64","contentType":"image/png"}
+//	{"key":"scan","docType":"asset","contentType":"application/pdf","reference":{"sha256":"...","uri":"https://..."}}
+type stateRecord struct {
+	Key         string             `json:"key"`
+	DocType     string             `json:"docType"`
+	Value       string             `json:"value,omitempty"`
+	Encoding    string             `json:"encoding,omitempty"`
+	ContentType string             `json:"contentType,omitempty"`
+	Reference   *offChainReference `json:"reference,omitempty"`
+	Expires     int64              `json:"expires,omitempty"`
+}
+
+// exportResponse is a page of exported records along with the bookmark of
+// the next page
+type exportResponse struct {
+	Records          string           `json:"Records"`
+	ResponseMetadata responseMetadata `json:"ResponseMetadata"`
+}
+
+// exportState returns one page of the assets in the key range
+// [startKey, endKey) as NDJSON. The Records string of the response can be
+// passed to importState as is. Expired keys are left out.
+func exportState(stub shim.ChaincodeStubInterface, startKey, endKey string, pageSize int, bookmark string) (string, error) {
+	size, err := pageSizeOf(pageSize)
+	if err != nil {
+		return "", err
+	}
+
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return "", err
+	}
+
+	iterator, metadata, err := stub.GetStateByRangeWithPagination(startKey, endKey, size, bookmark)
+	if err != nil {
+		return "", fmt.Errorf("Failed to export assets: %s", err)
+	}
+	defer iterator.Close()
+
+	var buffer bytes.Buffer
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("Failed to export assets: %s", err)
+		}
+		expires, err := getExpiry(stub, kv.Key)
+		if err != nil {
+			return "", err
+		}
+		if expires != 0 && expires <= now {
+			continue
+		}
+		meta, err := getMeta(stub, kv.Key)
+		if err != nil {
+			return "", err
+		}
+		record := stateRecord{Key: kv.Key, DocType: "asset", ContentType: meta.ContentType, Expires: expires}
+		if len(meta.URI) > 0 {
+			record.Reference = &offChainReference{SHA256: meta.SHA256, URI: meta.URI, Size: meta.Size}
+		} else {
+			record.Value, record.Encoding = encodeValue(kv.Value)
+		}
+		recordJSONasBytes, err := json.Marshal(record)
+		if err != nil {
+			return "", err
+		}
+		buffer.Write(recordJSONasBytes)
+		buffer.WriteString("\n")
+	}
+
+	response := exportResponse{Records: buffer.String()}
+	if metadata != nil {
+		response.ResponseMetadata.RecordsCount = metadata.FetchedRecordsCount
+		response.ResponseMetadata.Bookmark = metadata.Bookmark
+	}
+	result, err := json.Marshal(response)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// importState writes the assets of an export in a single transaction. The
+// import fails if a record would overwrite an existing asset, unless the
+// force flag is set.
+func importState(stub shim.ChaincodeStubInterface, records string, force bool) (string, error) {
+	// Validate every record before writing any of them
+	var imported []stateRecord
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(records, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var record stateRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return "", errcode.InvalidArgument("Line %d: failed to decode record: %s", i+1, err).With("line", i+1)
+		}
+		if record.DocType != "asset" || len(record.Key) == 0 {
+			return "", errcode.InvalidArgument("Line %d: expecting an asset record with a key", i+1).With("line", i+1)
+		}
+		if seen[record.Key] {
+			return "", errcode.InvalidArgument("Line %d: %s appears more than once", i+1, record.Key).With("line", i+1)
+		}
+		seen[record.Key] = true
+
+		if record.Expires < 0 {
+			return "", errcode.InvalidArgument("Line %d: expires must not be negative", i+1).With("line", i+1)
+		}
+		if record.Reference == nil {
+			if _, err := decodeData(record.Value, record.Encoding); err != nil {
+				return "", errcode.Wrap(err, "Line %d", i+1).With("line", i+1)
+			}
+		} else if len(record.Reference.SHA256) == 0 || len(record.Reference.URI) == 0 {
+			return "", errcode.InvalidArgument("Line %d: reference must have a sha256 and a uri", i+1).With("line", i+1)
+		}
+
+		if !force {
+			_, err := get(stub, record.Key)
+			if err != nil && errcode.CodeOf(err) != errcode.CodeNotFound {
+				return "", err
+			}
+			if err == nil {
+				return "", errcode.AlreadyExists("Line %d: %s already exists, import with force to overwrite it", i+1, record.Key).
+					With("line", i+1).With("key", record.Key)
+			}
+		}
+		imported = append(imported, record)
+	}
+	if len(imported) == 0 {
+		return "", errcode.InvalidArgument("No records to import")
+	}
+
+	for _, record := range imported {
+		contentType := record.ContentType
+		if len(contentType) == 0 {
+			contentType = textContentType
+		}
+		var err error
+		if record.Reference != nil {
+			_, err = putReference(stub, record.Key, *record.Reference, contentType)
+		} else {
+			value, _ := decodeData(record.Value, record.Encoding)
+			_, err = putContent(stub, record.Key, value, contentType)
+		}
+		if err != nil {
+			return "", err
+		}
+		if record.Expires > 0 {
+			err = putExpiry(stub, record.Key, record.Expires)
+		} else {
+			err = clearExpiry(stub, record.Key)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("{\"imported\":%d}", len(imported)), nil
 }
 
 // main function starts up the chaincode in the container during instantiate
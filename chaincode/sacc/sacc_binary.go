@@ -0,0 +1,234 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Values are stored as raw bytes together with their content type, size and
+// SHA-256 in the metadata of the key (see sacc_meta.go). Chaincode arguments
+// are byte arrays and reach the functions unchanged, so setBinary can take
+// the bytes as they are; clients that cannot send arbitrary bytes pass them
+// base64 encoded instead. get returns the bytes as the response payload,
+// while functions returning JSON base64 encode values that are not valid
+// UTF-8 and say so with "encoding":"base64".
+//
+// Values larger than maxValueSize bytes, which Init takes as an optional
+// third argument, are rejected. Such payloads can be kept off-chain:
+// setReference stores only their SHA-256 and URI, and verify checks a copy
+// of the data against the stored hash.
+const (
+	encodingRaw    = "raw"
+	encodingBase64 = "base64"
+
+	// textContentType is the content type of values stored with set
+	textContentType = "text/plain; charset=utf-8"
+
+	configObjectType      = "config"
+	maxValueSizeConfigKey = "maxValueSize"
+	defaultMaxValueSize   = 1 << 20
+)
+
+// offChainReference is stored as the value of a key set with setReference
+type offChainReference struct {
+	SHA256 string `json:"sha256"`
+	URI    string `json:"uri"`
+	Size   int    `json:"size,omitempty"`
+}
+
+// verifyResult is returned by verify
+type verifyResult struct {
+	Key       string `json:"key"`
+	Valid     bool   `json:"valid"`
+	SHA256    string `json:"sha256"`
+	Submitted string `json:"submitted"`
+}
+
+// setBinary stores data, raw or base64 encoded, with the given content type
+func setBinary(stub shim.ChaincodeStubInterface, key, data, contentType, encoding string) (string, error) {
+	if len(contentType) == 0 {
+		return "", errcode.InvalidArgument("Content type must be a non-empty string")
+	}
+	value, err := decodeData(data, encoding)
+	if err != nil {
+		return "", err
+	}
+	meta, err := putContent(stub, key, value, contentType)
+	if err != nil {
+		return "", err
+	}
+	if err := clearExpiry(stub, key); err != nil {
+		return "", err
+	}
+	return marshalAssetWithMeta(key, nil, meta)
+}
+
+// setReference stores a reference to data kept off-chain: its SHA-256 as a
+// hex string, the URI it can be fetched from and, optionally, its size
+func setReference(stub shim.ChaincodeStubInterface, key, hash, uri, contentType string, size int) (string, error) {
+	digest, err := hex.DecodeString(hash)
+	if err != nil || len(digest) != sha256.Size {
+		return "", errcode.InvalidArgument("SHA-256 must be %d hex characters", 2*sha256.Size)
+	}
+	parsed, err := url.Parse(uri)
+	if err != nil || len(parsed.Scheme) == 0 {
+		return "", errcode.InvalidArgument("URI must be an absolute URI, got '%s'", uri)
+	}
+	if len(contentType) == 0 {
+		return "", errcode.InvalidArgument("Content type must be a non-empty string")
+	}
+	if size < 0 {
+		return "", errcode.InvalidArgument("Size must not be negative")
+	}
+
+	meta, err := putReference(stub, key, offChainReference{SHA256: hex.EncodeToString(digest), URI: uri, Size: size}, contentType)
+	if err != nil {
+		return "", err
+	}
+	if err := clearExpiry(stub, key); err != nil {
+		return "", err
+	}
+	return marshalAssetWithMeta(key, nil, meta)
+}
+
+// verify checks data, raw or base64 encoded, against the SHA-256 stored for
+// the key. For off-chain references this is the hash of the data at the URI.
+func verify(stub shim.ChaincodeStubInterface, key, data, encoding string) (string, error) {
+	value, err := get(stub, key)
+	if err != nil {
+		return "", err
+	}
+	submitted, err := decodeData(data, encoding)
+	if err != nil {
+		return "", err
+	}
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return "", err
+	}
+	expected := meta.SHA256
+	if len(expected) == 0 {
+		// written before hashes were recorded
+		expected = sha256Hex([]byte(value))
+	}
+
+	result := verifyResult{Key: key, SHA256: expected, Submitted: sha256Hex(submitted)}
+	result.Valid = result.Submitted == expected
+	resultJSONasBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(resultJSONasBytes), nil
+}
+
+// putContent writes a value stored on-chain and records its content type,
+// size and hash. Values larger than the configured maximum are rejected.
+func putContent(stub shim.ChaincodeStubInterface, key string, value []byte, contentType string) (*assetMeta, error) {
+	maxSize, err := getMaxValueSize(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > maxSize {
+		return nil, errcode.InvalidArgument("Value of %s is %d bytes, the maximum is %d, use setReference to store it off-chain", key, len(value), maxSize).
+			With("size", len(value)).With("maxValueSize", maxSize)
+	}
+
+	// The metadata is written first, as it looks at the value before the write
+	meta, err := putMeta(stub, key, assetMeta{ContentType: contentType, Size: len(value), SHA256: sha256Hex(value)})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, value)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set asset: %s", key)
+	}
+	return meta, nil
+}
+
+// putReference writes a reference to off-chain data as the value of a key
+func putReference(stub shim.ChaincodeStubInterface, key string, ref offChainReference, contentType string) (*assetMeta, error) {
+	refJSONasBytes, err := json.Marshal(ref)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := putMeta(stub, key, assetMeta{ContentType: contentType, Size: ref.Size, SHA256: ref.SHA256, URI: ref.URI})
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(key, refJSONasBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set asset: %s", key)
+	}
+	return meta, nil
+}
+
+// getMaxValueSize returns the maximum size of a value stored on-chain
+func getMaxValueSize(stub shim.ChaincodeStubInterface) (int, error) {
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{maxValueSizeConfigKey})
+	if err != nil {
+		return 0, err
+	}
+	maxSizeAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get %s: %s", maxValueSizeConfigKey, err)
+	}
+	if maxSizeAsBytes == nil {
+		return defaultMaxValueSize, nil
+	}
+	return strconv.Atoi(string(maxSizeAsBytes))
+}
+
+// putMaxValueSize sets the maximum size of a value stored on-chain
+func putMaxValueSize(stub shim.ChaincodeStubInterface, maxSize string) error {
+	n, err := strconv.Atoi(maxSize)
+	if err != nil || n <= 0 {
+		return errcode.InvalidArgument("%s must be a positive integer, got '%s'", maxValueSizeConfigKey, maxSize)
+	}
+	configKey, err := stub.CreateCompositeKey(configObjectType, []string{maxValueSizeConfigKey})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(configKey, []byte(strconv.Itoa(n)))
+}
+
+// decodeData decodes data passed raw or base64 encoded
+func decodeData(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", encodingRaw:
+		return []byte(data), nil
+	case encodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, errcode.InvalidArgument("Data is not valid base64: %s", err)
+		}
+		return decoded, nil
+	}
+	return nil, errcode.InvalidArgument("Encoding must be '%s' or '%s', got '%s'", encodingRaw, encodingBase64, encoding)
+}
+
+// encodeValue returns a value as a string that survives JSON encoding, and
+// the encoding used
+func encodeValue(value []byte) (string, string) {
+	if utf8.Valid(value) {
+		return string(value), ""
+	}
+	return base64.StdEncoding.EncodeToString(value), encodingBase64
+}
+
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,202 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Every write of an asset records its metadata under the composite key
+// (meta, key), next to the value itself, so values are stored as they are. The
+// version starts at 1 and goes up by one with every write. Values written
+// before versioning was introduced have no metadata and count as version 1.
+// Deleting a key, or sweeping it once it expired, leaves a tombstone holding
+// its last version, so a key that is set again goes on from that version and
+// a client holding a version from before the delete cannot overwrite it.
+const metaObjectType = "meta"
+
+// assetMeta describes the last write of an asset and its content, see
+// sacc_binary.go
+type assetMeta struct {
+	Version     int    `json:"version"`
+	Writer      string `json:"writer"`
+	TxID        string `json:"txId"`
+	Timestamp   string `json:"timestamp"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256,omitempty"`
+	URI         string `json:"uri,omitempty"` // set for off-chain references
+}
+
+// assetWithMeta is an asset along with its metadata, as returned by
+// getWithMeta and setIfVersion
+type assetWithMeta struct {
+	Key      string  `json:"key"`
+	Value    *string `json:"value,omitempty"`
+	Encoding string  `json:"encoding,omitempty"`
+	assetMeta
+}
+
+// getWithMeta returns the value of an asset with its version, last writer,
+// last transaction ID and the timestamp of that transaction
+func getWithMeta(stub shim.ChaincodeStubInterface, key string) (string, error) {
+	value, err := get(stub, key)
+	if err != nil {
+		return "", err
+	}
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return "", err
+	}
+	return marshalAssetWithMeta(key, []byte(value), meta)
+}
+
+// setIfVersion stores the asset only if its current version is
+// expectedVersion. A key that does not exist, has been deleted or has expired
+// has version 0, so an expected version of 0 creates the key.
+func setIfVersion(stub shim.ChaincodeStubInterface, key, value string, expectedVersion int) (string, error) {
+	current := 0
+	_, err := get(stub, key)
+	if err == nil {
+		meta, err := getMeta(stub, key)
+		if err != nil {
+			return "", err
+		}
+		current = meta.Version
+	} else if errcode.CodeOf(err) != errcode.CodeNotFound {
+		return "", err
+	}
+	if current != expectedVersion {
+		return "", errcode.Conflict("Asset %s is at version %d, expected version %d", key, current, expectedVersion).
+			With("key", key).With("version", current).With("expectedVersion", expectedVersion)
+	}
+
+	meta, err := putAsset(stub, key, value)
+	if err != nil {
+		return "", err
+	}
+	if err := clearExpiry(stub, key); err != nil {
+		return "", err
+	}
+	return marshalAssetWithMeta(key, []byte(value), meta)
+}
+
+// getMeta returns the metadata of an asset. An asset without metadata has
+// version 1 if it has a value, written before versioning was introduced, and
+// version 0 otherwise. The metadata of a deleted asset is its tombstone.
+func getMeta(stub shim.ChaincodeStubInterface, key string) (*assetMeta, error) {
+	metaKey, err := stub.CreateCompositeKey(metaObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	metaAsBytes, err := stub.GetState(metaKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get metadata of asset: %s with error: %s", key, err)
+	}
+	meta := &assetMeta{}
+	if metaAsBytes == nil {
+		value, err := stub.GetState(key)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get asset: %s with error: %s", key, err)
+		}
+		if value != nil {
+			meta.Version = 1
+		}
+		return meta, nil
+	}
+	err = json.Unmarshal(metaAsBytes, meta)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// putMeta records a write of an asset by the current transaction and
+// returns the new metadata. The content fields are taken from content. It is
+// called before the value is written, so that getMeta sees the value as it
+// was. The version goes on from the last one, even if the key was deleted or
+// has expired.
+func putMeta(stub shim.ChaincodeStubInterface, key string, content assetMeta) (*assetMeta, error) {
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, err
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	meta.Version++
+	meta.Writer = mspID + "::" + id
+	meta.TxID = stub.GetTxID()
+	meta.Timestamp = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
+	meta.ContentType = content.ContentType
+	meta.Size = content.Size
+	meta.SHA256 = content.SHA256
+	meta.URI = content.URI
+
+	metaKey, err := stub.CreateCompositeKey(metaObjectType, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	metaAsBytes, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(metaKey, metaAsBytes)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// delMeta replaces the metadata of a deleted asset by a tombstone that only
+// keeps its version
+func delMeta(stub shim.ChaincodeStubInterface, key string) error {
+	meta, err := getMeta(stub, key)
+	if err != nil {
+		return err
+	}
+	metaKey, err := stub.CreateCompositeKey(metaObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	tombstoneAsBytes, err := json.Marshal(assetMeta{Version: meta.Version})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(metaKey, tombstoneAsBytes)
+}
+
+// marshalAssetWithMeta returns an asset with its metadata as JSON. The value
+// is left out if it is nil.
+func marshalAssetWithMeta(key string, value []byte, meta *assetMeta) (string, error) {
+	asset := assetWithMeta{Key: key, assetMeta: *meta}
+	if value != nil {
+		encoded, encoding := encodeValue(value)
+		asset.Value = &encoded
+		asset.Encoding = encoding
+	}
+	result, err := json.Marshal(asset)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
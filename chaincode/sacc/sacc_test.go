@@ -0,0 +1,335 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var (
+	alice = cctest.NewClient("Org1MSP", "alice")
+	bob   = cctest.NewClient("Org2MSP", "bob")
+)
+
+// newStub returns a stub holding the asset a, set by alice in Init
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("sacc", new(SimpleAsset))
+	if res := stub.As(alice).Init("a", "10"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+func TestSetGetAndDel(t *testing.T) {
+	stub := newStub(t)
+
+	if value := string(cctest.Invoke(t, stub, "get", "a")); value != "10" {
+		t.Errorf("a is %s", value)
+	}
+	cctest.Invoke(t, stub, "set", "a", "20")
+	if value := string(cctest.Invoke(t, stub, "get", "a")); value != "20" {
+		t.Errorf("a is %s after set", value)
+	}
+	cctest.Invoke(t, stub, "del", "a")
+	cctest.AssertCode(t, stub.Invoke("get", "a"), errcode.CodeNotFound, "getting a deleted asset")
+	cctest.AssertCode(t, stub.Invoke("del", "a"), errcode.CodeNotFound, "deleting a missing asset")
+	cctest.AssertCode(t, stub.Invoke("put", "a", "1"), errcode.CodeInvalidArgument, "an unknown function")
+	cctest.AssertCode(t, stub.Invoke("set", "a"), errcode.CodeInvalidArgument, "set without a value")
+}
+
+func TestConditionalWrites(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.AssertCode(t, stub.Invoke("setIfAbsent", "a", "1"), errcode.CodeAlreadyExists, "setIfAbsent of an existing asset")
+	cctest.Invoke(t, stub, "setIfAbsent", "b", "1")
+
+	e := cctest.AssertCode(t, stub.Invoke("compareAndSet", "a", "11", "12"), errcode.CodeConflict, "compareAndSet with a stale value")
+	if e != nil && e.Details["current"] != "10" {
+		t.Errorf("the conflict reports %v", e.Details)
+	}
+	cctest.AssertCode(t, stub.Invoke("compareAndSet", "c", "", "1"), errcode.CodeNotFound, "compareAndSet of a missing asset")
+	cctest.Invoke(t, stub, "compareAndSet", "a", "10", "11")
+	if value := string(stub.State["a"]); value != "11" {
+		t.Errorf("a is %s after compareAndSet", value)
+	}
+}
+
+func TestList(t *testing.T) {
+	stub := newStub(t)
+	for _, key := range []string{"car1", "car2", "car3", "cat"} {
+		cctest.Invoke(t, stub, "set", key, key+"-value")
+	}
+
+	response := listResponse{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "list", "car", "2"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Records) != 2 || response.Records[0].Key != "car1" || response.Records[1].Value != "car2-value" {
+		t.Errorf("the first page is %+v", response.Records)
+	}
+	if response.ResponseMetadata.Bookmark != "car3" {
+		t.Fatalf("the bookmark is %q", response.ResponseMetadata.Bookmark)
+	}
+	response = listResponse{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "list", "car", "2", "car3"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Records) != 1 || response.Records[0].Key != "car3" || len(response.ResponseMetadata.Bookmark) != 0 {
+		t.Errorf("the last page is %+v", response)
+	}
+
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "list", "", "10"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Records) != 5 {
+		t.Errorf("listed %d assets, want 5", len(response.Records))
+	}
+	cctest.AssertCode(t, stub.Invoke("list", "car", "0"), errcode.CodeInvalidArgument, "a page of no assets")
+	cctest.AssertCode(t, stub.Invoke("list", "car", "4294967297"), errcode.CodeInvalidArgument, "a page size beyond 32 bits")
+}
+
+func TestExportAndImport(t *testing.T) {
+	stub := newStub(t)
+	cctest.Invoke(t, stub, "set", "b", "20")
+
+	for _, pageSize := range []string{"0", "-1", "2147483648"} {
+		cctest.AssertCode(t, stub.Invoke("exportState", "a", "z", pageSize, ""), errcode.CodeInvalidArgument, "exporting pages of "+pageSize)
+	}
+	page := exportResponse{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "exportState", "a", "z", "10", ""), &page); err != nil {
+		t.Fatal(err)
+	}
+
+	empty := cctest.NewStub("sacc", new(SimpleAsset)).As(alice)
+	cctest.Invoke(t, empty, "importState", page.Records)
+	if value := string(cctest.Invoke(t, empty, "get", "b")); value != "20" {
+		t.Errorf("b was imported as %s", value)
+	}
+	cctest.AssertCode(t, empty.Invoke("importState", page.Records), errcode.CodeAlreadyExists, "importing existing assets")
+}
+
+func TestTTL(t *testing.T) {
+	stub := newStub(t)
+	now := time.Now()
+	stub.At(now)
+
+	cctest.Invoke(t, stub, "setWithTTL", "session", "x", "60")
+	cctest.Invoke(t, stub, "setWithTTL", "a", "11", "60")
+	cctest.Invoke(t, stub, "set", "a", "12")
+	if value := string(cctest.Invoke(t, stub, "get", "session")); value != "x" {
+		t.Errorf("session is %s before it expires", value)
+	}
+	cctest.AssertCode(t, stub.Invoke("setWithTTL", "b", "1", "0"), errcode.CodeInvalidArgument, "a TTL of 0")
+	cctest.AssertCode(t, stub.Invoke("setWithTTL", "b", "1", "9223372036854775807"), errcode.CodeInvalidArgument, "a TTL that overflows the expiry")
+	cctest.AssertCode(t, stub.Invoke("setWithTTL", "b", "1", strconv.FormatInt(maxExpiry-now.Unix()+1, 10)), errcode.CodeInvalidArgument, "a TTL past the year 9999")
+
+	stub.At(now.Add(time.Minute))
+	cctest.AssertCode(t, stub.Invoke("get", "session"), errcode.CodeNotFound, "getting an expired asset")
+	if value := string(cctest.Invoke(t, stub, "get", "a")); value != "12" {
+		t.Errorf("set did not drop the TTL of a, which is %s", value)
+	}
+	response := listResponse{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "list", "", "10"), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Records) != 1 || response.Records[0].Key != "a" {
+		t.Errorf("listed %+v", response.Records)
+	}
+
+	// An expired key may be set again
+	cctest.Invoke(t, stub, "setIfAbsent", "session", "y")
+	if value := string(cctest.Invoke(t, stub, "get", "session")); value != "y" {
+		t.Errorf("session is %s after setIfAbsent", value)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	stub := newStub(t)
+	now := time.Now()
+	stub.At(now)
+	for i, key := range []string{"s1", "s2", "s3"} {
+		cctest.Invoke(t, stub, "setWithTTL", key, "x", strconv.Itoa(10*(i+1)))
+	}
+	cctest.Invoke(t, stub, "setWithTTL", "s4", "x", "3600")
+
+	stub.At(now.Add(time.Minute))
+	if res := string(cctest.Invoke(t, stub, "sweepExpired", "2")); res != `{"swept":2,"more":true}` {
+		t.Errorf("the first sweep returned %s", res)
+	}
+	if res := string(cctest.Invoke(t, stub, "sweepExpired", "2")); res != `{"swept":1,"more":false}` {
+		t.Errorf("the second sweep returned %s", res)
+	}
+	for _, key := range []string{"s1", "s2", "s3"} {
+		if stub.State[key] != nil {
+			t.Errorf("%s was not swept", key)
+		}
+	}
+	if stub.State["s4"] == nil || stub.State["a"] == nil {
+		t.Error("the sweep deleted an asset that has not expired")
+	}
+	// Only the TTL entries of s4 remain
+	ttlKeys := 0
+	for key := range stub.State {
+		if strings.HasPrefix(key, "\x00"+ttlObjectType+"\x00") || strings.HasPrefix(key, "\x00"+expiryKeyIndex+"\x00") {
+			ttlKeys++
+		}
+	}
+	if ttlKeys != 2 {
+		t.Errorf("%d TTL entries remain, want 2", ttlKeys)
+	}
+}
+
+// readWithMeta returns an asset with its metadata
+func readWithMeta(t *testing.T, stub *cctest.Stub, key string) assetWithMeta {
+	asset := assetWithMeta{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "getWithMeta", key), &asset); err != nil {
+		t.Fatal(err)
+	}
+	return asset
+}
+
+func TestVersions(t *testing.T) {
+	stub := newStub(t)
+
+	asset := readWithMeta(t, stub, "a")
+	if asset.Value == nil || *asset.Value != "10" || asset.Version != 1 || asset.Writer != alice.Member() || len(asset.TxID) == 0 || len(asset.Timestamp) == 0 {
+		t.Errorf("Init wrote %+v", asset)
+	}
+	cctest.Invoke(t, stub.As(bob), "set", "a", "11")
+	if asset = readWithMeta(t, stub, "a"); asset.Version != 2 || asset.Writer != bob.Member() {
+		t.Errorf("set wrote %+v", asset)
+	}
+
+	e := cctest.AssertCode(t, stub.Invoke("setIfVersion", "a", "12", "1"), errcode.CodeConflict, "setIfVersion of a stale version")
+	if e != nil && e.Details["version"] != 2.0 {
+		t.Errorf("the conflict reports %v", e.Details)
+	}
+	cctest.Invoke(t, stub, "setIfVersion", "a", "12", "2")
+	if asset = readWithMeta(t, stub, "a"); asset.Value == nil || *asset.Value != "12" || asset.Version != 3 {
+		t.Errorf("setIfVersion wrote %+v", asset)
+	}
+
+	cctest.AssertCode(t, stub.Invoke("setIfVersion", "b", "1", "1"), errcode.CodeConflict, "setIfVersion of a missing key")
+	cctest.Invoke(t, stub, "setIfVersion", "b", "1", "0")
+	if asset = readWithMeta(t, stub, "b"); asset.Version != 1 {
+		t.Errorf("setIfVersion created %+v", asset)
+	}
+	cctest.AssertCode(t, stub.Invoke("getWithMeta", "c"), errcode.CodeNotFound, "getWithMeta of a missing key")
+}
+
+func TestVersionsSurviveDeletes(t *testing.T) {
+	stub := newStub(t)
+	now := time.Now()
+	stub.At(now)
+
+	// a is at version 2 when it is deleted and set again
+	cctest.Invoke(t, stub, "set", "a", "11")
+	cctest.Invoke(t, stub, "del", "a")
+	cctest.AssertCode(t, stub.Invoke("setIfVersion", "a", "12", "2"), errcode.CodeConflict, "setIfVersion of a deleted key")
+	cctest.Invoke(t, stub, "setIfVersion", "a", "12", "0")
+	if asset := readWithMeta(t, stub, "a"); asset.Version != 3 {
+		t.Errorf("a set again after a delete is %+v", asset)
+	}
+
+	cctest.Invoke(t, stub, "setWithTTL", "b", "1", "60")
+	stub.At(now.Add(time.Minute))
+	cctest.AssertCode(t, stub.Invoke("setIfVersion", "b", "2", "1"), errcode.CodeConflict, "setIfVersion of an expired key")
+	cctest.Invoke(t, stub, "set", "b", "2")
+	if asset := readWithMeta(t, stub, "b"); asset.Version != 2 {
+		t.Errorf("b set again after it expired is %+v", asset)
+	}
+	cctest.Invoke(t, stub, "setWithTTL", "b", "3", "60")
+	stub.At(now.Add(2 * time.Minute))
+	cctest.Invoke(t, stub, "sweepExpired", "10")
+	cctest.Invoke(t, stub, "set", "b", "4")
+	if asset := readWithMeta(t, stub, "b"); asset.Version != 4 {
+		t.Errorf("b set again after it was swept is %+v", asset)
+	}
+
+	// A value written before versioning has no metadata
+	stub.State["legacy"] = []byte("x")
+	if asset := readWithMeta(t, stub, "legacy"); asset.Version != 1 {
+		t.Errorf("a value without metadata is %+v", asset)
+	}
+	cctest.AssertCode(t, stub.Invoke("setIfVersion", "legacy", "y", "0"), errcode.CodeConflict, "setIfVersion 0 of a value without metadata")
+	cctest.Invoke(t, stub, "setIfVersion", "legacy", "y", "1")
+	if asset := readWithMeta(t, stub, "legacy"); asset.Version != 2 {
+		t.Errorf("a value without metadata set again is %+v", asset)
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	stub := cctest.NewStub("sacc", new(SimpleAsset)).As(alice)
+	if res := stub.Init("a", "10", "8"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+
+	data := []byte{0xff, 0x00, 0xfe}
+	cctest.Invoke(t, stub, "setBinary", "blob", base64.StdEncoding.EncodeToString(data), "application/octet-stream", "base64")
+	if value := cctest.Invoke(t, stub, "get", "blob"); !bytes.Equal(value, data) {
+		t.Errorf("get returned %x", value)
+	}
+	asset := readWithMeta(t, stub, "blob")
+	if asset.Value == nil || *asset.Value != base64.StdEncoding.EncodeToString(data) || asset.Encoding != "base64" ||
+		asset.ContentType != "application/octet-stream" || asset.Size != 3 || asset.SHA256 != sha256Hex(data) {
+		t.Errorf("getWithMeta returned %+v", asset)
+	}
+	if asset = readWithMeta(t, stub, "a"); asset.ContentType != textContentType || asset.Size != 2 {
+		t.Errorf("Init wrote %+v", asset)
+	}
+
+	e := cctest.AssertCode(t, stub.Invoke("setBinary", "big", "123456789", "text/plain"), errcode.CodeInvalidArgument, "a value larger than the maximum")
+	if e != nil && e.Details["maxValueSize"] != 8.0 {
+		t.Errorf("the error reports %v", e.Details)
+	}
+	cctest.AssertCode(t, stub.Invoke("setBinary", "b", "!", "text/plain", "base64"), errcode.CodeInvalidArgument, "data that is not base64")
+	cctest.AssertCode(t, stub.Invoke("setBinary", "b", "x", ""), errcode.CodeInvalidArgument, "an empty content type")
+	cctest.AssertCode(t, stub.Invoke("set", "a", "123456789"), errcode.CodeInvalidArgument, "set of a value larger than the maximum")
+}
+
+func TestReferencesAndVerify(t *testing.T) {
+	stub := newStub(t)
+
+	data := []byte("a large document kept off-chain")
+	hash := sha256Hex(data)
+	cctest.Invoke(t, stub, "setReference", "doc", hash, "https://example.com/doc", "text/plain", strconv.Itoa(len(data)))
+	if asset := readWithMeta(t, stub, "doc"); asset.URI != "https://example.com/doc" || asset.SHA256 != hash || asset.Size != len(data) {
+		t.Errorf("setReference wrote %+v", asset)
+	}
+
+	result := verifyResult{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "verify", "doc", string(data)), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.SHA256 != hash {
+		t.Errorf("verify of the data returned %+v", result)
+	}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "verify", "doc", "tampered"), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid {
+		t.Error("verify accepted other data")
+	}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "verify", "a", "10"), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid {
+		t.Errorf("verify of an on-chain value returned %+v", result)
+	}
+
+	cctest.AssertCode(t, stub.Invoke("setReference", "doc", "abcd", "https://example.com/doc", "text/plain"), errcode.CodeInvalidArgument, "a short hash")
+	cctest.AssertCode(t, stub.Invoke("setReference", "doc", hash, "doc", "text/plain"), errcode.CodeInvalidArgument, "a relative URI")
+	cctest.AssertCode(t, stub.Invoke("verify", "nope", "x"), errcode.CodeNotFound, "verify of a missing key")
+}
@@ -0,0 +1,204 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Keys set with setWithTTL expire a number of seconds after the timestamp of
+// the transaction that set them. Once expired they read as not found, and
+// sweepExpired eventually deletes them. Two composite keys track the expiry
+// of a key, both holding the expiry as Unix seconds:
+//
+//	ttl        key         -> expiry, to look up the expiry of a key
+//	expiry~key expiry, key -> 0x00, to find expired keys in expiry order
+//
+// Expiry times in the expiry~key index are zero padded, so the composite
+// keys sort by time.
+const (
+	ttlObjectType  = "ttl"
+	expiryKeyIndex = "expiry~key"
+)
+
+// maxExpiry is the latest expiry, the end of the year 9999, so that the
+// expiry of a key cannot overflow
+const maxExpiry = 253402300799
+
+// setWithTTL stores the asset and makes it expire after the given number of
+// seconds. Setting the key again, with or without a TTL, replaces the expiry.
+func setWithTTL(stub shim.ChaincodeStubInterface, key, value string, seconds int) (string, error) {
+	if seconds <= 0 {
+		return "", errcode.InvalidArgument("TTL must be a positive number of seconds")
+	}
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return "", err
+	}
+	if int64(seconds) > maxExpiry-now {
+		return "", errcode.InvalidArgument("TTL of %d seconds ends after the year 9999", seconds).With("ttl", seconds)
+	}
+	if _, err := putAsset(stub, key, value); err != nil {
+		return "", err
+	}
+	if err := putExpiry(stub, key, now+int64(seconds)); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// sweepExpired deletes up to pageSize expired assets, oldest first, and
+// reports how many were deleted and whether more expired assets remain.
+// Call it repeatedly until more is false.
+func sweepExpired(stub shim.ChaincodeStubInterface, pageSize int) (string, error) {
+	if pageSize <= 0 {
+		return "", errcode.InvalidArgument("Page size must be a positive integer")
+	}
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return "", err
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(expiryKeyIndex, []string{})
+	if err != nil {
+		return "", fmt.Errorf("Failed to read expiry index: %s", err)
+	}
+	defer iterator.Close()
+
+	swept := 0
+	more := false
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("Failed to read expiry index: %s", err)
+		}
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return "", err
+		}
+		expires, err := strconv.ParseInt(attributes[0], 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("Malformed expiry index entry for %s", attributes[1])
+		}
+		if expires > now {
+			// the index is in expiry order, nothing after this has expired
+			break
+		}
+		if swept == pageSize {
+			more = true
+			break
+		}
+
+		key := attributes[1]
+		if err := delMeta(stub, key); err != nil {
+			return "", err
+		}
+		if err := stub.DelState(key); err != nil {
+			return "", fmt.Errorf("Failed to delete asset: %s with error: %s", key, err)
+		}
+		if err := delExpiry(stub, key, expires); err != nil {
+			return "", err
+		}
+		swept++
+	}
+
+	fmt.Printf("- swept %d expired assets\n", swept)
+	return fmt.Sprintf("{\"swept\":%d,\"more\":%t}", swept, more), nil
+}
+
+// getExpiry returns the expiry of a key as Unix seconds, or 0 if the key
+// does not expire
+func getExpiry(stub shim.ChaincodeStubInterface, key string) (int64, error) {
+	ttlKey, err := stub.CreateCompositeKey(ttlObjectType, []string{key})
+	if err != nil {
+		return 0, err
+	}
+	expiresAsBytes, err := stub.GetState(ttlKey)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get expiry of asset: %s with error: %s", key, err)
+	}
+	if expiresAsBytes == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(expiresAsBytes), 10, 64)
+}
+
+// isExpired reports whether a key has a TTL that has run out at the time of
+// the transaction
+func isExpired(stub shim.ChaincodeStubInterface, key string) (bool, error) {
+	expires, err := getExpiry(stub, key)
+	if err != nil || expires == 0 {
+		return false, err
+	}
+	now, err := txTimeSeconds(stub)
+	if err != nil {
+		return false, err
+	}
+	return expires <= now, nil
+}
+
+// putExpiry sets the expiry of a key, replacing any previous one
+func putExpiry(stub shim.ChaincodeStubInterface, key string, expires int64) error {
+	if err := clearExpiry(stub, key); err != nil {
+		return err
+	}
+	ttlKey, err := stub.CreateCompositeKey(ttlObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := stub.PutState(ttlKey, []byte(strconv.FormatInt(expires, 10))); err != nil {
+		return err
+	}
+	indexKey, err := stub.CreateCompositeKey(expiryKeyIndex, []string{expiryAttribute(expires), key})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(indexKey, []byte{0x00})
+}
+
+// clearExpiry removes the expiry of a key, if it has one
+func clearExpiry(stub shim.ChaincodeStubInterface, key string) error {
+	expires, err := getExpiry(stub, key)
+	if err != nil || expires == 0 {
+		return err
+	}
+	return delExpiry(stub, key, expires)
+}
+
+// delExpiry deletes both composite keys tracking the expiry of a key
+func delExpiry(stub shim.ChaincodeStubInterface, key string, expires int64) error {
+	ttlKey, err := stub.CreateCompositeKey(ttlObjectType, []string{key})
+	if err != nil {
+		return err
+	}
+	if err := stub.DelState(ttlKey); err != nil {
+		return err
+	}
+	indexKey, err := stub.CreateCompositeKey(expiryKeyIndex, []string{expiryAttribute(expires), key})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(indexKey)
+}
+
+// expiryAttribute formats an expiry for the expiry~key index so that index
+// entries sort by time
+func expiryAttribute(expires int64) string {
+	return fmt.Sprintf("%020d", expires)
+}
+
+// txTimeSeconds returns the transaction timestamp set by the client as Unix
+// seconds
+func txTimeSeconds(stub shim.ChaincodeStubInterface) (int64, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, err
+	}
+	return ts.Seconds, nil
+}
@@ -0,0 +1,664 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+// A fungible token with an ERC-20 style API, grown from the integer balances of
+// chaincode_example02. chaincode_example02 itself is left as it is, since the
+// first-network scripts and chaincode_example04 depend on its functions.
+//
+// Accounts are client identities, written as MSPID::ID where ID is the value
+// returned by cid.GetID; whoAmI returns the invoker's. Amounts are non-negative
+// integers in the smallest unit of the token, passed and returned as decimal
+// strings: with 2 decimals, "150" is 1.50 tokens.
+//
+// Minters create and destroy tokens. The instantiator is the first minter and
+// admins grant the role to others with grantRole. Clients whose certificate has
+// token.minter=true are minters too, but only in the MSPs listed by the
+// optional fourth argument of init, since any CA on the channel can issue the
+// attribute.
+//
+//   peer chaincode instantiate -C mychannel -n token -v 1.0 -c '{"Args":["init","Sample Token","SMP","2","[\"Org1MSP\"]"]}'
+//   peer chaincode invoke -C mychannel -n token -c '{"Args":["mint","Org1MSP::<id>","10000"]}'
+//   peer chaincode invoke -C mychannel -n token -c '{"Args":["transfer","Org2MSP::<id>","150"]}'
+//   peer chaincode invoke -C mychannel -n token -c '{"Args":["approve","Org2MSP::<id>","500"]}'
+//   peer chaincode invoke -C mychannel -n token -c '{"Args":["transferFrom","Org1MSP::<id>","Org3MSP::<id>","200"]}'
+//   peer chaincode invoke -C mychannel -n token -c '{"Args":["burn","100"]}'
+//   peer chaincode query -C mychannel -n token -c '{"Args":["balanceOf","Org2MSP::<id>"]}'
+//   peer chaincode query -C mychannel -n token -c '{"Args":["allowance","Org1MSP::<id>","Org2MSP::<id>"]}'
+//   peer chaincode query -C mychannel -n token -c '{"Args":["totalSupply"]}'
+//   peer chaincode query -C mychannel -n token -c '{"Args":["verifySupply"]}'
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/identity"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/roles"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// TokenChaincode implements a fungible token
+type TokenChaincode struct {
+}
+
+// minterRole is the ledger role allowed to mint and burn tokens
+const minterRole = "minter"
+
+// minterAttribute makes a client a minter if its MSP is one of the minter MSPs
+const minterAttribute = "token.minter"
+
+// registry holds the ledger roles of the token
+var registry = roles.New().
+	Include(roles.AdminRole, minterRole)
+
+// State keys. Balances and allowances use composite keys, so they cannot clash
+// with the plain keys.
+const (
+	metadataKey    = "metadata"
+	totalSupplyKey = "totalSupply"
+	balanceIndex   = "balance"
+	allowanceIndex = "allowance"
+)
+
+// maxDecimals is the largest number of decimals a token may have
+const maxDecimals = 18
+
+// tokenMetadata describes the token and is set at Init
+type tokenMetadata struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+	// MinterMSPIDs are the MSPs whose clients with token.minter=true are minters
+	MinterMSPIDs []string `json:"minterMspIds,omitempty"`
+}
+
+// transferEvent is sent with every change of balances. From is empty for
+// mints and To is empty for burns.
+type transferEvent struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+// approvalEvent is sent when an owner sets the allowance of a spender
+type approvalEvent struct {
+	Owner   string `json:"owner"`
+	Spender string `json:"spender"`
+	Value   string `json:"value"`
+}
+
+// supplyReport is returned by verifySupply
+type supplyReport struct {
+	TotalSupply string `json:"totalSupply"`
+	Balances    string `json:"balances"`
+	Accounts    int    `json:"accounts"`
+}
+
+// Init creates the token with its name, symbol and decimals, and optionally the
+// JSON array of minter MSPs. The instantiator becomes the first minter, and its
+// MSP the admin MSP (see package roles). If the token already exists, as on an
+// upgrade, Init leaves it unchanged.
+func (t *TokenChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("token Init")
+	_, args := stub.GetFunctionAndParameters()
+
+	metadataJSONasBytes, err := stub.GetState(metadataKey)
+	if err != nil {
+		return errcode.Internal("Failed to get state").Response()
+	}
+	if metadataJSONasBytes != nil {
+		return shim.Success(nil)
+	}
+
+	if len(args) != 3 && len(args) != 4 {
+		return errcode.InvalidArgument("Incorrect number of arguments. Expecting 3 or 4: name, symbol, decimals and optionally the minter MSPs").Response()
+	}
+	metadata := tokenMetadata{Name: args[0], Symbol: args[1]}
+	if len(metadata.Name) == 0 || len(metadata.Symbol) == 0 {
+		return errcode.InvalidArgument("Name and symbol must be non-empty strings").Response()
+	}
+	metadata.Decimals, err = strconv.Atoi(args[2])
+	if err != nil || metadata.Decimals < 0 || metadata.Decimals > maxDecimals {
+		return errcode.InvalidArgument("Decimals must be an integer from 0 to %d", maxDecimals).With("decimals", args[2]).Response()
+	}
+	if len(args) == 4 {
+		err = json.Unmarshal([]byte(args[3]), &metadata.MinterMSPIDs)
+		if err != nil {
+			return errcode.InvalidArgument("The minter MSPs must be a JSON array of strings: %s", err).Response()
+		}
+		for _, mspID := range metadata.MinterMSPIDs {
+			if len(mspID) == 0 {
+				return errcode.InvalidArgument("Minter MSP IDs must be non-empty strings").Response()
+			}
+		}
+	}
+
+	metadataJSONasBytes, err = json.Marshal(metadata)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = stub.PutState(metadataKey, metadataJSONasBytes)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = stub.PutState(totalSupplyKey, []byte("0"))
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = registry.Init(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	minter, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	_, err = registry.Grant(stub, minter, minterRole)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	return shim.Success(nil)
+}
+
+func (t *TokenChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	fmt.Println("token Invoke")
+	return routes.Handle(stub)
+}
+
+// routes lists the functions of the chaincode and their parameters. The chaincode keeps no
+// state outside the ledger, so the router is built once.
+var routes = new(TokenChaincode).buildRoutes()
+
+func (t *TokenChaincode) buildRoutes() *router.Router {
+	amount := router.Required("amount", router.String).Describe("integer in the smallest unit")
+
+	return registry.AddRoutes(router.New("token", "1.0").
+		Add("metadata", "Returns the name, symbol and decimals of the token", t.metadata).
+		Add("totalSupply", "Returns the number of tokens in existence", t.totalSupply).
+		Add("balanceOf", "Returns the balance of an account, the invoker's by default", t.balanceOf,
+			router.Optional("account", router.String).Describe("MSPID::ID")).
+		Add("whoAmI", "Returns the account of the invoker", t.whoAmI).
+		Add("transfer", "Moves tokens from the invoker's account to another account", t.transfer,
+			router.Required("to", router.String).Describe("MSPID::ID"),
+			amount).
+		Add("approve", "Allows a spender to move up to an amount from the invoker's account", t.approve,
+			router.Required("spender", router.String).Describe("MSPID::ID"),
+			amount).
+		Add("allowance", "Returns how much a spender may still move from an owner's account", t.allowance,
+			router.Required("owner", router.String).Describe("MSPID::ID"),
+			router.Required("spender", router.String).Describe("MSPID::ID")).
+		Add("transferFrom", "Moves tokens from an account that approved the invoker to another account", t.transferFrom,
+			router.Required("from", router.String).Describe("MSPID::ID"),
+			router.Required("to", router.String).Describe("MSPID::ID"),
+			amount).
+		Add("mint", "Creates tokens in an account; minters only",
+			requireMinter(t.mint),
+			router.Required("to", router.String).Describe("MSPID::ID"),
+			amount).
+		Add("burn", "Destroys tokens from the invoker's account; minters only",
+			requireMinter(t.burn),
+			amount).
+		Add("verifySupply", "Checks that the balances of all accounts add up to the total supply", t.verifySupply))
+}
+
+// identities reads the minter attribute of the invoker
+var identities = identity.New(identity.DefaultNodeOUs)
+
+// requireMinter wraps a handler so that only minters may call it: clients of
+// the minter MSPs with token.minter=true, and members holding minterRole
+func requireMinter(h router.Handler) router.Handler {
+	requireRole := registry.Require("", minterRole, h)
+	return func(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+		if identities.AssertAttributeValue(stub, minterAttribute, "true") != nil {
+			return requireRole(stub, args)
+		}
+		metadata, err := getMetadata(stub)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		mspID, err := cid.GetMSPID(stub)
+		if err != nil {
+			return errcode.Unauthorized("Failed to get the MSP ID of the invoker: %s", err).Response()
+		}
+		for _, minterMSPID := range metadata.MinterMSPIDs {
+			if minterMSPID == mspID {
+				return h(stub, args)
+			}
+		}
+		return requireRole(stub, args)
+	}
+}
+
+// getMetadata returns the metadata set at Init
+func getMetadata(stub shim.ChaincodeStubInterface) (*tokenMetadata, error) {
+	metadataJSONasBytes, err := stub.GetState(metadataKey)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get state")
+	}
+	if metadataJSONasBytes == nil {
+		return nil, errcode.NotFound("The token has not been initialized")
+	}
+	metadata := &tokenMetadata{}
+	err = json.Unmarshal(metadataJSONasBytes, metadata)
+	if err != nil {
+		return nil, errcode.Internal("Failed to decode the token metadata: %s", err)
+	}
+	return metadata, nil
+}
+
+// metadata returns the name, symbol and decimals of the token
+func (t *TokenChaincode) metadata(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	metadataJSONasBytes, err := stub.GetState(metadataKey)
+	if err != nil {
+		return errcode.Internal("Failed to get state").Response()
+	}
+	if metadataJSONasBytes == nil {
+		return errcode.NotFound("The token has not been initialized").Response()
+	}
+	return shim.Success(metadataJSONasBytes)
+}
+
+// totalSupply returns the number of tokens in existence
+func (t *TokenChaincode) totalSupply(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	supply, err := getAmount(stub, totalSupplyKey)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(supply.String()))
+}
+
+// balanceOf returns the balance of an account
+func (t *TokenChaincode) balanceOf(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	account := args.String("account")
+	if !args.Has("account") {
+		invoker, err := roles.Member(stub)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		account = invoker
+	}
+	err := checkAccount(account)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	balance, err := getBalance(stub, account)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(balance.String()))
+}
+
+// whoAmI returns the account of the invoker, which is what others pass to
+// transfer and approve
+func (t *TokenChaincode) whoAmI(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	invoker, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(invoker))
+}
+
+// transfer moves tokens from the invoker's account
+func (t *TokenChaincode) transfer(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	from, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	to := args.String("to")
+	err = checkAccount(to)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	amount, err := parseAmount(args.String("amount"), true)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = move(stub, from, to, amount)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// approve sets how much a spender may move from the invoker's account,
+// replacing any previous allowance. An amount of 0 withdraws the allowance.
+func (t *TokenChaincode) approve(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	owner, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	spender := args.String("spender")
+	err = checkAccount(spender)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	amount, err := parseAmount(args.String("amount"), false)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	key, err := stub.CreateCompositeKey(allowanceIndex, []string{owner, spender})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putAmount(stub, key, amount)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = emit(stub, "Approval", approvalEvent{Owner: owner, Spender: spender, Value: amount.String()})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// allowance returns how much a spender may still move from an owner's account
+func (t *TokenChaincode) allowance(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	owner, spender := args.String("owner"), args.String("spender")
+	key, err := stub.CreateCompositeKey(allowanceIndex, []string{owner, spender})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	allowance, err := getAmount(stub, key)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success([]byte(allowance.String()))
+}
+
+// transferFrom moves tokens from an account that approved the invoker,
+// decreasing the invoker's allowance by the amount
+func (t *TokenChaincode) transferFrom(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	spender, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	from, to := args.String("from"), args.String("to")
+	err = checkAccount(from)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = checkAccount(to)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	amount, err := parseAmount(args.String("amount"), true)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	key, err := stub.CreateCompositeKey(allowanceIndex, []string{from, spender})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	allowance, err := getAmount(stub, key)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if allowance.Cmp(amount) < 0 {
+		return errcode.Unauthorized("Allowance of %s is %s, less than %s", spender, allowance, amount).
+			With("allowance", allowance.String()).With("amount", amount.String()).Response()
+	}
+
+	err = move(stub, from, to, amount)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putAmount(stub, key, allowance.Sub(allowance, amount))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// mint creates tokens in an account, increasing the total supply
+func (t *TokenChaincode) mint(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	to := args.String("to")
+	err := checkAccount(to)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	amount, err := parseAmount(args.String("amount"), true)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	supply, err := getAmount(stub, totalSupplyKey)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	balance, err := getBalance(stub, to)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	// The balance and the supply grow by the same amount
+	err = putBalance(stub, to, balance.Add(balance, amount))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putAmount(stub, totalSupplyKey, supply.Add(supply, amount))
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = emit(stub, "Transfer", transferEvent{To: to, Value: amount.String()})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// burn destroys tokens from the invoker's account, decreasing the total supply
+func (t *TokenChaincode) burn(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	from, err := roles.Member(stub)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	amount, err := parseAmount(args.String("amount"), true)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	supply, err := getAmount(stub, totalSupplyKey)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	balance, err := getBalance(stub, from)
+	if err != nil {
+		return errcode.Response(err)
+	}
+	if balance.Cmp(amount) < 0 {
+		return errcode.Conflict("Insufficient funds: balance is %s, less than %s", balance, amount).
+			With("balance", balance.String()).With("amount", amount.String()).Response()
+	}
+
+	// The balance and the supply shrink by the same amount
+	err = putBalance(stub, from, balance.Sub(balance, amount))
+	if err != nil {
+		return errcode.Response(err)
+	}
+	err = putAmount(stub, totalSupplyKey, supply.Sub(supply, amount))
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	err = emit(stub, "Transfer", transferEvent{From: from, Value: amount.String()})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(nil)
+}
+
+// verifySupply checks the invariant that the balances of all accounts add up
+// to the total supply. It reads every balance, so it is meant to be queried
+// rather than invoked.
+func (t *TokenChaincode) verifySupply(stub shim.ChaincodeStubInterface, args *router.Args) pb.Response {
+	supply, err := getAmount(stub, totalSupplyKey)
+	if err != nil {
+		return errcode.Response(err)
+	}
+
+	iterator, err := stub.GetStateByPartialCompositeKey(balanceIndex, []string{})
+	if err != nil {
+		return errcode.Internal("Failed to read balances: %s", err).Response()
+	}
+	defer iterator.Close()
+
+	sum := new(big.Int)
+	accounts := 0
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return errcode.Internal("Failed to read balances: %s", err).Response()
+		}
+		balance, err := parseStoredAmount(kv.Key, kv.Value)
+		if err != nil {
+			return errcode.Response(err)
+		}
+		sum.Add(sum, balance)
+		accounts++
+	}
+
+	if sum.Cmp(supply) != 0 {
+		return errcode.Internal("Balances add up to %s but the total supply is %s", sum, supply).
+			With("totalSupply", supply.String()).With("balances", sum.String()).Response()
+	}
+
+	reportJSONasBytes, err := json.Marshal(supplyReport{TotalSupply: supply.String(), Balances: sum.String(), Accounts: accounts})
+	if err != nil {
+		return errcode.Response(err)
+	}
+	return shim.Success(reportJSONasBytes)
+}
+
+// move moves tokens between two accounts and sends a Transfer event. It
+// returns a CONFLICT error if the sender has insufficient funds.
+func move(stub shim.ChaincodeStubInterface, from, to string, amount *big.Int) error {
+	fromBalance, err := getBalance(stub, from)
+	if err != nil {
+		return err
+	}
+	if fromBalance.Cmp(amount) < 0 {
+		return errcode.Conflict("Insufficient funds: balance of %s is %s, less than %s", from, fromBalance, amount).
+			With("balance", fromBalance.String()).With("amount", amount.String())
+	}
+
+	// Writes are not visible to reads in the same transaction, so a transfer
+	// to oneself must not write the credited balance over the debited one
+	if from != to {
+		toBalance, err := getBalance(stub, to)
+		if err != nil {
+			return err
+		}
+		err = putBalance(stub, from, fromBalance.Sub(fromBalance, amount))
+		if err != nil {
+			return err
+		}
+		err = putBalance(stub, to, toBalance.Add(toBalance, amount))
+		if err != nil {
+			return err
+		}
+	}
+
+	return emit(stub, "Transfer", transferEvent{From: from, To: to, Value: amount.String()})
+}
+
+// checkAccount returns an INVALID_ARGUMENT error unless account has the form
+// MSPID::ID
+func checkAccount(account string) error {
+	parts := strings.SplitN(account, "::", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return errcode.InvalidArgument("Account must have the form MSPID::ID, got '%s'", account).With("account", account)
+	}
+	return nil
+}
+
+// parseAmount parses a decimal amount, which must be positive if positive is
+// set and must not be negative otherwise
+func parseAmount(s string, positive bool) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(s, 10)
+	if !ok || amount.Sign() < 0 || positive && amount.Sign() == 0 {
+		return nil, errcode.InvalidArgument("Amount must be a positive integer, got '%s'", s).With("amount", s)
+	}
+	return amount, nil
+}
+
+// parseStoredAmount parses an amount read from state
+func parseStoredAmount(key string, value []byte) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(string(value), 10)
+	if !ok {
+		return nil, errcode.Internal("Invalid amount stored under %q: %s", key, value)
+	}
+	return amount, nil
+}
+
+// getAmount reads an amount from state, which is 0 if the key does not exist
+func getAmount(stub shim.ChaincodeStubInterface, key string) (*big.Int, error) {
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, errcode.Internal("Failed to get state")
+	}
+	if value == nil {
+		return new(big.Int), nil
+	}
+	return parseStoredAmount(key, value)
+}
+
+// putAmount writes an amount to state. Zero balances and allowances are
+// removed rather than stored; the total supply is always stored.
+func putAmount(stub shim.ChaincodeStubInterface, key string, amount *big.Int) error {
+	if amount.Sign() == 0 && key != totalSupplyKey {
+		return stub.DelState(key)
+	}
+	return stub.PutState(key, []byte(amount.String()))
+}
+
+// getBalance returns the balance of an account
+func getBalance(stub shim.ChaincodeStubInterface, account string) (*big.Int, error) {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account})
+	if err != nil {
+		return nil, err
+	}
+	return getAmount(stub, key)
+}
+
+// putBalance sets the balance of an account
+func putBalance(stub shim.ChaincodeStubInterface, account string, balance *big.Int) error {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account})
+	if err != nil {
+		return err
+	}
+	return putAmount(stub, key, balance)
+}
+
+// emit sets the event of the transaction. A transaction has at most one event,
+// so the last one emitted wins.
+func emit(stub shim.ChaincodeStubInterface, name string, event interface{}) error {
+	eventJSONasBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, eventJSONasBytes)
+}
+
+func main() {
+	err := shim.Start(new(TokenChaincode))
+	if err != nil {
+		fmt.Printf("Error starting Token chaincode: %s", err)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-samples/chaincode/lib/cctest"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+var (
+	alice  = cctest.NewClient("Org1MSP", "alice")
+	bob    = cctest.NewClient("Org2MSP", "bob")
+	carol  = cctest.NewClient("Org2MSP", "carol")
+	dave   = cctest.NewClient("Org3MSP", "dave")
+	minter = cctest.NewClientWith("Org3MSP", "minter", cctest.CertOptions{Attrs: map[string]string{"token.minter": "true"}})
+	forger = cctest.NewClientWith("Org2MSP", "forger", cctest.CertOptions{Attrs: map[string]string{"token.minter": "true"}})
+)
+
+// newStub returns a stub for a token instantiated by alice, who is its first
+// minter, where Org3MSP is the minter MSP
+func newStub(t *testing.T) *cctest.Stub {
+	stub := cctest.NewStub("token", new(TokenChaincode))
+	if res := stub.As(alice).Init("init", "Sample Token", "SMP", "2", `["Org3MSP"]`); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	return stub
+}
+
+func balanceOf(t *testing.T, stub *cctest.Stub, client *cctest.Client) string {
+	return string(cctest.Invoke(t, stub, "balanceOf", client.Member()))
+}
+
+// assertBalances checks the balance of each client
+func assertBalances(t *testing.T, stub *cctest.Stub, balances map[*cctest.Client]string) {
+	for client, want := range balances {
+		if got := balanceOf(t, stub, client); got != want {
+			t.Errorf("the balance of %s is %s, want %s", client.Member(), got, want)
+		}
+	}
+}
+
+// assertSupply checks that the balances add up to the total supply, both
+// with verifySupply and by summing the balances found in the state, and that
+// the total supply is want
+func assertSupply(t *testing.T, stub *cctest.Stub, want string) {
+	t.Helper()
+	report := supplyReport{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "verifySupply"), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := string([]byte{0}) + balanceIndex + string([]byte{0})
+	sum := new(big.Int)
+	accounts := 0
+	for key, value := range stub.State {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		balance, ok := new(big.Int).SetString(string(value), 10)
+		if !ok || balance.Sign() <= 0 {
+			t.Errorf("%q holds the balance %q", key, value)
+		}
+		sum.Add(sum, balance)
+		accounts++
+	}
+	supply := string(stub.State[totalSupplyKey])
+
+	if supply != want || sum.String() != want || report.TotalSupply != want || report.Balances != want || report.Accounts != accounts {
+		t.Errorf("total supply %s, balances in state %s over %d accounts, verifySupply %+v; want %s", supply, sum, accounts, report, want)
+	}
+}
+
+func TestInit(t *testing.T) {
+	stub := cctest.NewStub("token", new(TokenChaincode)).As(alice)
+	for _, args := range [][]string{
+		{"init", "Sample Token", "SMP"},
+		{"init", "", "SMP", "2"},
+		{"init", "Sample Token", "SMP", "19"},
+		{"init", "Sample Token", "SMP", "two"},
+		{"init", "Sample Token", "SMP", "2", "Org3MSP"},
+		{"init", "Sample Token", "SMP", "2", `["Org3MSP",""]`},
+	} {
+		cctest.AssertCode(t, stub.Init(args...), errcode.CodeInvalidArgument, "Init "+strings.Join(args[1:], ","))
+	}
+
+	stub = newStub(t)
+	if res := stub.As(bob).Init("init", "Other", "OTH", "0"); res.Status != shim.OK {
+		t.Fatalf("the upgrade failed: %s", res.Message)
+	}
+	metadata := tokenMetadata{}
+	if err := json.Unmarshal(cctest.Invoke(t, stub, "metadata"), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != "Sample Token" || metadata.Symbol != "SMP" || metadata.Decimals != 2 || len(metadata.MinterMSPIDs) != 1 {
+		t.Errorf("the upgrade changed the metadata to %+v", metadata)
+	}
+	cctest.AssertCode(t, stub.As(bob).Invoke("mint", bob.Member(), "1"), errcode.CodeUnauthorized, "a mint by the upgrader")
+	assertSupply(t, stub, "0")
+}
+
+func TestMintAndBurn(t *testing.T) {
+	stub := newStub(t)
+
+	cctest.Invoke(t, stub.As(alice), "mint", alice.Member(), "1000")
+	cctest.Invoke(t, stub, "mint", bob.Member(), "500")
+	cctest.Invoke(t, stub.As(minter), "mint", dave.Member(), "250")
+	assertSupply(t, stub, "1750")
+	events := stub.Events()
+	if len(events) != 3 || events[0].EventName != "Transfer" || !strings.Contains(string(events[0].Payload), `"from":""`) {
+		t.Errorf("mint emitted %v", events)
+	}
+
+	cctest.AssertCode(t, stub.As(bob).Invoke("mint", bob.Member(), "1"), errcode.CodeUnauthorized, "a mint by a client who is not a minter")
+	cctest.AssertCode(t, stub.As(bob).Invoke("burn", "1"), errcode.CodeUnauthorized, "a burn by a client who is not a minter")
+	cctest.AssertCode(t, stub.As(alice).Invoke("mint", "bob", "1"), errcode.CodeInvalidArgument, "a mint to an invalid account")
+	cctest.AssertCode(t, stub.Invoke("mint", bob.Member(), "0"), errcode.CodeInvalidArgument, "a mint of nothing")
+	cctest.AssertCode(t, stub.Invoke("mint", bob.Member(), "-1"), errcode.CodeInvalidArgument, "a negative mint")
+	cctest.AssertCode(t, stub.Invoke("burn", "1001"), errcode.CodeConflict, "a burn above the balance")
+	assertSupply(t, stub, "1750")
+
+	cctest.Invoke(t, stub.As(alice), "burn", "400")
+	cctest.AssertCode(t, stub.As(minter).Invoke("burn", "0"), errcode.CodeInvalidArgument, "a burn of nothing")
+	cctest.AssertCode(t, stub.As(minter).Invoke("burn", "1"), errcode.CodeConflict, "a burn from an empty account")
+	assertSupply(t, stub, "1350")
+}
+
+func TestOnlyMinterMSPsIssueTheMinterAttribute(t *testing.T) {
+	stub := newStub(t)
+	cctest.AssertCode(t, stub.As(forger).Invoke("mint", forger.Member(), "1"), errcode.CodeUnauthorized, "a mint with the attribute from another MSP")
+
+	stub = cctest.NewStub("token", new(TokenChaincode))
+	if res := stub.As(alice).Init("init", "Sample Token", "SMP", "2"); res.Status != shim.OK {
+		t.Fatalf("Init failed: %s", res.Message)
+	}
+	cctest.AssertCode(t, stub.As(minter).Invoke("mint", minter.Member(), "1"), errcode.CodeUnauthorized, "a mint with the attribute without minter MSPs")
+	cctest.Invoke(t, stub.As(alice), "mint", minter.Member(), "1")
+	assertSupply(t, stub, "1")
+}
+
+func TestTransfers(t *testing.T) {
+	stub := newStub(t)
+	cctest.Invoke(t, stub.As(alice), "mint", alice.Member(), "1000")
+
+	cctest.Invoke(t, stub, "transfer", bob.Member(), "300")
+	assertBalances(t, stub, map[*cctest.Client]string{alice: "700", bob: "300"})
+	assertSupply(t, stub, "1000")
+
+	// A transfer to oneself leaves the balance as it is
+	cctest.Invoke(t, stub, "transfer", alice.Member(), "700")
+	assertBalances(t, stub, map[*cctest.Client]string{alice: "700"})
+	assertSupply(t, stub, "1000")
+
+	cctest.AssertCode(t, stub.Invoke("transfer", bob.Member(), "701"), errcode.CodeConflict, "a transfer above the balance")
+	cctest.AssertCode(t, stub.Invoke("transfer", alice.Member(), "701"), errcode.CodeConflict, "a transfer to oneself above the balance")
+	cctest.AssertCode(t, stub.As(dave).Invoke("transfer", bob.Member(), "1"), errcode.CodeConflict, "a transfer from an empty account")
+	cctest.AssertCode(t, stub.Invoke("transfer", bob.Member(), "1.5"), errcode.CodeInvalidArgument, "a fractional transfer")
+	cctest.AssertCode(t, stub.Invoke("transfer", "Org2MSP::", "1"), errcode.CodeInvalidArgument, "a transfer to an invalid account")
+	assertSupply(t, stub, "1000")
+
+	// Emptied accounts are removed from the state
+	cctest.Invoke(t, stub.As(bob), "transfer", dave.Member(), "300")
+	if bal := balanceOf(t, stub, bob); bal != "0" {
+		t.Errorf("the balance of bob is %s", bal)
+	}
+	assertSupply(t, stub, "1000")
+}
+
+func TestTransferFrom(t *testing.T) {
+	stub := newStub(t)
+	cctest.Invoke(t, stub.As(alice), "mint", alice.Member(), "1000")
+
+	cctest.Invoke(t, stub, "approve", carol.Member(), "200")
+	if allowance := string(cctest.Invoke(t, stub, "allowance", alice.Member(), carol.Member())); allowance != "200" {
+		t.Errorf("the allowance is %s", allowance)
+	}
+	cctest.Invoke(t, stub.As(carol), "transferFrom", alice.Member(), dave.Member(), "150")
+	assertBalances(t, stub, map[*cctest.Client]string{alice: "850", dave: "150", carol: "0"})
+	assertSupply(t, stub, "1000")
+
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", alice.Member(), dave.Member(), "51"), errcode.CodeUnauthorized, "a transfer above the allowance")
+	cctest.AssertCode(t, stub.As(bob).Invoke("transferFrom", alice.Member(), bob.Member(), "1"), errcode.CodeUnauthorized, "a transfer without allowance")
+	if allowance := string(cctest.Invoke(t, stub, "allowance", alice.Member(), carol.Member())); allowance != "50" {
+		t.Errorf("the allowance is %s after a failed transfer", allowance)
+	}
+
+	// A failed move leaves the allowance as it was
+	cctest.Invoke(t, stub.As(alice), "approve", carol.Member(), "5000")
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", alice.Member(), dave.Member(), "851"), errcode.CodeConflict, "a transfer above the balance")
+	if allowance := string(cctest.Invoke(t, stub, "allowance", alice.Member(), carol.Member())); allowance != "5000" {
+		t.Errorf("the allowance is %s after a failed transfer", allowance)
+	}
+
+	// Moving tokens back to their owner spends the allowance but no tokens
+	cctest.Invoke(t, stub.As(carol), "transferFrom", alice.Member(), alice.Member(), "850")
+	assertBalances(t, stub, map[*cctest.Client]string{alice: "850"})
+	if allowance := string(cctest.Invoke(t, stub, "allowance", alice.Member(), carol.Member())); allowance != "4150" {
+		t.Errorf("the allowance is %s", allowance)
+	}
+	assertSupply(t, stub, "1000")
+
+	cctest.Invoke(t, stub.As(alice), "approve", carol.Member(), "0")
+	cctest.AssertCode(t, stub.As(carol).Invoke("transferFrom", alice.Member(), dave.Member(), "1"), errcode.CodeUnauthorized, "a transfer after the allowance was withdrawn")
+	assertSupply(t, stub, "1000")
+}
+
+// TestSupplyInvariant runs a random sequence of operations, many of which
+// fail, and checks after each that the balances add up to the total supply
+// and match a model of the accounts
+func TestSupplyInvariant(t *testing.T) {
+	stub := newStub(t)
+	clients := []*cctest.Client{alice, bob, carol, dave}
+	balances := map[*cctest.Client]int{}
+	allowances := map[[2]*cctest.Client]int{}
+	supply := 0
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 300; i++ {
+		from, to := clients[r.Intn(len(clients))], clients[r.Intn(len(clients))]
+		amount := r.Intn(400) + 1
+		value := strconv.Itoa(amount)
+
+		var res pb.Response
+		ok := false
+		switch r.Intn(5) {
+		case 0:
+			res = stub.As(alice).Invoke("mint", to.Member(), value)
+			ok = true
+			balances[to] += amount
+			supply += amount
+		case 1:
+			res = stub.As(from).Invoke("burn", value)
+			ok = from == alice && balances[from] >= amount
+			if ok {
+				balances[from] -= amount
+				supply -= amount
+			}
+		case 2:
+			res = stub.As(from).Invoke("transfer", to.Member(), value)
+			ok = balances[from] >= amount
+			if ok {
+				balances[from] -= amount
+				balances[to] += amount
+			}
+		case 3:
+			res = stub.As(from).Invoke("approve", to.Member(), value)
+			ok = true
+			allowances[[2]*cctest.Client{from, to}] = amount
+		case 4:
+			spender := clients[r.Intn(len(clients))]
+			res = stub.As(spender).Invoke("transferFrom", from.Member(), to.Member(), value)
+			allowance := allowances[[2]*cctest.Client{from, spender}]
+			ok = allowance >= amount && balances[from] >= amount
+			if ok {
+				allowances[[2]*cctest.Client{from, spender}] -= amount
+				balances[from] -= amount
+				balances[to] += amount
+			}
+		}
+		// MockStub buffers at most 100 events
+		stub.Events()
+		if (res.Status == shim.OK) != ok {
+			t.Fatalf("step %d: got status %d (%s), want success %v", i, res.Status, res.Message, ok)
+		}
+
+		assertSupply(t, stub, strconv.Itoa(supply))
+		for _, client := range clients {
+			if got := balanceOf(t, stub, client); got != strconv.Itoa(balances[client]) {
+				t.Fatalf("step %d: the balance of %s is %s, want %d", i, client.Member(), got, balances[client])
+			}
+		}
+	}
+}
@@ -24,11 +24,14 @@ package main
 /* Imports
  * 4 utility libraries for formatting, handling bytes, reading and writing JSON, and string manipulation
  * 2 specific Hyperledger Fabric specific libraries for Smart Contracts
+ * 2 libraries shared by the sample chaincodes for routing function calls and reporting errors
  */
 import (
 	"fmt"
 	"strconv"
 
+	"github.com/hyperledger/fabric-samples/chaincode/lib/errcode"
+	"github.com/hyperledger/fabric-samples/chaincode/lib/router"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	sc "github.com/hyperledger/fabric/protos/peer"
 )
@@ -37,12 +40,6 @@ import (
 type SmartContract struct {
 }
 
-// Define Status codes for the response
-const (
-	OK    = 200
-	ERROR = 500
-)
-
 // Init is called when the smart contract is instantiated
 func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
 	return shim.Success(nil)
@@ -55,109 +52,142 @@ func (s *SmartContract) Init(APIstub shim.ChaincodeStubInterface) sc.Response {
 //	- pruneFast, deletes all rows associated with the variable and replaces them with a single row containing the aggregate value
 //	- pruneSafe, same as pruneFast except it pre-computed the value and backs it up before performing any destructive operations
 //	- delete, removes all rows associated with the variable
+//	- describe, lists the supported invocations and their arguments
 func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response {
-	// Retrieve the requested Smart Contract function and arguments
-	function, args := APIstub.GetFunctionAndParameters()
-
 	// Route to the appropriate handler function to interact with the ledger appropriately
-	if function == "update" {
-		return s.update(APIstub, args)
-	} else if function == "get" {
-		return s.get(APIstub, args)
-	} else if function == "prunefast" {
-		return s.pruneFast(APIstub, args)
-	} else if function == "prunesafe" {
-		return s.pruneSafe(APIstub, args)
-	} else if function == "delete" {
-		return s.delete(APIstub, args)
-	} else if function == "putstandard" {
-		return s.putStandard(APIstub, args)
-	} else if function == "getstandard" {
-		return s.getStandard(APIstub, args)
-	}
+	return routes.Handle(APIstub)
+}
 
-	return shim.Error("Invalid Smart Contract function name.")
+/**
+ * Lists the supported invocations and their arguments. The router checks the number and type of the
+ * arguments before the handler function is called, which receives them parsed. It is built once, the
+ * contract keeps no state of its own.
+ */
+var routes = new(SmartContract).buildRoutes()
+
+/**
+ * Builds the router of the contract
+ *
+ * @return The router for this contract
+ */
+func (s *SmartContract) buildRoutes() *router.Router {
+	name := router.Required("name", router.String)
+	return router.New("high-throughput", "1.0").
+		Add("update", "Adds a delta to a variable", s.update,
+			name,
+			router.Required("delta", router.Float),
+			router.Required("op", router.String).Describe("+ or -")).
+		Add("get", "Returns the aggregate value of a variable", s.get, name).
+		Add("prunefast", "Replaces the delta rows of a variable by their aggregate", s.pruneFast, name).
+		Add("prunesafe", "Replaces the delta rows of a variable by their aggregate, keeping a backup", s.pruneSafe, name).
+		Add("delete", "Removes all rows of a variable", s.delete, name).
+		Add("putstandard", "Stores a value in a single row", s.putStandard,
+			name,
+			router.Required("value", router.String)).
+		Add("getstandard", "Returns a value stored in a single row", s.getStandard, name)
 }
 
 /**
  * Updates the ledger to include a new delta for a particular variable. If this is the first time
  * this variable is being added to the ledger, then its initial value is assumed to be 0. The arguments
- * to give in the args array are as follows:
- *	- args[0] -> name of the variable
- *	- args[1] -> new delta (float)
- *	- args[2] -> operation (currently supported are addition "+" and subtraction "-")
+ * of the update invocation are as follows:
+ *	- name -> name of the variable
+ *	- delta -> new delta (float)
+ *	- op -> operation (currently supported are addition "+" and subtraction "-")
  *
  * @param APIstub The chaincode shim
- * @param args The arguments array for the update invocation
+ * @param args The parsed arguments of the update invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (s *SmartContract) update(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	// Check we have a valid number of args
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments, expecting 3")
-	}
-
+func (s *SmartContract) update(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 	// Extract the args
-	name := args[0]
-	op := args[2]
-	_, err := strconv.ParseFloat(args[1], 64)
-	if err != nil {
-		return shim.Error("Provided value was not a number")
-	}
+	name := args.String("name")
+	delta := strconv.FormatFloat(args.Float("delta"), 'f', -1, 64)
+	op := args.String("op")
 
 	// Make sure a valid operator is provided
 	if op != "+" && op != "-" {
-		return shim.Error(fmt.Sprintf("Operator %s is unrecognized", op))
+		return errcode.InvalidArgument("Operator %s is unrecognized", op).Response()
+	}
+
+	err := addDelta(APIstub, name, delta, op)
+	if err != nil {
+		return errcode.Response(err)
 	}
 
+	return shim.Success([]byte(fmt.Sprintf("Successfully added %s%s to %s", op, delta, name)))
+}
+
+/**
+ * Adds a delta row for a variable to the ledger
+ *
+ * @param APIstub The chaincode shim
+ * @param name The name of the variable
+ * @param delta The delta, formatted as a number
+ * @param op The operation, "+" or "-"
+ *
+ * @return An error if the row could not be written
+ */
+func addDelta(APIstub shim.ChaincodeStubInterface, name, delta, op string) error {
 	// Retrieve info needed for the update procedure
 	txid := APIstub.GetTxID()
 	compositeIndexName := "varName~op~value~txID"
 
 	// Create the composite key that will allow us to query for all deltas on a particular variable
-	compositeKey, compositeErr := APIstub.CreateCompositeKey(compositeIndexName, []string{name, op, args[1], txid})
+	compositeKey, compositeErr := APIstub.CreateCompositeKey(compositeIndexName, []string{name, op, delta, txid})
 	if compositeErr != nil {
-		return shim.Error(fmt.Sprintf("Could not create a composite key for %s: %s", name, compositeErr.Error()))
+		return errcode.Internal("Could not create a composite key for %s: %s", name, compositeErr.Error())
 	}
 
 	// Save the composite key index
 	compositePutErr := APIstub.PutState(compositeKey, []byte{0x00})
 	if compositePutErr != nil {
-		return shim.Error(fmt.Sprintf("Could not put operation for %s in the ledger: %s", name, compositePutErr.Error()))
+		return errcode.Internal("Could not put operation for %s in the ledger: %s", name, compositePutErr.Error())
 	}
 
-	return shim.Success([]byte(fmt.Sprintf("Successfully added %s%s to %s", op, args[1], name)))
+	return nil
 }
 
 /**
  * Retrieves the aggregate value of a variable in the ledger. Gets all delta rows for the variable
- * and computes the final value from all deltas. The args array for the invocation must contain the
+ * and computes the final value from all deltas. The invocation takes the
  * following argument:
- *	- args[0] -> The name of the variable to get the value of
+ *	- name -> The name of the variable to get the value of
  *
  * @param APIstub The chaincode shim
- * @param args The arguments array for the get invocation
+ * @param args The parsed arguments of the get invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	// Check we have a valid number of args
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments, expecting 1")
+func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
+	finalVal, err := getValue(APIstub, args.String("name"))
+	if err != nil {
+		return errcode.Response(err)
 	}
 
-	name := args[0]
+	return shim.Success(f2barr(finalVal))
+}
+
+/**
+ * Computes the aggregate value of a variable from all of its delta rows
+ *
+ * @param APIstub The chaincode shim
+ * @param name The name of the variable
+ *
+ * @return The value, or a NOT_FOUND error if the variable has no rows
+ */
+func getValue(APIstub shim.ChaincodeStubInterface, name string) (float64, error) {
 	// Get all deltas for the variable
 	deltaResultsIterator, deltaErr := APIstub.GetStateByPartialCompositeKey("varName~op~value~txID", []string{name})
 	if deltaErr != nil {
-		return shim.Error(fmt.Sprintf("Could not retrieve value for %s: %s", name, deltaErr.Error()))
+		return 0, errcode.Internal("Could not retrieve value for %s: %s", name, deltaErr.Error())
 	}
 	defer deltaResultsIterator.Close()
 
 	// Check the variable existed
 	if !deltaResultsIterator.HasNext() {
-		return shim.Error(fmt.Sprintf("No variable by the name %s exists", name))
+		return 0, errcode.NotFound("No variable by the name %s exists", name).With("name", name)
 	}
 
 	// Iterate through result set and compute final value
@@ -167,13 +197,13 @@ func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args []string)
 		// Get the next row
 		responseRange, nextErr := deltaResultsIterator.Next()
 		if nextErr != nil {
-			return shim.Error(nextErr.Error())
+			return 0, nextErr
 		}
 
 		// Split the composite key into its component parts
 		_, keyParts, splitKeyErr := APIstub.SplitCompositeKey(responseRange.Key)
 		if splitKeyErr != nil {
-			return shim.Error(splitKeyErr.Error())
+			return 0, splitKeyErr
 		}
 
 		// Retrieve the delta value and operation
@@ -183,7 +213,7 @@ func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args []string)
 		// Convert the value string and perform the operation
 		value, convErr := strconv.ParseFloat(valueStr, 64)
 		if convErr != nil {
-			return shim.Error(convErr.Error())
+			return 0, convErr
 		}
 
 		switch operation {
@@ -192,11 +222,11 @@ func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args []string)
 		case "-":
 			finalVal -= value
 		default:
-			return shim.Error(fmt.Sprintf("Unrecognized operation %s", operation))
+			return 0, errcode.Internal("Unrecognized operation %s", operation)
 		}
 	}
 
-	return shim.Success([]byte(strconv.FormatFloat(finalVal, 'f', -1, 64)))
+	return finalVal, nil
 }
 
 /**
@@ -204,33 +234,28 @@ func (s *SmartContract) get(APIstub shim.ChaincodeStubInterface, args []string)
  * have been processed and deleted, a single new row is added which defines a delta containing the final
  * computed value of the variable. This function is NOT safe as any failures or errors during pruning
  * will result in an undefined final value for the variable and loss of data. Use pruneSafe if data
- * integrity is important. The args array contains the following argument:
- *	- args[0] -> The name of the variable to prune
+ * integrity is important. The invocation takes the following argument:
+ *	- name -> The name of the variable to prune
  *
  * @param APIstub The chaincode shim
- * @param args The args array for the pruneFast invocation
+ * @param args The parsed arguments of the pruneFast invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (s *SmartContract) pruneFast(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	// Check we have a valid number of ars
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments, expecting 1")
-	}
-
+func (s *SmartContract) pruneFast(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 	// Retrieve the name of the variable to prune
-	name := args[0]
+	name := args.String("name")
 
 	// Get all delta rows for the variable
 	deltaResultsIterator, deltaErr := APIstub.GetStateByPartialCompositeKey("varName~op~value~txID", []string{name})
 	if deltaErr != nil {
-		return shim.Error(fmt.Sprintf("Could not retrieve value for %s: %s", name, deltaErr.Error()))
+		return errcode.Internal("Could not retrieve value for %s: %s", name, deltaErr.Error()).Response()
 	}
 	defer deltaResultsIterator.Close()
 
 	// Check the variable existed
 	if !deltaResultsIterator.HasNext() {
-		return shim.Error(fmt.Sprintf("No variable by the name %s exists", name))
+		return errcode.NotFound("No variable by the name %s exists", name).With("name", name).Response()
 	}
 
 	// Iterate through result set computing final value while iterating and deleting each key
@@ -240,13 +265,13 @@ func (s *SmartContract) pruneFast(APIstub shim.ChaincodeStubInterface, args []st
 		// Get the next row
 		responseRange, nextErr := deltaResultsIterator.Next()
 		if nextErr != nil {
-			return shim.Error(nextErr.Error())
+			return errcode.Response(nextErr)
 		}
 
 		// Split the key into its composite parts
 		_, keyParts, splitKeyErr := APIstub.SplitCompositeKey(responseRange.Key)
 		if splitKeyErr != nil {
-			return shim.Error(splitKeyErr.Error())
+			return errcode.Response(splitKeyErr)
 		}
 
 		// Retrieve the operation and value
@@ -256,13 +281,13 @@ func (s *SmartContract) pruneFast(APIstub shim.ChaincodeStubInterface, args []st
 		// Convert the value to a float
 		value, convErr := strconv.ParseFloat(valueStr, 64)
 		if convErr != nil {
-			return shim.Error(convErr.Error())
+			return errcode.Response(convErr)
 		}
 
 		// Delete the row from the ledger
 		deltaRowDelErr := APIstub.DelState(responseRange.Key)
 		if deltaRowDelErr != nil {
-			return shim.Error(fmt.Sprintf("Could not delete delta row: %s", deltaRowDelErr.Error()))
+			return errcode.Internal("Could not delete delta row: %s", deltaRowDelErr.Error()).Response()
 		}
 
 		// Add the value of the deleted row to the final aggregate
@@ -272,62 +297,52 @@ func (s *SmartContract) pruneFast(APIstub shim.ChaincodeStubInterface, args []st
 		case "-":
 			finalVal -= value
 		default:
-			return shim.Error(fmt.Sprintf("Unrecognized operation %s", operation))
+			return errcode.Internal("Unrecognized operation %s", operation).Response()
 		}
 	}
 
 	// Update the ledger with the final value and return
-	updateResp := s.update(APIstub, []string{name, strconv.FormatFloat(finalVal, 'f', -1, 64), "+"})
-	if updateResp.Status == OK {
-		return shim.Success([]byte(fmt.Sprintf("Successfully pruned variable %s, final value is %f, %d rows pruned", args[0], finalVal, i)))
+	updateErr := addDelta(APIstub, name, strconv.FormatFloat(finalVal, 'f', -1, 64), "+")
+	if updateErr == nil {
+		return shim.Success([]byte(fmt.Sprintf("Successfully pruned variable %s, final value is %f, %d rows pruned", name, finalVal, i)))
 	}
 
-	return shim.Error(fmt.Sprintf("Failed to prune variable: all rows deleted but could not update value to %f, variable no longer exists in ledger", finalVal))
+	return errcode.Internal("Failed to prune variable: all rows deleted but could not update value to %f, variable no longer exists in ledger", finalVal).Response()
 }
 
 /**
  * This function performs the same function as pruneFast except it provides data backups in case the
  * prune fails. The final aggregate value is computed before any deletion occurs and is backed up
  * to a new row. This back-up row is deleted only after the new aggregate delta has been successfully
- * written to the ledger. The args array contains the following argument:
- *	args[0] -> The name of the variable to prune
+ * written to the ledger. The invocation takes the following argument:
+ *	name -> The name of the variable to prune
  *
  * @param APIstub The chaincode shim
- * @param args The arguments array for the pruneSafe invocation
+ * @param args The parsed arguments of the pruneSafe invocation
  *
  * @result A response structure indicating success or failure with a message
  */
-func (s *SmartContract) pruneSafe(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	// Verify there are a correct number of arguments
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments, expecting 1 (the name of the variable to prune)")
-	}
-
+func (s *SmartContract) pruneSafe(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 	// Get the var name
-	name := args[0]
+	name := args.String("name")
 
 	// Get the var's value and process it
-	getResp := s.get(APIstub, args)
-	if getResp.Status == ERROR {
-		return shim.Error(fmt.Sprintf("Could not retrieve the value of %s before pruning, pruning aborted: %s", name, getResp.Message))
-	}
-
-	valueStr := string(getResp.Payload)
-	val, convErr := strconv.ParseFloat(valueStr, 64)
-	if convErr != nil {
-		return shim.Error(fmt.Sprintf("Could not convert the value of %s to a number before pruning, pruning aborted: %s", name, convErr.Error()))
+	val, getErr := getValue(APIstub, name)
+	if getErr != nil {
+		return errcode.Wrap(getErr, "Could not retrieve the value of %s before pruning, pruning aborted", name).Response()
 	}
+	valueStr := string(f2barr(val))
 
 	// Store the var's value temporarily
 	backupPutErr := APIstub.PutState(fmt.Sprintf("%s_PRUNE_BACKUP", name), []byte(valueStr))
 	if backupPutErr != nil {
-		return shim.Error(fmt.Sprintf("Could not backup the value of %s before pruning, pruning aborted: %s", name, backupPutErr.Error()))
+		return errcode.Internal("Could not backup the value of %s before pruning, pruning aborted: %s", name, backupPutErr.Error()).Response()
 	}
 
 	// Get all deltas for the variable
 	deltaResultsIterator, deltaErr := APIstub.GetStateByPartialCompositeKey("varName~op~value~txID", []string{name})
 	if deltaErr != nil {
-		return shim.Error(fmt.Sprintf("Could not retrieve value for %s: %s", name, deltaErr.Error()))
+		return errcode.Internal("Could not retrieve value for %s: %s", name, deltaErr.Error()).Response()
 	}
 	defer deltaResultsIterator.Close()
 
@@ -336,59 +351,55 @@ func (s *SmartContract) pruneSafe(APIstub shim.ChaincodeStubInterface, args []st
 	for i = 0; deltaResultsIterator.HasNext(); i++ {
 		responseRange, nextErr := deltaResultsIterator.Next()
 		if nextErr != nil {
-			return shim.Error(fmt.Sprintf("Could not retrieve next row for pruning: %s", nextErr.Error()))
+			return errcode.Internal("Could not retrieve next row for pruning: %s", nextErr.Error()).Response()
 		}
 
 		deltaRowDelErr := APIstub.DelState(responseRange.Key)
 		if deltaRowDelErr != nil {
-			return shim.Error(fmt.Sprintf("Could not delete delta row: %s", deltaRowDelErr.Error()))
+			return errcode.Internal("Could not delete delta row: %s", deltaRowDelErr.Error()).Response()
 		}
 	}
 
 	// Insert new row for the final value
-	updateResp := s.update(APIstub, []string{name, valueStr, "+"})
-	if updateResp.Status == ERROR {
-		return shim.Error(fmt.Sprintf("Could not insert the final value of the variable after pruning, variable backup is stored in %s_PRUNE_BACKUP: %s", name, updateResp.Message))
+	updateErr := addDelta(APIstub, name, valueStr, "+")
+	if updateErr != nil {
+		return errcode.Wrap(updateErr, "Could not insert the final value of the variable after pruning, variable backup is stored in %s_PRUNE_BACKUP", name).
+			With("backup", name+"_PRUNE_BACKUP").Response()
 	}
 
 	// Delete the backup value
 	delErr := APIstub.DelState(fmt.Sprintf("%s_PRUNE_BACKUP", name))
 	if delErr != nil {
-		return shim.Error(fmt.Sprintf("Could not delete backup value %s_PRUNE_BACKUP, this does not affect the ledger but should be removed manually", name))
+		return errcode.Internal("Could not delete backup value %s_PRUNE_BACKUP, this does not affect the ledger but should be removed manually", name).Response()
 	}
 
 	return shim.Success([]byte(fmt.Sprintf("Successfully pruned variable %s, final value is %f, %d rows pruned", name, val, i)))
 }
 
 /**
- * Deletes all rows associated with an aggregate variable from the ledger. The args array
- * contains the following argument:
- *	- args[0] -> The name of the variable to delete
+ * Deletes all rows associated with an aggregate variable from the ledger. The invocation
+ * takes the following argument:
+ *	- name -> The name of the variable to delete
  *
  * @param APIstub The chaincode shim
- * @param args The arguments array for the delete invocation
+ * @param args The parsed arguments of the delete invocation
  *
  * @return A response structure indicating success or failure with a message
  */
-func (s *SmartContract) delete(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	// Check there are a correct number of arguments
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments, expecting 1")
-	}
-
+func (s *SmartContract) delete(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
 	// Retrieve the variable name
-	name := args[0]
+	name := args.String("name")
 
 	// Delete all delta rows
 	deltaResultsIterator, deltaErr := APIstub.GetStateByPartialCompositeKey("varName~op~value~txID", []string{name})
 	if deltaErr != nil {
-		return shim.Error(fmt.Sprintf("Could not retrieve delta rows for %s: %s", name, deltaErr.Error()))
+		return errcode.Internal("Could not retrieve delta rows for %s: %s", name, deltaErr.Error()).Response()
 	}
 	defer deltaResultsIterator.Close()
 
 	// Ensure the variable exists
 	if !deltaResultsIterator.HasNext() {
-		return shim.Error(fmt.Sprintf("No variable by the name %s exists", name))
+		return errcode.NotFound("No variable by the name %s exists", name).With("name", name).Response()
 	}
 
 	// Iterate through result set and delete all indices
@@ -396,12 +407,12 @@ func (s *SmartContract) delete(APIstub shim.ChaincodeStubInterface, args []strin
 	for i = 0; deltaResultsIterator.HasNext(); i++ {
 		responseRange, nextErr := deltaResultsIterator.Next()
 		if nextErr != nil {
-			return shim.Error(fmt.Sprintf("Could not retrieve next delta row: %s", nextErr.Error()))
+			return errcode.Internal("Could not retrieve next delta row: %s", nextErr.Error()).Response()
 		}
 
 		deltaRowDelErr := APIstub.DelState(responseRange.Key)
 		if deltaRowDelErr != nil {
-			return shim.Error(fmt.Sprintf("Could not delete delta row: %s", deltaRowDelErr.Error()))
+			return errcode.Internal("Could not delete delta row: %s", deltaRowDelErr.Error()).Response()
 		}
 	}
 
@@ -434,29 +445,29 @@ func main() {
 /**
  * All functions below this are for testing traditional editing of a single row
  */
-func (s *SmartContract) putStandard(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	name := args[0]
-	valStr := args[1]
+func (s *SmartContract) putStandard(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
+	name := args.String("name")
+	valStr := args.String("value")
 
 	_, getErr := APIstub.GetState(name)
 	if getErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to retrieve the statr of %s: %s", name, getErr.Error()))
+		return errcode.Internal("Failed to retrieve the statr of %s: %s", name, getErr.Error()).Response()
 	}
 
 	putErr := APIstub.PutState(name, []byte(valStr))
 	if putErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to put state: %s", putErr.Error()))
+		return errcode.Internal("Failed to put state: %s", putErr.Error()).Response()
 	}
 
 	return shim.Success(nil)
 }
 
-func (s *SmartContract) getStandard(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	name := args[0]
+func (s *SmartContract) getStandard(APIstub shim.ChaincodeStubInterface, args *router.Args) sc.Response {
+	name := args.String("name")
 
 	val, getErr := APIstub.GetState(name)
 	if getErr != nil {
-		return shim.Error(fmt.Sprintf("Failed to get state: %s", getErr.Error()))
+		return errcode.Internal("Failed to get state: %s", getErr.Error()).Response()
 	}
 
 	return shim.Success(val)